@@ -1,4 +1,16 @@
 OAUTH_CLIENT_ID=
 OAUTH_CLIENT_SECRET=
+OAUTH_REDIRECT_URL=
+AUTH_PROVIDER=github
+AUTH_GITHUB_ORGS=
+AUTH_GITHUB_TEAMS=
+AUTH_DEV_ENABLED=false
+AUTH_DEV_USERS=
 JWT_SIGNING_KEY=testing
+JWT_EXPIRY=1h
+JWT_REFRESH_EXPIRY=168h
 DB_CONNECTION=sqlite:///path/to/db.db
+TRUSTED_PROXIES=
+AUTH_DEFAULT_ROLE=worker
+AUTH_REQUESTERS=
+AUTH_ALLOWED_USERS=
@@ -34,6 +34,8 @@
 //   ],
 //   ...
 // ]
+// The input is read as a stream, so it can be bigger than the available
+// memory. Malformed pairs are reported with their index and skipped.
 
 // The output DB will contain a single table with all the fields as columns,
 // except for "bag":
@@ -45,13 +47,15 @@
 package main
 
 import (
+	"bufio"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 
+	"github.com/src-d/code-annotation/server/dbutil"
+
 	"github.com/jessevdk/go-flags"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -91,10 +95,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	source, err := ioutil.ReadFile(opts.Args.Input)
+	source, err := os.Open(opts.Args.Input)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer source.Close()
 
 	destDB, err := sql.Open("sqlite3", opts.Args.Output)
 	if err != nil {
@@ -107,11 +112,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	var data [][3]interface{}
-	if err := json.Unmarshal(source, &data); err != nil {
-		log.Fatal(err)
-	}
-
 	tx, err := destDB.Begin()
 	if err != nil {
 		log.Fatal(err)
@@ -124,18 +124,33 @@ func main() {
 
 	var success, failures int64
 
-	for _, pair := range data {
-		fileA := pair[0].(map[string]interface{})
-		fileB := pair[1].(map[string]interface{})
+	decoder := dbutil.NewPairsDecoder(bufio.NewReader(source))
+	for {
+		pair, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if _, ok := err.(*dbutil.MalformedPairError); ok {
+			failures++
+			log.Println(err)
+			continue
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
 
+		fileA, fileB := pair.A, pair.B
 		res, err := insert.Exec(
-			fileA["blob_id"], fileA["repository_id"], fileA["commit_hash"], fileA["path"], fileA["content"],
-			fileB["blob_id"], fileB["repository_id"], fileB["commit_hash"], fileB["path"], fileB["content"],
-			pair[2].(float64))
+			fileA.BlobID, fileA.RepositoryID, fileA.CommitHash, fileA.Path, fileA.Content,
+			fileB.BlobID, fileB.RepositoryID, fileB.CommitHash, fileB.Path, fileB.Content,
+			pair.Score)
 
 		if err != nil {
 			failures++
 			log.Println(err)
+			continue
 		}
 
 		rowsAffected, _ := res.RowsAffected()
@@ -1,9 +1,10 @@
 /*
-Tool to export annotation results from the internal DB to an output DB.
-It does a simple copy&paste of the internal DB. The annotation results are
-stored in the assignments table.
+Tool to export annotation results from the internal DB.
+It can do a simple copy&paste of the internal DB to an output DB, where the
+annotation results are stored in the assignments table, and write flat
+datasets in JSON Lines or CSV format, along with their typed schema.
 
-Usage: import <origin-DSN> <path-to-sqlite.db>
+Usage: export [options] <origin-DSN> [<path-to-sqlite.db>]
 
 Where DSN can be one of:
 sqlite:///path/to/db.db
@@ -22,20 +23,41 @@ import (
 )
 
 const desc = `Exports annotation results from the internal input database to a new output
-database. The destination database must be empty.
+database, and/or to flat dataset files. The destination database must be empty.
 
 The Input argument must be one of:
 sqlite:///path/to/db.db
 postgresql://[user[:password]@][netloc][:port][,...][/dbname]
 
 For a complete reference of the PostgreSQL connection string, see
-https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING`
+https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING
+
+The datasets contain one row for each file pair, with the blob IDs,
+repositories, commits, paths and score of both files, followed by the answer,
+duration, comment, flags and answer time of every worker, or by the majority
+answer and the flags set by any worker if --majority is used.
+All the rows have the same columns, and missing values are null. They include
+all the experiments unless --experiment is set.
+
+--schema writes the names and types of the columns as a Spark SQL schema (the
+JSON of a StructType), to load the datasets with typed columns, e.g. in
+PySpark:
+
+    schema = StructType.fromJson(json.load(open("dataset.schema.json")))
+    spark.read.schema(schema).json("dataset.jsonl")
+
+Parquet files are not written; they can be written from the loaded tables.`
 
 var opts struct {
-	Args struct {
-		Input  string `description:"SQLite or PostgreSQL Data Source Name"`
+	JSONL      string `long:"jsonl" description:"JSON Lines dataset filepath"`
+	CSV        string `long:"csv" description:"CSV dataset filepath"`
+	Schema     string `long:"schema" description:"Spark SQL schema filepath of the datasets"`
+	Majority   bool   `long:"majority" description:"write the majority answer of each pair instead of the answers of every worker"`
+	Experiment int    `long:"experiment" description:"ID of the experiment exported to the datasets; all of them by default"`
+	Args       struct {
+		Input  string `description:"SQLite or PostgreSQL Data Source Name" required:"yes"`
 		Output string `description:"SQLite database filepath"`
-	} `positional-args:"yes" required:"yes"`
+	} `positional-args:"yes"`
 }
 
 func main() {
@@ -55,23 +77,76 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.Args.Output == "" && opts.JSONL == "" && opts.CSV == "" && opts.Schema == "" {
+		fmt.Println("At least one of Output, --jsonl, --csv or --schema must be set")
+		fmt.Println()
+		parser.WriteHelp(os.Stdout)
+		os.Exit(1)
+	}
+
 	originDB, err := dbutil.Open(opts.Args.Input, true)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer originDB.Close()
 
-	destDB, err := dbutil.OpenSQLite(opts.Args.Output, false)
+	if opts.Args.Output != "" {
+		if err := copyDB(originDB, opts.Args.Output); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	datasets := []struct {
+		path   string
+		format dbutil.DatasetFormat
+	}{
+		{opts.JSONL, dbutil.JSONLines},
+		{opts.CSV, dbutil.CSV},
+		{opts.Schema, dbutil.Schema},
+	}
+
+	for _, d := range datasets {
+		if d.path == "" {
+			continue
+		}
+
+		if err := exportDataset(originDB, d.path, d.format); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func copyDB(originDB dbutil.DB, output string) error {
+	destDB, err := dbutil.OpenSQLite(output, false)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer destDB.Close()
 
 	if err = dbutil.Bootstrap(destDB); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := dbutil.Copy(originDB, destDB, dbutil.Options{}); err != nil {
-		log.Fatal(err)
+	return dbutil.Copy(originDB, destDB, dbutil.Options{})
+}
+
+func exportDataset(originDB dbutil.DB, path string, format dbutil.DatasetFormat) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := dbutil.ExportDataset(originDB, f, format, opts.Experiment, opts.Majority)
+	if err != nil {
+		return err
 	}
+
+	if format == dbutil.Schema {
+		fmt.Printf("Exported the dataset schema to %s\n", path)
+		return nil
+	}
+
+	fmt.Printf("Exported %v file pairs to %s\n", rows, path)
+	return nil
 }
@@ -34,22 +34,25 @@
 //   ],
 //   ...
 // ]
+// The input is read as a stream, so it can be bigger than the available
+// memory. Malformed pairs are reported with their index and skipped.
 
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 
+	"github.com/src-d/code-annotation/server/dbutil"
+
 	"github.com/jessevdk/go-flags"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const desc = `Extracts features from a JSON file with pairs of files to the output database.
-The destination db must exist.`
+The destination db must exist, its schema will be migrated to the latest version.`
 
 var opts struct {
 	Args struct {
@@ -58,13 +61,7 @@ var opts struct {
 	} `positional-args:"yes" required:"yes"`
 }
 
-const (
-	createFeaturesSQL = `CREATE TABLE IF NOT EXISTS features (
-		blob_id TEXT,
-		name TEXT, weight REAL,
-		PRIMARY KEY (blob_id, name))`
-	insertSQL = `INSERT INTO features VALUES ($1, $2, $3)`
-)
+const insertSQL = `INSERT INTO features VALUES ($1, $2, $3)`
 
 func main() {
 	parser := flags.NewParser(&opts, flags.Default)
@@ -87,24 +84,19 @@ func main() {
 		parser.WriteHelp(os.Stdout)
 		os.Exit(1)
 	}
-	destDB, err := sql.Open("sqlite3", opts.Args.Output)
+	destDB, err := dbutil.OpenSQLite(opts.Args.Output, true)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer destDB.Close()
 
-	source, err := os.OpenFile(opts.Args.Input, os.O_RDONLY, 0)
+	source, err := os.Open(opts.Args.Input)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer source.Close()
 
-	_, err = destDB.Exec(createFeaturesSQL)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var data [][3]interface{}
-	if err := json.NewDecoder(source).Decode(&data); err != nil {
+	if err := dbutil.Bootstrap(destDB); err != nil {
 		log.Fatal(err)
 	}
 
@@ -118,23 +110,21 @@ func main() {
 		log.Fatal(err)
 	}
 
-	var success, failures int64
+	var success, failures, malformed int64
 
 	// there are full duplicates in json (checked with map & reflect.DeepEqual)
 	// instead of printing many errors like UNIQUE constraint failed: features.blob_id, features.name
 	// I handle them here
 	blobIDs := make(map[string]bool)
 
-	processFile := func(f map[string]interface{}) {
-		blobID := f["blob_id"].(string)
-		if _, ok := blobIDs[blobID]; ok {
+	processFile := func(f dbutil.DumpFile) {
+		if _, ok := blobIDs[f.BlobID]; ok {
 			return
 		}
-		blobIDs[blobID] = true
+		blobIDs[f.BlobID] = true
 
-		features := f["bag"].(map[string]interface{})
-		for name, weight := range features {
-			res, err := insert.Exec(blobID, name, weight.(float64))
+		for name, weight := range f.Bag {
+			res, err := insert.Exec(f.BlobID, name, weight)
 
 			if err != nil {
 				failures++
@@ -147,9 +137,25 @@ func main() {
 		}
 	}
 
-	for _, pair := range data {
-		processFile(pair[0].(map[string]interface{}))
-		processFile(pair[1].(map[string]interface{}))
+	decoder := dbutil.NewPairsDecoder(bufio.NewReader(source))
+	for {
+		pair, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if _, ok := err.(*dbutil.MalformedPairError); ok {
+			malformed++
+			log.Println(err)
+			continue
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		processFile(pair.A)
+		processFile(pair.B)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -161,4 +167,8 @@ func main() {
 	if failures > 0 {
 		fmt.Printf("Failed to extract and save %v features\n", failures)
 	}
+
+	if malformed > 0 {
+		fmt.Printf("Skipped %v malformed file pairs\n", malformed)
+	}
 }
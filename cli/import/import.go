@@ -1,7 +1,7 @@
 /*
 Tool to import pairs of files from an input sqlite database to another database.
 
-Usage: import <path-to-sqlite.db> <destination-DSN>
+Usage: import [options] <path-to-sqlite.db> <destination-DSN>
 
 Where DSN can be one of:
 sqlite:///path/to/db.db
@@ -25,7 +25,6 @@ import (
 	"github.com/jessevdk/go-flags"
 )
 
-// TODO: remove duplicated entries note if we decide to enforce the UNIQUE clause
 const desc = `Imports pairs of files from the input database to the output database.
 If the destination file does not exist, it will be created.
 
@@ -38,11 +37,19 @@ https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRI
 
 The destination database does not need to be empty, new imported file pairs can
 be added to previous imports.
-Please note: if a file pair is identical to an existing one it will not be
-detected. A new pair entry will be created with the same contents.`
+File pairs identical to an existing one in the same experiment, with the same
+files in any order, are skipped. Use --update to overwrite them instead.
+
+The file pairs are imported into the default experiment, unless a different one
+is chosen with --experiment-id or --experiment-name. An experiment chosen by
+name will be created if it does not exist.`
 
 var opts struct {
-	Args struct {
+	ExperimentID          int    `long:"experiment-id" description:"ID of an existing experiment to import the file pairs into"`
+	ExperimentName        string `long:"experiment-name" description:"name of the experiment to import the file pairs into; it is created if it does not exist"`
+	ExperimentDescription string `long:"experiment-description" description:"description of the experiment, if it is created"`
+	Update                bool   `long:"update" description:"update the file pairs that already exist instead of skipping them"`
+	Args                  struct {
 		Input  string `description:"SQLite database filepath"`
 		Output string `description:"SQLite or PostgreSQL Data Source Name"`
 	} `positional-args:"yes" required:"yes"`
@@ -65,6 +72,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.ExperimentID != 0 && opts.ExperimentName != "" {
+		fmt.Println("Only one of --experiment-id and --experiment-name can be used")
+		os.Exit(1)
+	}
+
 	originDB, err := dbutil.OpenSQLite(opts.Args.Input, true)
 	if err != nil {
 		log.Fatal(err)
@@ -85,14 +97,28 @@ func main() {
 		log.Fatal(err)
 	}
 
-	success, failures, err := dbutil.ImportFiles(originDB, destDB, dbutil.Options{})
+	stats, err := dbutil.ImportFiles(originDB, destDB,
+		dbutil.TargetExperiment{
+			ID:          opts.ExperimentID,
+			Name:        opts.ExperimentName,
+			Description: opts.ExperimentDescription,
+		},
+		dbutil.Options{UpdateDuplicates: opts.Update})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("Imported %v file pairs successfully\n", success)
+	fmt.Printf("Imported %v file pairs successfully\n", stats.Success)
+
+	if stats.Updated > 0 {
+		fmt.Printf("Updated %v existing file pairs\n", stats.Updated)
+	}
+
+	if stats.Skipped > 0 {
+		fmt.Printf("Skipped %v file pairs already imported\n", stats.Skipped)
+	}
 
-	if failures > 0 {
-		fmt.Printf("Failed to import %v file pairs\n", failures)
+	if stats.Failures > 0 {
+		fmt.Printf("Failed to import %v file pairs\n", stats.Failures)
 	}
 }
@@ -0,0 +1,119 @@
+/*
+Tool to load a JSON dump of pairs of files, as produced by the similarity
+model, directly into the internal database.
+It does the work of convert, feature_extractor and import in a single pass.
+
+Usage: load [options] <path-to-dump.json> <destination-DSN>
+
+Where DSN can be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+*/
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/src-d/code-annotation/server/dbutil"
+
+	"github.com/jessevdk/go-flags"
+)
+
+const desc = `Loads the file pairs and their features from a JSON dump into the output
+database. If the destination file does not exist, it will be created.
+
+The Output argument must be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+
+For a complete reference of the PostgreSQL connection string, see
+https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING
+
+The destination database does not need to be empty, new file pairs can be added
+to previous imports.
+File pairs identical to an existing one in the same experiment, with the same
+files in any order, are skipped. Use --update to overwrite them instead.
+Features are only stored for the files that do not have them yet.
+
+The file pairs are imported into the default experiment, unless a different one
+is chosen with --experiment-id or --experiment-name. An experiment chosen by
+name will be created if it does not exist.`
+
+var opts struct {
+	ExperimentID          int    `long:"experiment-id" description:"ID of an existing experiment to import the file pairs into"`
+	ExperimentName        string `long:"experiment-name" description:"name of the experiment to import the file pairs into; it is created if it does not exist"`
+	ExperimentDescription string `long:"experiment-description" description:"description of the experiment, if it is created"`
+	Update                bool   `long:"update" description:"update the file pairs that already exist instead of skipping them"`
+	Args                  struct {
+		Input  string `description:"JSON file"`
+		Output string `description:"SQLite or PostgreSQL Data Source Name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func main() {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.LongDescription = desc
+
+	if _, err := parser.Parse(); err != nil {
+		if err, ok := err.(*flags.Error); ok {
+			if err.Type == flags.ErrHelp {
+				os.Exit(0)
+			}
+
+			fmt.Println()
+			parser.WriteHelp(os.Stdout)
+		}
+
+		os.Exit(1)
+	}
+
+	if opts.ExperimentID != 0 && opts.ExperimentName != "" {
+		fmt.Println("Only one of --experiment-id and --experiment-name can be used")
+		os.Exit(1)
+	}
+
+	source, err := os.Open(opts.Args.Input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer source.Close()
+
+	destDB, err := dbutil.Open(opts.Args.Output, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer destDB.Close()
+
+	if err = dbutil.Bootstrap(destDB); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = dbutil.Initialize(destDB); err != nil {
+		log.Fatal(err)
+	}
+
+	stats, err := dbutil.LoadDump(bufio.NewReader(source), destDB,
+		dbutil.TargetExperiment{
+			ID:          opts.ExperimentID,
+			Name:        opts.ExperimentName,
+			Description: opts.ExperimentDescription,
+		},
+		dbutil.Options{UpdateDuplicates: opts.Update})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pairs := stats.FilePairs
+
+	fmt.Printf("File pairs: %v imported, %v updated, %v skipped, %v failed\n",
+		pairs.Success, pairs.Updated, pairs.Skipped, pairs.Failures)
+	fmt.Printf("Features: %v imported, %v failed\n",
+		stats.Features, stats.FeatureFailures)
+
+	if stats.Malformed > 0 {
+		fmt.Printf("Skipped %v malformed entries of the JSON dump\n", stats.Malformed)
+	}
+}
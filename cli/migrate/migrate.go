@@ -0,0 +1,119 @@
+/*
+Tool to manage the schema migrations of the internal DB.
+
+Usage: migrate [--steps=<n>] <status|up|down> <DSN>
+
+Where DSN can be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+*/
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/src-d/code-annotation/server/dbutil"
+
+	"github.com/jessevdk/go-flags"
+)
+
+const desc = `Manages the schema migrations of the internal database.
+
+The Mode argument must be one of:
+status: lists the migrations, and whether they are applied
+up:     applies all the pending migrations
+down:   reverts the last applied migrations, one unless --steps is set
+
+The DB argument must be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+
+For a complete reference of the PostgreSQL connection string, see
+https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING`
+
+var opts struct {
+	Steps int `long:"steps" default:"1" description:"number of migrations to revert with down"`
+	Args  struct {
+		Mode string `description:"status, up or down"`
+		DB   string `description:"SQLite or PostgreSQL Data Source Name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func main() {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.LongDescription = desc
+
+	if _, err := parser.Parse(); err != nil {
+		if err, ok := err.(*flags.Error); ok {
+			if err.Type == flags.ErrHelp {
+				os.Exit(0)
+			}
+
+			fmt.Println()
+			parser.WriteHelp(os.Stdout)
+		}
+
+		os.Exit(1)
+	}
+
+	db, err := dbutil.Open(opts.Args.DB, opts.Args.Mode != "up")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch opts.Args.Mode {
+	case "status":
+		err = status(db)
+	case "up":
+		err = up(db)
+	case "down":
+		err = down(db)
+	default:
+		fmt.Printf("Unknown mode %q\n\n", opts.Args.Mode)
+		parser.WriteHelp(os.Stdout)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func status(db dbutil.DB) error {
+	migrations, err := dbutil.Status(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		state := "pending"
+		if m.Applied {
+			state = "applied"
+		}
+
+		fmt.Printf("%4d %-8s %s\n", m.Version, state, m.Description)
+	}
+
+	current, err := dbutil.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version %d, latest version %d\n", current, dbutil.LatestSchemaVersion())
+	return nil
+}
+
+func up(db dbutil.DB) error {
+	applied, err := dbutil.MigrateUp(db)
+	fmt.Printf("Applied %d migrations\n", applied)
+	return err
+}
+
+func down(db dbutil.DB) error {
+	reverted, err := dbutil.MigrateDown(db, opts.Steps)
+	fmt.Printf("Reverted %d migrations\n", reverted)
+	return err
+}
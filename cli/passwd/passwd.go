@@ -0,0 +1,133 @@
+/*
+Tool to set the password of the users of the local authentication provider.
+
+Usage: passwd [options] <DSN> <login>
+
+Where DSN can be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+*/
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/jessevdk/go-flags"
+)
+
+const desc = `Sets the password of a user of the local authentication provider, read
+from the first line of the standard input. The user is created if it does not
+exist, with the given role and name.
+
+The DB argument must be one of:
+sqlite:///path/to/db.db
+postgresql://[user[:password]@][netloc][:port][,...][/dbname]
+
+For a complete reference of the PostgreSQL connection string, see
+https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING`
+
+var opts struct {
+	Role     string `long:"role" default:"worker" description:"role of the user, if it is created: worker or requester"`
+	Username string `long:"username" description:"real name of the user, if it is created"`
+	Args     struct {
+		DB    string `description:"SQLite or PostgreSQL Data Source Name"`
+		Login string `description:"login of the user"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func main() {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.LongDescription = desc
+
+	if _, err := parser.Parse(); err != nil {
+		if err, ok := err.(*flags.Error); ok {
+			if err.Type == flags.ErrHelp {
+				os.Exit(0)
+			}
+
+			fmt.Println()
+			parser.WriteHelp(os.Stdout)
+		}
+
+		os.Exit(1)
+	}
+
+	role, ok := model.Roles[model.Role(opts.Role)]
+	if !ok {
+		log.Fatalf("Wrong role: %q", opts.Role)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatalf("can't read the password: %s", err)
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		log.Fatal("the password can not be empty")
+	}
+
+	db, err := dbutil.Open(opts.Args.DB, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := dbutil.CheckSchema(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setPassword(repository.NewUsers(db.SQLDB()), role, password); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setPassword(users *repository.Users, role model.Role, password string) error {
+	hash, err := service.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, err := users.Get(opts.Args.Login)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		user = &model.User{
+			Login:        opts.Args.Login,
+			Username:     opts.Username,
+			Role:         role,
+			Provider:     service.LocalProvider,
+			PasswordHash: hash,
+		}
+
+		if err := users.Create(user); err != nil {
+			return err
+		}
+
+		fmt.Printf("Created the user %q with ID %d\n", user.Login, user.ID)
+		return nil
+	}
+
+	if user.Provider != service.LocalProvider {
+		return fmt.Errorf("the user %q is registered with the %s provider", user.Login, user.Provider)
+	}
+
+	user.PasswordHash = hash
+	if err := users.UpdatePassword(user); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated the password of the user %q\n", user.Login)
+	return nil
+}
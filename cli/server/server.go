@@ -6,6 +6,7 @@ import (
 
 	"github.com/src-d/code-annotation/server"
 	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/service"
 
 	"github.com/kelseyhightower/envconfig"
@@ -16,6 +17,9 @@ type appConfig struct {
 	Port     int    `envconfig:"PORT" default:"8080"`
 	UIDomain string `envconfig:"UI_DOMAIN" default:"http://127.0.0.1:8080"`
 	DBConn   string `envconfig:"DB_CONNECTION" default:"sqlite://./internal.db"`
+	// TrustedProxies are the IPs or CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For header is used as the address of the clients
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES"`
 }
 
 func main() {
@@ -33,17 +37,46 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := dbutil.CheckSchema(db); err != nil {
+		logger.Fatal(err)
+	}
+
+	stores := repository.NewStores(db.SQLDB())
+
 	// create services
 	var oauthConfig service.OAuthConfig
 	envconfig.MustProcess("oauth", &oauthConfig)
-	oauth := service.NewOAuth(oauthConfig.ClientID, oauthConfig.ClientSecret)
+
+	var providerConfig service.ProviderConfig
+	envconfig.MustProcess("auth", &providerConfig)
+	provider, err := service.NewProvider(providerConfig, oauthConfig, stores.Users)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if provider.Name() == service.DevProvider {
+		logger.Warn("the dev authentication provider is enabled, users log in without password")
+	}
 
 	var jwtConfig service.JWTConfig
 	envconfig.MustProcess("jwt", &jwtConfig)
-	jwt := service.NewJWT(jwtConfig.SigningKey)
+	jwt := service.NewJWT(jwtConfig, stores.Sessions)
+
+	var accessConfig service.AccessConfig
+	envconfig.MustProcess("auth", &accessConfig)
+	access, err := service.NewAccess(accessConfig.DefaultRole,
+		accessConfig.Requesters, accessConfig.AllowedUsers)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	proxies, err := service.NewTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	// start the router
-	router := server.Router(logger, jwt, oauth, conf.UIDomain, db.SQLDB(), "build")
+	router := server.Router(logger, jwt, provider, access, proxies, conf.UIDomain, stores, "build")
 	logger.Info("running...")
 	err = http.ListenAndServe(fmt.Sprintf("%s:%d", conf.Host, conf.Port), router)
 	logger.Fatal(err)
@@ -0,0 +1,420 @@
+package dbutil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatasetFormat is the file format of an exported dataset
+type DatasetFormat int
+
+const (
+	// JSONLines writes a JSON object for each row, one per line
+	JSONLines DatasetFormat = iota
+	// CSV writes a header with the column names, and a line for each row
+	CSV
+	// Schema writes the names and types of the columns as a Spark SQL schema
+	// (the JSON of a StructType), without any row. The JSON Lines and CSV
+	// datasets can be loaded with it to get typed columns
+	Schema
+)
+
+// the types of the dataset columns, as named by Spark SQL
+const (
+	longColumn      = "long"
+	doubleColumn    = "double"
+	stringColumn    = "string"
+	timestampColumn = "timestamp"
+)
+
+// datasetColumn is a column of a dataset. The pair columns are never null
+type datasetColumn struct {
+	name     string
+	typ      string
+	nullable bool
+}
+
+// the statements select all the experiments when the given ID is 0
+const (
+	selectDatasetUsersSQL = `SELECT DISTINCT users.id, users.login
+		FROM users JOIN assignments ON users.id = assignments.user_id
+		WHERE ($1=0 OR assignments.experiment_id=$1)
+		ORDER BY users.login`
+	selectDatasetAnswersSQL = `SELECT pair_id, user_id, answer, duration, comment, flags, answered_at
+		FROM assignments WHERE answer IS NOT NULL AND ($1=0 OR experiment_id=$1)`
+	selectDatasetPairsSQL = `SELECT id, experiment_id,
+		blob_id_a, repository_id_a, commit_hash_a, path_a,
+		blob_id_b, repository_id_b, commit_hash_b, path_b,
+		score
+		FROM file_pairs WHERE ($1=0 OR experiment_id=$1) ORDER BY id`
+)
+
+// datasetPairColumns are the columns with the file pair data, present in all
+// the datasets
+var datasetPairColumns = []datasetColumn{
+	{"pair_id", longColumn, false},
+	{"experiment_id", longColumn, false},
+	{"blob_id_a", stringColumn, false},
+	{"repository_id_a", stringColumn, false},
+	{"commit_hash_a", stringColumn, false},
+	{"path_a", stringColumn, false},
+	{"blob_id_b", stringColumn, false},
+	{"repository_id_b", stringColumn, false},
+	{"commit_hash_b", stringColumn, false},
+	{"path_b", stringColumn, false},
+	{"score", doubleColumn, false},
+}
+
+// datasetMajorityColumns are the columns added to the pair columns when the
+// answers are aggregated
+var datasetMajorityColumns = []datasetColumn{
+	{"majority_answer", stringColumn, true},
+	{"majority_votes", longColumn, false},
+	{"answers", longColumn, false},
+	{"flags", stringColumn, false},
+}
+
+// datasetUserColumns returns the columns added to the pair columns for the
+// answers of the given user, null if it did not answer the pair
+func datasetUserColumns(login string) []datasetColumn {
+	return []datasetColumn{
+		{"answer_" + login, stringColumn, true},
+		{"duration_" + login, longColumn, true},
+		{"comment_" + login, stringColumn, true},
+		{"flags_" + login, stringColumn, true},
+		{"answered_at_" + login, timestampColumn, true},
+	}
+}
+
+type datasetUser struct {
+	id    int
+	login string
+}
+
+type datasetAnswer struct {
+	answer   string
+	duration int
+	comment  string
+	// flags is a comma separated list
+	flags      string
+	answeredAt *time.Time
+}
+
+// ExportDataset writes a flat dataset to w with one row for each file pair of
+// the given experiment, or of all of them if experimentID is 0, with the same
+// columns for every row, or only their schema with the Schema format. Each row contains the file
+// pair data, followed by the answer, duration, comment, flags and answer time
+// of every user, or the majority answer and all the flags when majority is set.
+// Skipped answers are not counted for the majority; if there is a tie the
+// majority answer is null.
+// It returns the number of rows written
+func ExportDataset(db DB, w io.Writer, format DatasetFormat, experimentID int, majority bool) (int64, error) {
+	users, err := datasetUsers(db, experimentID)
+	if err != nil {
+		return 0, err
+	}
+
+	answers, err := datasetAnswers(db, experimentID)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := append([]datasetColumn{}, datasetPairColumns...)
+	if majority {
+		columns = append(columns, datasetMajorityColumns...)
+	} else {
+		for _, u := range users {
+			columns = append(columns, datasetUserColumns(u.login)...)
+		}
+	}
+
+	var dw datasetWriter
+	switch format {
+	case JSONLines:
+		dw = &jsonLinesWriter{enc: json.NewEncoder(w), columns: columns}
+	case CSV:
+		dw = &csvWriter{w: csv.NewWriter(w)}
+	case Schema:
+		dw = &schemaWriter{w: w}
+	default:
+		return 0, fmt.Errorf("Unknown dataset format")
+	}
+
+	if err := dw.writeHeader(columns); err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query(selectDatasetPairsSQL, experimentID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+
+	for rows.Next() {
+		var pairID, experimentID int
+		var blobIDA, repositoryIDA, commitHashA, pathA,
+			blobIDB, repositoryIDB, commitHashB, pathB string
+		var score float64
+
+		err := rows.Scan(&pairID, &experimentID,
+			&blobIDA, &repositoryIDA, &commitHashA, &pathA,
+			&blobIDB, &repositoryIDB, &commitHashB, &pathB,
+			&score)
+		if err != nil {
+			return count, err
+		}
+
+		values := []interface{}{pairID, experimentID,
+			blobIDA, repositoryIDA, commitHashA, pathA,
+			blobIDB, repositoryIDB, commitHashB, pathB,
+			score}
+
+		pairAnswers := answers[pairID]
+		if majority {
+			values = append(values, majorityValues(pairAnswers)...)
+			values = append(values, pairFlags(pairAnswers))
+		} else {
+			for _, u := range users {
+				if a, ok := pairAnswers[u.id]; ok {
+					values = append(values, a.answer, a.duration, a.comment, a.flags, a.answeredAt)
+				} else {
+					values = append(values, nil, nil, nil, nil, nil)
+				}
+			}
+		}
+
+		if err := dw.writeRow(values); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, dw.flush()
+}
+
+// datasetUsers returns the users with assignments in the experiment, ordered
+// by login
+func datasetUsers(db DB, experimentID int) ([]datasetUser, error) {
+	rows, err := db.Query(selectDatasetUsersSQL, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []datasetUser
+	for rows.Next() {
+		var u datasetUser
+		if err := rows.Scan(&u.id, &u.login); err != nil {
+			return nil, err
+		}
+
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// datasetAnswers returns the answers of the experiment by pair ID and user ID
+func datasetAnswers(db DB, experimentID int) (map[int]map[int]datasetAnswer, error) {
+	rows, err := db.Query(selectDatasetAnswersSQL, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := make(map[int]map[int]datasetAnswer)
+	for rows.Next() {
+		var pairID, userID int
+		var a datasetAnswer
+		if err := rows.Scan(&pairID, &userID, &a.answer, &a.duration,
+			&a.comment, &a.flags, &a.answeredAt); err != nil {
+			return nil, err
+		}
+
+		if answers[pairID] == nil {
+			answers[pairID] = make(map[int]datasetAnswer)
+		}
+		answers[pairID][userID] = a
+	}
+
+	return answers, rows.Err()
+}
+
+// majorityValues returns the values of the datasetMajorityColumns for the
+// given answers of a pair
+func majorityValues(answers map[int]datasetAnswer) []interface{} {
+	votes := make(map[string]int)
+	total := 0
+
+	for _, a := range answers {
+		if a.answer == "skip" {
+			continue
+		}
+
+		votes[a.answer]++
+		total++
+	}
+
+	labels := make([]string, 0, len(votes))
+	for label := range votes {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var label interface{}
+	max := 0
+	for _, l := range labels {
+		switch {
+		case votes[l] > max:
+			label, max = l, votes[l]
+		case votes[l] == max:
+			label = nil
+		}
+	}
+
+	return []interface{}{label, max, total}
+}
+
+// pairFlags returns the flags set by any user in the given answers of a pair,
+// sorted and separated by commas
+func pairFlags(answers map[int]datasetAnswer) string {
+	seen := make(map[string]bool)
+	var flags []string
+
+	for _, a := range answers {
+		if a.flags == "" {
+			continue
+		}
+
+		for _, f := range strings.Split(a.flags, ",") {
+			if !seen[f] {
+				seen[f] = true
+				flags = append(flags, f)
+			}
+		}
+	}
+
+	sort.Strings(flags)
+	return strings.Join(flags, ",")
+}
+
+// datasetWriter writes the rows of a dataset in a given format
+type datasetWriter interface {
+	writeHeader(columns []datasetColumn) error
+	writeRow(values []interface{}) error
+	flush() error
+}
+
+type jsonLinesWriter struct {
+	enc     *json.Encoder
+	columns []datasetColumn
+}
+
+func (w *jsonLinesWriter) writeHeader(columns []datasetColumn) error {
+	return nil
+}
+
+func (w *jsonLinesWriter) writeRow(values []interface{}) error {
+	row := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		row[w.columns[i].name] = v
+	}
+
+	return w.enc.Encode(row)
+}
+
+func (w *jsonLinesWriter) flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) writeHeader(columns []datasetColumn) error {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+
+	return w.w.Write(names)
+}
+
+// writeRow writes the values as text; null values are written as empty
+// fields
+func (w *csvWriter) writeRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			record[i] = ""
+		case string:
+			record[i] = v
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		case *time.Time:
+			if v != nil {
+				record[i] = v.Format(time.RFC3339)
+			}
+		default:
+			record[i] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// schemaWriter writes the Spark SQL schema of the columns, ignoring the rows
+type schemaWriter struct {
+	w io.Writer
+}
+
+type schemaField struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Nullable bool              `json:"nullable"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (w *schemaWriter) writeHeader(columns []datasetColumn) error {
+	fields := make([]schemaField, len(columns))
+	for i, c := range columns {
+		fields[i] = schemaField{Name: c.name, Type: c.typ,
+			Nullable: c.nullable, Metadata: map[string]string{}}
+	}
+
+	enc := json.NewEncoder(w.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Type   string        `json:"type"`
+		Fields []schemaField `json:"fields"`
+	}{"struct", fields})
+}
+
+func (w *schemaWriter) writeRow(values []interface{}) error {
+	return nil
+}
+
+func (w *schemaWriter) flush() error {
+	return nil
+}
@@ -2,22 +2,26 @@ package dbutil
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
 )
 
+// tablePlaceholder is replaced with the names in tables, the only values that
+// are not passed as parameters
 const (
 	tablePlaceholder = `<TABLE>`
 
-	dumpAllSQL       = `SELECT * FROM <TABLE>`
-	bulkInsertSQL    = `INSERT INTO <TABLE> VALUES `
-	maxIDSQL         = `SELECT MAX(id) FROM <TABLE>`
-	alterSequenceSQL = `ALTER SEQUENCE <TABLE>_id_seq RESTART WITH $1`
+	dumpAllSQL    = `SELECT * FROM <TABLE>`
+	bulkInsertSQL = `INSERT INTO <TABLE> VALUES `
+	maxIDSQL      = `SELECT MAX(id) FROM <TABLE>`
+	// ALTER SEQUENCE does not accept parameters, setval does
+	setSequenceSQL = `SELECT setval($1, $2, false)`
 )
 
-var tables = []string{"users", "experiments", "file_pairs", "assignments"}
+// sessions are not copied, the users have to log in again
+var tables = []string{"users", "experiments", "file_pairs", "assignments", "gold_answers",
+	"assignment_events"}
 
 // Copy dumps the contents of the origin DB into the destination DB. The
 // destination DB should be bootstrapped, but empty
@@ -135,18 +139,9 @@ func fixSequences(db DB, logger *log.Logger) {
 		}
 
 		if maxID.Valid {
-			alterCmd := strings.Replace(alterSequenceSQL, tablePlaceholder, table, 1)
-
-			newMax := fmt.Sprintf("%v", maxID.Int64+1)
-
-			// for some reason Exec() fails to substitute $1 with the argument
-			//_, err := db.Exec(alterCmd, newMax)
-
-			alterCmd = strings.Replace(alterCmd, "$1", newMax, 1)
-			_, err := db.Exec(alterCmd)
-
-			if err != nil {
-				logger.Printf("Error while executing %q:\n%v\n", alterCmd, err)
+			sequence := table + "_id_seq"
+			if _, err := db.Exec(setSequenceSQL, sequence, maxID.Int64+1); err != nil {
+				logger.Printf("Error while restarting the sequence %q:\n%v\n", sequence, err)
 			}
 		}
 	}
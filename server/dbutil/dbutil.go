@@ -7,7 +7,7 @@ import (
 	"log"
 	"os"
 	"regexp"
-	"strings"
+	"time"
 
 	// loads the driver
 	_ "github.com/lib/pq"
@@ -34,48 +34,18 @@ func (db *DB) SQLDB() *sql.DB {
 	return db.DB
 }
 
-const (
-	incrementTypePlaceholder = "<INCREMENT_TYPE>"
-	sqliteIncrementType      = "INTEGER"
-	posgresIncrementType     = "SERIAL"
-
-	createUsers = `CREATE TABLE IF NOT EXISTS users (
-			id <INCREMENT_TYPE>, login TEXT UNIQUE, username TEXT, avatar_url TEXT, role TEXT,
-			PRIMARY KEY (id))`
-	createExperiments = `CREATE TABLE IF NOT EXISTS experiments (
-			id <INCREMENT_TYPE>, name TEXT UNIQUE, description TEXT,
-			PRIMARY KEY (id))`
-	// TODO: consider a unique constrain to avoid importing identical pairs
-	createFilePairs = `CREATE TABLE IF NOT EXISTS file_pairs (
-		id <INCREMENT_TYPE>,
-		blob_id_a TEXT, repository_id_a TEXT, commit_hash_a TEXT, path_a TEXT, content_a TEXT, hash_a TEXT,
-		blob_id_b TEXT, repository_id_b TEXT, commit_hash_b TEXT, path_b TEXT, content_b TEXT, hash_b TEXT,
-		score DOUBLE PRECISION, diff TEXT, experiment_id INTEGER,
-		PRIMARY KEY (id),
-		FOREIGN KEY(experiment_id) REFERENCES experiments(id))`
-	createAssignments = `CREATE TABLE IF NOT EXISTS assignments (
-			id <INCREMENT_TYPE>,
-			user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
-			answer TEXT, duration INTEGER,
-			PRIMARY KEY (id),
-			UNIQUE (user_id, pair_id, experiment_id),
-			FOREIGN KEY (user_id) REFERENCES users(id),
-			FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
-			FOREIGN KEY (experiment_id) REFERENCES experiments(id))`
-	createFeatures = `CREATE TABLE IF NOT EXISTS features (
-		blob_id TEXT,
-		name TEXT, weight REAL,
-		PRIMARY KEY (blob_id, name))`
-)
-
 const (
 	defaultExperimentID = 1
 
 	insertExperiments = `INSERT INTO experiments
-		(id, name, description)
-		VALUES ($1, 'default', 'Default experiment')`
+		(id, name, description, created_at, updated_at)
+		VALUES ($1, 'default', 'Default experiment', $2, $2)`
 
 	alterExperimentsSequence = `ALTER SEQUENCE experiments_id_seq RESTART WITH 2`
+
+	selectExperimentsWhereID   = `SELECT id FROM experiments WHERE id=$1`
+	selectExperimentsWhereName = `SELECT id FROM experiments WHERE name=$1`
+	insertNamedExperiments     = `INSERT INTO experiments (name, description, created_at, updated_at) VALUES ($1, $2, $3, $3)`
 )
 
 const selectFiles = `SELECT * FROM files`
@@ -83,8 +53,20 @@ const selectFiles = `SELECT * FROM files`
 const insertFilePairs = `INSERT INTO file_pairs (
 		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a,
 		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b,
-		score, diff, experiment_id ) VALUES
-		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+		score, diff, experiment_id, created_at, updated_at ) VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16)`
+
+const selectFilePairsKeys = `SELECT blob_id_a, hash_a, blob_id_b, hash_b
+		FROM file_pairs WHERE experiment_id=$1`
+
+// updateFilePairs overwrites the pair with the same files, in any order
+const updateFilePairs = `UPDATE file_pairs SET
+		blob_id_a=$1, repository_id_a=$2, commit_hash_a=$3, path_a=$4, content_a=$5, hash_a=$6,
+		blob_id_b=$7, repository_id_b=$8, commit_hash_b=$9, path_b=$10, content_b=$11, hash_b=$12,
+		score=$13, diff=$14, updated_at=$15
+		WHERE experiment_id=$16 AND (
+			(blob_id_a=$1 AND hash_a=$6 AND blob_id_b=$7 AND hash_b=$12) OR
+			(blob_id_a=$7 AND hash_a=$12 AND blob_id_b=$1 AND hash_b=$6))`
 
 var (
 	sqliteReg = regexp.MustCompile(`^sqlite://(.+)$`)
@@ -131,38 +113,17 @@ sqlite:///path/to/db.db
 postgresql://[user[:password]@][netloc][:port][,...][/dbname]`, connection)
 }
 
-// Bootstrap creates the necessary tables for the output DB. It is safe to call on a
-// DB that is already bootstrapped.
+// Bootstrap creates the necessary tables for the output DB, applying all the
+// pending migrations. It is safe to call on a DB that is already bootstrapped.
 func Bootstrap(db DB) error {
-	tables := []string{createUsers, createExperiments,
-		createFilePairs, createAssignments, createFeatures}
-
-	var colType string
-
-	switch db.driver {
-	case sqlite:
-		colType = sqliteIncrementType
-	case postgres:
-		colType = posgresIncrementType
-	default:
-		return fmt.Errorf("Unknown driver type")
-	}
-
-	for _, table := range tables {
-		cmd := strings.Replace(table, incrementTypePlaceholder, colType, 1)
-
-		if _, err := db.Exec(cmd); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	_, err := MigrateUp(db)
+	return err
 }
 
 // Initialize populates the DB with default values. It is safe to call on a
 // DB that is already initialized
 func Initialize(db DB) error {
-	_, err := db.Exec(insertExperiments, defaultExperimentID)
+	_, err := db.Exec(insertExperiments, defaultExperimentID, time.Now().UTC())
 	if db.driver == postgres && err == nil {
 		db.Exec(alterExperimentsSequence)
 	}
@@ -173,8 +134,11 @@ func Initialize(db DB) error {
 
 // Options for the ImportFiles and Copy methods.
 // Logger is optional, if it is not provided the default stderr will be used.
+// UpdateDuplicates is only used by ImportFiles, to overwrite the file pairs
+// that already exist instead of skipping them.
 type Options struct {
-	Logger *log.Logger
+	Logger           *log.Logger
+	UpdateDuplicates bool
 }
 
 func (opts *Options) getLogger() *log.Logger {
@@ -186,75 +150,295 @@ func (opts *Options) getLogger() *log.Logger {
 
 }
 
-// ImportFiles imports pairs of files from the origin to the destination DB.
-// It copies the contents and processes the needed data (md5 hash, diff)
-func ImportFiles(originDB DB, destDB DB, opts Options) (success, failures int64, e error) {
+// TargetExperiment identifies the experiment the imported file pairs belong to.
+// If ID is set, that experiment must exist. Otherwise the experiment is looked
+// up by Name, and created with the given Description if it does not exist.
+// The zero value targets the default experiment
+type TargetExperiment struct {
+	ID          int
+	Name        string
+	Description string
+}
+
+// experimentID returns the ID of the target experiment, creating it if needed
+// with the given transaction, so it is not left behind when the import fails
+func (t TargetExperiment) experimentID(tx *sql.Tx, logger *log.Logger) (int, error) {
+	var id int
+
+	switch {
+	case t.ID != 0:
+		err := tx.QueryRow(selectExperimentsWhereID, t.ID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("Experiment with ID %v does not exist", t.ID)
+		}
+
+		return id, err
+	case t.Name != "":
+		err := tx.QueryRow(selectExperimentsWhereName, t.Name).Scan(&id)
+		if err != sql.ErrNoRows {
+			return id, err
+		}
+
+		if _, err := tx.Exec(insertNamedExperiments, t.Name, t.Description, time.Now().UTC()); err != nil {
+			return 0, fmt.Errorf("Failed to create experiment %q: %v", t.Name, err)
+		}
+
+		logger.Printf("Creating experiment %q\n", t.Name)
+
+		err = tx.QueryRow(selectExperimentsWhereName, t.Name).Scan(&id)
+		return id, err
+	default:
+		return defaultExperimentID, nil
+	}
+}
+
+// ImportStats counts the file pairs processed by ImportFiles
+type ImportStats struct {
+	// Success is the number of new file pairs
+	Success int64
+	// Updated is the number of existing file pairs updated with the imported
+	// values, when Options.UpdateDuplicates is set
+	Updated int64
+	// Skipped is the number of file pairs already present in the target
+	// experiment
+	Skipped int64
+	// Failures is the number of file pairs that could not be imported
+	Failures int64
+}
+
+// file contains the values of one of the files of an imported pair
+type file struct {
+	blobID, repositoryID, commitHash, path, content, hash string
+}
+
+// pairKey returns a key that identifies the pair formed by files a and b,
+// regardless of their order
+func pairKey(blobIDA, hashA, blobIDB, hashB string) string {
+	a := blobIDA + ":" + hashA
+	b := blobIDB + ":" + hashB
+
+	if a > b {
+		a, b = b, a
+	}
+
+	return a + "|" + b
+}
+
+// the savepoint set before each row written by the importers. PostgreSQL
+// aborts the whole transaction on the first failed statement, so a failed row
+// is rolled back to it to go on with the next ones
+const (
+	setRowSavepoint      = `SAVEPOINT import_row`
+	rollbackRowSavepoint = `ROLLBACK TO SAVEPOINT import_row`
+	releaseRowSavepoint  = `RELEASE SAVEPOINT import_row`
+)
+
+// execRow executes the statement in the transaction as a single row, that is
+// rolled back alone if it fails. The failures of the statement are returned as
+// rowErr, and the ones that leave the transaction unusable as err
+func execRow(tx *sql.Tx, stmt *sql.Stmt, args ...interface{}) (res sql.Result, rowErr, err error) {
+	if _, err := tx.Exec(setRowSavepoint); err != nil {
+		return nil, nil, err
+	}
+
+	res, rowErr = stmt.Exec(args...)
+	if rowErr != nil {
+		_, err = tx.Exec(rollbackRowSavepoint)
+		return nil, rowErr, err
+	}
+
+	_, err = tx.Exec(releaseRowSavepoint)
+	return res, nil, err
+}
+
+// filePairsImporter inserts file pairs into an experiment, detecting the ones
+// that already exist on it
+type filePairsImporter struct {
+	tx           *sql.Tx
+	experimentID int
+	update       bool
+	insert       *sql.Stmt
+	updateStmt   *sql.Stmt
+	existing     map[string]bool
+	logger       *log.Logger
+	stats        ImportStats
+}
+
+// newFilePairsImporter returns a filePairsImporter that will use the given
+// transaction, loading the keys of the pairs already in the experiment
+func newFilePairsImporter(tx *sql.Tx, experimentID int, opts Options) (*filePairsImporter, error) {
+	insert, err := tx.Prepare(insertFilePairs)
+	if err != nil {
+		return nil, err
+	}
+
+	update, err := tx.Prepare(updateFilePairs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(selectFilePairsKeys, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var blobIDA, hashA, blobIDB, hashB string
+		if err := rows.Scan(&blobIDA, &hashA, &blobIDB, &hashB); err != nil {
+			return nil, err
+		}
+
+		existing[pairKey(blobIDA, hashA, blobIDB, hashB)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &filePairsImporter{
+		tx:           tx,
+		experimentID: experimentID,
+		update:       opts.UpdateDuplicates,
+		insert:       insert,
+		updateStmt:   update,
+		existing:     existing,
+		logger:       opts.getLogger(),
+	}, nil
+}
+
+// importPair stores the pair formed by files a and b, and counts the result.
+// It only returns the errors that abort the import
+func (imp *filePairsImporter) importPair(a, b file, score float64) error {
+	a.hash = md5hash(a.content)
+	b.hash = md5hash(b.content)
+
+	key := pairKey(a.blobID, a.hash, b.blobID, b.hash)
+	duplicated := imp.existing[key]
+
+	if duplicated && !imp.update {
+		imp.stats.Skipped++
+		return nil
+	}
+
+	diffText, err := diff(a.path, b.path, a.content, b.content)
+	if err != nil {
+		imp.logger.Printf(
+			"Failed to create diff for files:\n - %q\n - %q\nerror: %v\n",
+			a.path, b.path, err)
+		imp.stats.Failures++
+		return nil
+	}
+
+	if duplicated {
+		_, rowErr, err := execRow(imp.tx, imp.updateStmt,
+			a.blobID, a.repositoryID, a.commitHash, a.path, a.content, a.hash,
+			b.blobID, b.repositoryID, b.commitHash, b.path, b.content, b.hash,
+			score,
+			diffText,
+			time.Now().UTC(),
+			imp.experimentID)
+
+		if err != nil {
+			return err
+		}
+
+		if rowErr != nil {
+			imp.logger.Printf("Failed to update row\nerror: %v\n", rowErr)
+			imp.stats.Failures++
+			return nil
+		}
+
+		imp.stats.Updated++
+		return nil
+	}
+
+	res, rowErr, err := execRow(imp.tx, imp.insert,
+		a.blobID, a.repositoryID, a.commitHash, a.path, a.content, a.hash,
+		b.blobID, b.repositoryID, b.commitHash, b.path, b.content, b.hash,
+		score,
+		diffText,
+		imp.experimentID,
+		time.Now().UTC())
+
+	if err != nil {
+		return err
+	}
+
+	if rowErr != nil {
+		imp.logger.Printf("Failed to insert row\nerror: %v\n", rowErr)
+		imp.stats.Failures++
+		return nil
+	}
+
+	imp.existing[key] = true
+
+	rowsAffected, _ := res.RowsAffected()
+	imp.stats.Success += rowsAffected
+	return nil
+}
+
+// ImportFiles imports pairs of files from the origin to the destination DB,
+// into the given target experiment.
+// It copies the contents and processes the needed data (md5 hash, diff).
+// Pairs already present in the experiment, with the same files in any order,
+// are skipped, or updated if opts.UpdateDuplicates is set
+func ImportFiles(originDB DB, destDB DB, target TargetExperiment, opts Options) (ImportStats, error) {
 
 	logger := opts.getLogger()
 
 	rows, err := originDB.Query(selectFiles)
 	if err != nil {
-		return 0, 0, err
+		return ImportStats{}, err
 	}
 	defer rows.Close()
 
 	tx, err := destDB.Begin()
 	if err != nil {
-		return 0, 0, err
+		return ImportStats{}, err
 	}
 
-	insert, err := tx.Prepare(insertFilePairs)
+	experimentID, err := target.experimentID(tx, logger)
 	if err != nil {
-		return 0, 0, err
+		tx.Rollback()
+		return ImportStats{}, err
+	}
+
+	importer, err := newFilePairsImporter(tx, experimentID, opts)
+	if err != nil {
+		tx.Rollback()
+		return ImportStats{}, err
 	}
 
 	for rows.Next() {
-		var blobIDA, repositoryIDA, commitHashA, pathA, contentA,
-			blobIDB, repositoryIDB, commitHashB, pathB, contentB string
+		var a, b file
 		var score float64
 
 		err := rows.Scan(
-			&blobIDA, &repositoryIDA, &commitHashA, &pathA, &contentA,
-			&blobIDB, &repositoryIDB, &commitHashB, &pathB, &contentB,
+			&a.blobID, &a.repositoryID, &a.commitHash, &a.path, &a.content,
+			&b.blobID, &b.repositoryID, &b.commitHash, &b.path, &b.content,
 			&score)
 
 		if err != nil {
 			logger.Printf("Failed to read row from origin DB\nerror: %v\n", err)
-			failures++
-			continue
-		}
-
-		diffText, err := diff(pathA, pathB, contentA, contentB)
-		if err != nil {
-			logger.Printf(
-				"Failed to create diff for files:\n - %q\n - %q\nerror: %v\n",
-				pathA, pathB, err)
-			failures++
+			importer.stats.Failures++
 			continue
 		}
 
-		res, err := insert.Exec(
-			blobIDA, repositoryIDA, commitHashA, pathA, contentA, md5hash(contentA),
-			blobIDB, repositoryIDB, commitHashB, pathB, contentB, md5hash(contentB),
-			score,
-			diffText,
-			defaultExperimentID)
-
-		if err != nil {
-			logger.Printf("Failed to insert row\nerror: %v\n", err)
-			failures++
-			continue
+		if err := importer.importPair(a, b, score); err != nil {
+			tx.Rollback()
+			return ImportStats{}, err
 		}
-
-		rowsAffected, _ := res.RowsAffected()
-		success += rowsAffected
 	}
 
+	stats := importer.stats
+
 	if err := tx.Commit(); err != nil {
-		return 0, success + failures, err
+		return ImportStats{Failures: stats.Success + stats.Updated + stats.Failures}, err
 	}
 
-	return success, failures, rows.Err()
+	return stats, rows.Err()
 }
 
 func md5hash(text string) string {
@@ -1,7 +1,14 @@
 package dbutil
 
 import (
+	"bytes"
+	"encoding/csv"
+	"io"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,6 +65,454 @@ func (suite *DBUtilSuite) TestDiff() {
 	assert.Equal(ac, acDiff)
 }
 
+func (suite *DBUtilSuite) TestPairKey() {
+	assert := suite.Assert()
+
+	assert.Equal(pairKey("a", "1", "b", "2"), pairKey("b", "2", "a", "1"))
+	assert.NotEqual(pairKey("a", "1", "b", "2"), pairKey("a", "1", "b", "3"))
+	assert.NotEqual(pairKey("a", "1", "b", "2"), pairKey("a", "2", "b", "1"))
+}
+
+// newTestDB returns a bootstrapped and initialized SQLite DB in a temporary
+// directory, that is removed with the returned function
+func newTestDB(suite *DBUtilSuite) (DB, func()) {
+	require := suite.Require()
+
+	dir, err := ioutil.TempDir("", "dbutil")
+	require.NoError(err)
+
+	db, err := OpenSQLite(filepath.Join(dir, "internal.db"), false)
+	require.NoError(err)
+	require.NoError(Bootstrap(db))
+	require.NoError(Initialize(db))
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+const createFilesSQL = `CREATE TABLE files (
+	blob_id_a TEXT, repository_id_a TEXT, commit_hash_a TEXT, path_a TEXT, content_a TEXT,
+	blob_id_b TEXT, repository_id_b TEXT, commit_hash_b TEXT, path_b TEXT, content_b TEXT,
+	score DOUBLE PRECISION)`
+
+func (suite *DBUtilSuite) TestImportFilesDuplicates() {
+	require := suite.Require()
+
+	origin, cleanOrigin := newTestDB(suite)
+	defer cleanOrigin()
+	dest, cleanDest := newTestDB(suite)
+	defer cleanDest()
+
+	_, err := origin.Exec(createFilesSQL)
+	require.NoError(err)
+	_, err = origin.Exec(`INSERT INTO files VALUES
+		('a', 'repo', 'c1', 'a.go', 'content a', 'b', 'repo', 'c1', 'b.go', 'content b', 0.5),
+		('b', 'repo', 'c1', 'b.go', 'content b', 'a', 'repo', 'c1', 'a.go', 'content a', 0.7),
+		('a', 'repo', 'c1', 'a.go', 'content a', 'c', 'repo', 'c1', 'c.go', 'content c', 0.2)`)
+	require.NoError(err)
+
+	opts := Options{Logger: log.New(ioutil.Discard, "", 0)}
+
+	stats, err := ImportFiles(origin, dest, TargetExperiment{}, opts)
+	require.NoError(err)
+	suite.Equal(ImportStats{Success: 2, Skipped: 1}, stats)
+
+	stats, err = ImportFiles(origin, dest, TargetExperiment{}, opts)
+	require.NoError(err)
+	suite.Equal(ImportStats{Skipped: 3}, stats)
+
+	opts.UpdateDuplicates = true
+	stats, err = ImportFiles(origin, dest, TargetExperiment{}, opts)
+	require.NoError(err)
+	suite.Equal(ImportStats{Updated: 3}, stats)
+
+	stats, err = ImportFiles(origin, dest, TargetExperiment{Name: "other"}, Options{Logger: opts.Logger})
+	require.NoError(err)
+	suite.Equal(ImportStats{Success: 2, Skipped: 1}, stats)
+
+	var count int
+	require.NoError(dest.QueryRow(`SELECT COUNT(*) FROM file_pairs`).Scan(&count))
+	suite.Equal(4, count)
+}
+
+func (suite *DBUtilSuite) TestImportRollback() {
+	require := suite.Require()
+
+	dest, cleanDest := newTestDB(suite)
+	defer cleanDest()
+
+	opts := Options{Logger: log.New(ioutil.Discard, "", 0)}
+
+	// the dump is truncated after the experiment is created
+	_, err := LoadDump(strings.NewReader(`[[{"blob_id": `), dest, TargetExperiment{Name: "new"}, opts)
+	require.Error(err)
+
+	// the experiment is not created when the import fails
+	var count int
+	require.NoError(dest.QueryRow(`SELECT COUNT(*) FROM experiments WHERE name='new'`).Scan(&count))
+	suite.Equal(0, count)
+}
+
+func (suite *DBUtilSuite) TestLoadRowFailures() {
+	require := suite.Require()
+
+	dest, cleanDest := newTestDB(suite)
+	defer cleanDest()
+
+	// the rows of the pair with blob b and of the feature f2 fail to insert
+	_, err := dest.Exec(`CREATE TRIGGER fail_pair BEFORE INSERT ON file_pairs
+		WHEN NEW.blob_id_b = 'b' BEGIN SELECT RAISE(ABORT, 'failed pair'); END`)
+	require.NoError(err)
+	_, err = dest.Exec(`CREATE TRIGGER fail_feature BEFORE INSERT ON features
+		WHEN NEW.name = 'f2' BEGIN SELECT RAISE(ABORT, 'failed feature'); END`)
+	require.NoError(err)
+
+	opts := Options{Logger: log.New(ioutil.Discard, "", 0)}
+	stats, err := LoadDump(strings.NewReader(loadDump), dest, TargetExperiment{}, opts)
+	require.NoError(err)
+	suite.Equal(LoadStats{
+		FilePairs:       ImportStats{Success: 1, Failures: 1},
+		Features:        2,
+		FeatureFailures: 1,
+	}, stats)
+
+	// only the failed rows are rolled back
+	var pairs, features int
+	require.NoError(dest.QueryRow(`SELECT COUNT(*) FROM file_pairs`).Scan(&pairs))
+	require.NoError(dest.QueryRow(`SELECT COUNT(*) FROM features`).Scan(&features))
+	suite.Equal(1, pairs)
+	suite.Equal(2, features)
+}
+
+// datasetDump and datasetOtherDump have the pairs of the default experiment
+// and of another one
+const datasetDump = `[
+[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A"},
+ {"blob_id": "b", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "B"}, 0.9],
+[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A"},
+ {"blob_id": "c", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "C"}, 0.5],
+[{"blob_id": "b", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "B"},
+ {"blob_id": "c", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "C"}, 0.1]
+]`
+
+const datasetOtherDump = `[
+[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A"},
+ {"blob_id": "d", "repository_id": "r", "commit_hash": "c", "path": "d.go", "content": "D"}, 0.3]
+]`
+
+// datasetAssignments are the assignments of the users 1 (alice) and 2 (bob)
+// for the dataset pairs. Pair 3 has no answers, pair 4 is of the other
+// experiment
+const datasetAssignments = `INSERT INTO assignments
+	(user_id, pair_id, experiment_id, answer, duration, comment, flags, answered_at) VALUES
+	(1, 1, 1, 'yes', 10, 'odd, "quoted"', 'binary', '2018-01-02 03:04:05'),
+	(2, 1, 1, 'yes', 20, '', '', '2018-01-02 03:04:06'),
+	(1, 2, 1, 'skip', 5, '', 'truncated,binary', '2018-01-02 03:04:07'),
+	(2, 2, 1, 'no', 15, '', 'generated', '2018-01-02 03:04:08'),
+	(1, 3, 1, NULL, 0, '', '', NULL),
+	(2, 4, 2, 'maybe', 1, '', '', '2018-01-02 03:04:09')`
+
+// newDatasetDB returns a test DB with the datasetDump pairs and the
+// datasetAssignments
+func newDatasetDB(suite *DBUtilSuite) (DB, func()) {
+	require := suite.Require()
+
+	db, clean := newTestDB(suite)
+	opts := Options{Logger: log.New(ioutil.Discard, "", 0)}
+
+	_, err := LoadDump(strings.NewReader(datasetDump), db, TargetExperiment{}, opts)
+	require.NoError(err)
+	_, err = LoadDump(strings.NewReader(datasetOtherDump), db, TargetExperiment{Name: "other"}, opts)
+	require.NoError(err)
+
+	_, err = db.Exec(`INSERT INTO users (login, role) VALUES ('alice', 'worker'), ('bob', 'worker')`)
+	require.NoError(err)
+	_, err = db.Exec(datasetAssignments)
+	require.NoError(err)
+
+	return db, clean
+}
+
+func (suite *DBUtilSuite) TestExportDataset() {
+	require := suite.Require()
+
+	db, clean := newDatasetDB(suite)
+	defer clean()
+
+	datasets := []struct {
+		golden   string
+		format   DatasetFormat
+		majority bool
+	}{
+		{"dataset.jsonl", JSONLines, false},
+		{"dataset.csv", CSV, false},
+		{"dataset_majority.jsonl", JSONLines, true},
+		{"dataset_majority.csv", CSV, true},
+		{"dataset_schema.json", Schema, false},
+		{"dataset_majority_schema.json", Schema, true},
+	}
+
+	for _, d := range datasets {
+		var buf bytes.Buffer
+		rows, err := ExportDataset(db, &buf, d.format, 1, d.majority)
+		require.NoError(err, d.golden)
+		suite.EqualValues(3, rows, d.golden)
+
+		expected, err := readFile(filepath.Join("testdata", d.golden))
+		require.NoError(err)
+		suite.Equal(expected, buf.String(), d.golden)
+	}
+
+	// the other experiment only has the answers of bob
+	var buf bytes.Buffer
+	rows, err := ExportDataset(db, &buf, CSV, 2, false)
+	require.NoError(err)
+	suite.EqualValues(1, rows)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(err)
+	require.Len(records, 2)
+	suite.Equal("answer_bob", records[0][len(datasetPairColumns)])
+	suite.Len(records[0], len(datasetPairColumns)+5)
+	suite.Equal("maybe", records[1][len(datasetPairColumns)])
+
+	rows, err = ExportDataset(db, ioutil.Discard, JSONLines, 0, true)
+	require.NoError(err)
+	suite.EqualValues(4, rows)
+}
+
+// loadDump has two valid pairs with features, sharing file a
+const loadDump = `[
+[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A", "bag": {"f1": 0.5, "f2": 1}},
+ {"blob_id": "b", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "B", "bag": {"f1": 0.2}}, 0.9],
+[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A", "bag": {"f1": 0.5, "f2": 1}},
+ {"blob_id": "c", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "C"}, 0.5]
+]`
+
+func (suite *DBUtilSuite) TestLoadDump() {
+	cases := []struct {
+		name string
+		// previous is loaded before the dump, if set
+		previous string
+		dump     string
+		update   bool
+		stats    LoadStats
+		pairs    int
+		features int
+	}{
+		{
+			name:     "new",
+			dump:     loadDump,
+			stats:    LoadStats{FilePairs: ImportStats{Success: 2}, Features: 3},
+			pairs:    2,
+			features: 3,
+		},
+		{
+			name:     "empty",
+			dump:     `[]`,
+			stats:    LoadStats{},
+			pairs:    0,
+			features: 0,
+		},
+		{
+			name: "malformed",
+			dump: `[
+				[{"blob_id": "a"}, {}, 0.1],
+				"not a pair",
+				[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A"},
+				 {"blob_id": "b", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "B"}, "0.3"],
+				[{"blob_id": "d", "repository_id": "r", "commit_hash": "c", "path": "d.go", "content": "D", "bag": {"f3": 2}},
+				 {"blob_id": "e", "repository_id": "r", "commit_hash": "c", "path": "e.go", "content": "E"}, 0.2]
+			]`,
+			stats:    LoadStats{FilePairs: ImportStats{Success: 1}, Malformed: 3, Features: 1},
+			pairs:    1,
+			features: 1,
+		},
+		{
+			name:     "duplicates",
+			previous: loadDump,
+			dump:     loadDump,
+			stats:    LoadStats{FilePairs: ImportStats{Skipped: 2}},
+			pairs:    2,
+			features: 3,
+		},
+		{
+			name:     "update duplicates",
+			previous: loadDump,
+			dump:     loadDump,
+			update:   true,
+			stats:    LoadStats{FilePairs: ImportStats{Updated: 2}},
+			pairs:    2,
+			features: 3,
+		},
+	}
+
+	for _, c := range cases {
+		stats, pairs, features := suite.loadDumpCase(c.previous, c.dump, c.update)
+		suite.Equal(c.stats, stats, c.name)
+		suite.Equal(c.pairs, pairs, c.name)
+		suite.Equal(c.features, features, c.name)
+	}
+}
+
+// loadDumpCase loads the previous dump, if set, and the dump into a new test
+// DB. It returns the stats of the last load, and the number of file pairs and
+// features stored
+func (suite *DBUtilSuite) loadDumpCase(previous, dump string, update bool) (LoadStats, int, int) {
+	require := suite.Require()
+
+	db, clean := newTestDB(suite)
+	defer clean()
+
+	opts := Options{Logger: log.New(ioutil.Discard, "", 0), UpdateDuplicates: update}
+	if previous != "" {
+		_, err := LoadDump(strings.NewReader(previous), db, TargetExperiment{}, opts)
+		require.NoError(err)
+	}
+
+	stats, err := LoadDump(strings.NewReader(dump), db, TargetExperiment{}, opts)
+	require.NoError(err)
+
+	var pairs, features int
+	require.NoError(db.QueryRow(`SELECT COUNT(*) FROM file_pairs`).Scan(&pairs))
+	require.NoError(db.QueryRow(`SELECT COUNT(*) FROM features`).Scan(&features))
+
+	return stats, pairs, features
+}
+
+func (suite *DBUtilSuite) TestMajorityValues() {
+	assert := suite.Assert()
+
+	assert.Equal([]interface{}{"yes", 2, 3}, majorityValues(map[int]datasetAnswer{
+		1: {answer: "yes"}, 2: {answer: "no"}, 3: {answer: "yes"}, 4: {answer: "skip"}}))
+	assert.Equal([]interface{}{nil, 1, 2}, majorityValues(map[int]datasetAnswer{
+		1: {answer: "yes"}, 2: {answer: "no"}}))
+	assert.Equal([]interface{}{nil, 0, 0}, majorityValues(nil))
+}
+
+func (suite *DBUtilSuite) TestPairFlags() {
+	assert := suite.Assert()
+
+	assert.Equal("binary,generated,truncated", pairFlags(map[int]datasetAnswer{
+		1: {flags: "truncated,binary"}, 2: {}, 3: {flags: "generated,binary"}}))
+	assert.Equal("", pairFlags(map[int]datasetAnswer{1: {answer: "yes"}}))
+}
+
+func (suite *DBUtilSuite) TestPairsDecoder() {
+	require := suite.Require()
+
+	dump := `[
+		[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A", "bag": {"f": 0.5}},
+		 {"blob_id": "b", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "B"},
+		 0.9],
+		[{"blob_id": "a"}, {}, 0.1],
+		"not a pair",
+		[{"blob_id": "a", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "A"},
+		 {"blob_id": "c", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "C"},
+		 "0.3"],
+		[{"blob_id": "c", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "C"},
+		 {"blob_id": "d", "repository_id": "r", "commit_hash": "c", "path": "d.go", "content": "D"},
+		 0.2]
+	]`
+
+	decoder := NewPairsDecoder(strings.NewReader(dump))
+
+	pair, err := decoder.Next()
+	require.NoError(err)
+	suite.Equal(DumpPair{
+		A:     DumpFile{"a", "r", "c", "a.go", "A", map[string]float64{"f": 0.5}},
+		B:     DumpFile{"b", "r", "c", "b.go", "B", nil},
+		Score: 0.9,
+	}, *pair)
+
+	for _, index := range []int{1, 2, 3} {
+		_, err = decoder.Next()
+		require.IsType(&MalformedPairError{}, err)
+		suite.Equal(index, err.(*MalformedPairError).Index)
+	}
+
+	pair, err = decoder.Next()
+	require.NoError(err)
+	suite.Equal("d", pair.B.BlobID)
+
+	_, err = decoder.Next()
+	suite.Equal(io.EOF, err)
+
+	decoder = NewPairsDecoder(strings.NewReader(`[[{"blob_id": `))
+	_, err = decoder.Next()
+	suite.Error(err)
+	_, malformed := err.(*MalformedPairError)
+	suite.False(malformed)
+}
+
+func (suite *DBUtilSuite) TestMigrations() {
+	require := suite.Require()
+
+	db, clean := newTestDB(suite)
+	defer clean()
+
+	latest := LatestSchemaVersion()
+
+	version, err := SchemaVersion(db)
+	require.NoError(err)
+	suite.Equal(latest, version)
+	suite.NoError(CheckSchema(db))
+
+	reverted, err := MigrateDown(db, latest)
+	require.NoError(err)
+	suite.Equal(latest, reverted)
+
+	version, err = SchemaVersion(db)
+	require.NoError(err)
+	suite.Equal(0, version)
+	suite.Error(CheckSchema(db))
+
+	status, err := Status(db)
+	require.NoError(err)
+	suite.Len(status, latest)
+	for _, m := range status {
+		suite.False(m.Applied)
+	}
+
+	applied, err := MigrateUp(db)
+	require.NoError(err)
+	suite.Equal(latest, applied)
+	suite.NoError(CheckSchema(db))
+
+	applied, err = MigrateUp(db)
+	require.NoError(err)
+	suite.Equal(0, applied)
+}
+
+func (suite *DBUtilSuite) TestCheckSchemaReadOnly() {
+	require := suite.Require()
+
+	dir, err := ioutil.TempDir("", "dbutil")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenSQLite(filepath.Join(dir, "empty.db"), false)
+	require.NoError(err)
+	defer db.Close()
+
+	suite.Error(CheckSchema(db))
+
+	status, err := Status(db)
+	require.NoError(err)
+	suite.Len(status, LatestSchemaVersion())
+
+	exist, err := schemaMigrationsExist(db)
+	require.NoError(err)
+	suite.False(exist)
+
+	applied, err := MigrateUp(db)
+	require.NoError(err)
+	suite.Equal(LatestSchemaVersion(), applied)
+	suite.NoError(CheckSchema(db))
+}
+
 func TestDBUtil(t *testing.T) {
 	suite.Run(t, new(DBUtilSuite))
 }
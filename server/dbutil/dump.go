@@ -0,0 +1,188 @@
+package dbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DumpFile is one of the files of a pair in a JSON dump of the similarity
+// model. Bag contains the features of the file and their weights
+type DumpFile struct {
+	BlobID       string
+	RepositoryID string
+	CommitHash   string
+	Path         string
+	Content      string
+	Bag          map[string]float64
+}
+
+// DumpPair is a pair of files of a JSON dump, with the similarity score given
+// by the model
+type DumpPair struct {
+	A     DumpFile
+	B     DumpFile
+	Score float64
+}
+
+// dumpFileJSON is used to decode a DumpFile, detecting missing fields
+type dumpFileJSON struct {
+	BlobID       *string            `json:"blob_id"`
+	RepositoryID *string            `json:"repository_id"`
+	CommitHash   *string            `json:"commit_hash"`
+	Path         *string            `json:"path"`
+	Content      *string            `json:"content"`
+	Bag          map[string]float64 `json:"bag"`
+}
+
+// MalformedPairError is returned by PairsDecoder.Next for the entries of the
+// dump that are valid JSON, but not a valid pair. The decoding can continue
+// after this error
+type MalformedPairError struct {
+	Index int
+	Err   error
+}
+
+func (e *MalformedPairError) Error() string {
+	return fmt.Sprintf("Malformed pair at index %d: %v", e.Index, e.Err)
+}
+
+// PairsDecoder reads the pairs of a JSON dump one by one, without loading the
+// whole dump in memory. The dump is a JSON array where each element is also an
+// array, with two file objects and the score:
+//
+//	[[{"blob_id": "3a6e6[..]196", "repository_id": "github.com/[..]git",
+//	   "commit_hash": "92[..]9d5", "path": "/src/[..].java",
+//	   "content": "file contents", "bag": {"r.[..]": 0.4536, ...}},
+//	  {...},
+//	  0.9512810301340767],
+//	 ...]
+type PairsDecoder struct {
+	dec     *json.Decoder
+	started bool
+	index   int
+}
+
+// NewPairsDecoder returns a new PairsDecoder that reads from r
+func NewPairsDecoder(r io.Reader) *PairsDecoder {
+	return &PairsDecoder{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next pair of the dump, or io.EOF when there are no more
+// pairs. If the entry is not a valid pair, it returns a *MalformedPairError
+// and the next call will continue with the following entry. Any other error
+// means that the dump can not be read anymore
+func (d *PairsDecoder) Next() (*DumpPair, error) {
+	if !d.started {
+		if err := d.expectDelim('['); err != nil {
+			return nil, err
+		}
+
+		d.started = true
+	}
+
+	if !d.dec.More() {
+		if err := d.expectDelim(']'); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("Failed to read pair at index %d: %v", d.index, err)
+	}
+
+	index := d.index
+	d.index++
+
+	pair, err := decodePair(raw)
+	if err != nil {
+		return nil, &MalformedPairError{Index: index, Err: err}
+	}
+
+	return pair, nil
+}
+
+// expectDelim reads the next token, that must be the given delimiter
+func (d *PairsDecoder) expectDelim(delim json.Delim) error {
+	token, err := d.dec.Token()
+	if err == io.EOF && delim == ']' {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("Wrong JSON dump, expected %q but found %v", delim, token)
+	}
+
+	return nil
+}
+
+// decodePair decodes and validates a [file, file, score] entry
+func decodePair(raw json.RawMessage) (*DumpPair, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(raw, &elements); err != nil {
+		return nil, fmt.Errorf("expected an array: %v", err)
+	}
+
+	if len(elements) != 3 {
+		return nil, fmt.Errorf("expected 3 elements, found %d", len(elements))
+	}
+
+	var pair DumpPair
+	var err error
+
+	if pair.A, err = decodeFile(elements[0]); err != nil {
+		return nil, fmt.Errorf("first file: %v", err)
+	}
+
+	if pair.B, err = decodeFile(elements[1]); err != nil {
+		return nil, fmt.Errorf("second file: %v", err)
+	}
+
+	if err := json.Unmarshal(elements[2], &pair.Score); err != nil {
+		return nil, fmt.Errorf("score: %v", err)
+	}
+
+	return &pair, nil
+}
+
+// decodeFile decodes a file object, checking that all its fields but the bag
+// are present
+func decodeFile(raw json.RawMessage) (DumpFile, error) {
+	var f dumpFileJSON
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return DumpFile{}, err
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"blob_id", f.BlobID},
+		{"repository_id", f.RepositoryID},
+		{"commit_hash", f.CommitHash},
+		{"path", f.Path},
+		{"content", f.Content},
+	}
+
+	for _, field := range fields {
+		if field.value == nil {
+			return DumpFile{}, fmt.Errorf("missing field %q", field.name)
+		}
+	}
+
+	return DumpFile{
+		BlobID:       *f.BlobID,
+		RepositoryID: *f.RepositoryID,
+		CommitHash:   *f.CommitHash,
+		Path:         *f.Path,
+		Content:      *f.Content,
+		Bag:          f.Bag,
+	}, nil
+}
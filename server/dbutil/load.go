@@ -0,0 +1,188 @@
+package dbutil
+
+import (
+	"database/sql"
+	"io"
+	"log"
+)
+
+const (
+	selectFeaturesBlobIDs = `SELECT DISTINCT blob_id FROM features`
+	insertFeatures        = `INSERT INTO features (blob_id, name, weight) VALUES ($1, $2, $3)`
+)
+
+// LoadStats counts the items processed by LoadDump
+type LoadStats struct {
+	// FilePairs counts the file pairs imported into the experiment
+	FilePairs ImportStats
+	// Malformed is the number of entries of the dump that are not valid pairs
+	Malformed int64
+	// Features is the number of new features
+	Features int64
+	// FeatureFailures is the number of features that could not be stored
+	FeatureFailures int64
+}
+
+// featuresImporter inserts the features of the files, once per blob ID
+type featuresImporter struct {
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	blobIDs map[string]bool
+	stats   *LoadStats
+	logger  *log.Logger
+}
+
+// newFeaturesImporter returns a featuresImporter that will use the given
+// transaction, skipping the blobs that already have features
+func newFeaturesImporter(tx *sql.Tx, stats *LoadStats, opts Options) (*featuresImporter, error) {
+	insert, err := tx.Prepare(insertFeatures)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(selectFeaturesBlobIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobIDs := make(map[string]bool)
+	for rows.Next() {
+		var blobID string
+		if err := rows.Scan(&blobID); err != nil {
+			return nil, err
+		}
+
+		blobIDs[blobID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &featuresImporter{
+		tx:      tx,
+		insert:  insert,
+		blobIDs: blobIDs,
+		stats:   stats,
+		logger:  opts.getLogger(),
+	}, nil
+}
+
+// importFeatures stores the features of the given file, if its blob ID was
+// not processed yet. It only returns the errors that abort the load
+func (imp *featuresImporter) importFeatures(f DumpFile) error {
+	if imp.blobIDs[f.BlobID] {
+		return nil
+	}
+	imp.blobIDs[f.BlobID] = true
+
+	for name, weight := range f.Bag {
+		res, rowErr, err := execRow(imp.tx, imp.insert, f.BlobID, name, weight)
+		if err != nil {
+			return err
+		}
+
+		if rowErr != nil {
+			imp.logger.Printf("Failed to insert feature\nerror: %v\n", rowErr)
+			imp.stats.FeatureFailures++
+			continue
+		}
+
+		rowsAffected, _ := res.RowsAffected()
+		imp.stats.Features += rowsAffected
+	}
+
+	return nil
+}
+
+// LoadDump reads a JSON dump of file pairs, as described in PairsDecoder, and
+// stores its file pairs into the given target experiment and the features of
+// its files, in a single pass.
+// File pairs are processed as in ImportFiles. Files that already have
+// features in the DB are skipped
+func LoadDump(r io.Reader, destDB DB, target TargetExperiment, opts Options) (LoadStats, error) {
+	logger := opts.getLogger()
+
+	tx, err := destDB.Begin()
+	if err != nil {
+		return LoadStats{}, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	experimentID, err := target.experimentID(tx, logger)
+	if err != nil {
+		return LoadStats{}, err
+	}
+
+	var stats LoadStats
+
+	pairs, err := newFilePairsImporter(tx, experimentID, opts)
+	if err != nil {
+		return LoadStats{}, err
+	}
+
+	features, err := newFeaturesImporter(tx, &stats, opts)
+	if err != nil {
+		return LoadStats{}, err
+	}
+
+	decoder := NewPairsDecoder(r)
+	for {
+		pair, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if _, ok := err.(*MalformedPairError); ok {
+			logger.Println(err)
+			stats.Malformed++
+			continue
+		}
+
+		if err != nil {
+			return LoadStats{}, err
+		}
+
+		err = pairs.importPair(
+			file{
+				blobID:       pair.A.BlobID,
+				repositoryID: pair.A.RepositoryID,
+				commitHash:   pair.A.CommitHash,
+				path:         pair.A.Path,
+				content:      pair.A.Content,
+			},
+			file{
+				blobID:       pair.B.BlobID,
+				repositoryID: pair.B.RepositoryID,
+				commitHash:   pair.B.CommitHash,
+				path:         pair.B.Path,
+				content:      pair.B.Content,
+			},
+			pair.Score)
+		if err != nil {
+			return LoadStats{}, err
+		}
+
+		for _, f := range []DumpFile{pair.A, pair.B} {
+			if err := features.importFeatures(f); err != nil {
+				return LoadStats{}, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return LoadStats{}, err
+	}
+
+	committed = true
+	stats.FilePairs = pairs.stats
+
+	return stats, nil
+}
@@ -0,0 +1,613 @@
+package dbutil
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	incrementTypePlaceholder = "<INCREMENT_TYPE>"
+	sqliteIncrementType      = "INTEGER"
+	posgresIncrementType     = "SERIAL"
+
+	createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER,
+		PRIMARY KEY (version))`
+	selectSchemaMigrations = `SELECT version FROM schema_migrations ORDER BY version`
+	// the statements that check if the schema_migrations table exists,
+	// without creating it
+	sqliteSchemaMigrationsExist   = `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'`
+	postgresSchemaMigrationsExist = `SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema=current_schema() AND table_name='schema_migrations'`
+	insertSchemaMigrations = `INSERT INTO schema_migrations (version) VALUES ($1)`
+	deleteSchemaMigrations = `DELETE FROM schema_migrations WHERE version=$1`
+)
+
+// statements are the SQL commands of a migration. The sqlite and postgres
+// statements are used instead of the common ones for that driver when they
+// are set. They may contain the <INCREMENT_TYPE> placeholder
+type statements struct {
+	all      []string
+	sqlite   []string
+	postgres []string
+}
+
+// forDriver returns the statements to run with the given driver
+func (s statements) forDriver(d driver) ([]string, error) {
+	var stmts []string
+	var colType string
+
+	switch d {
+	case sqlite:
+		stmts, colType = s.sqlite, sqliteIncrementType
+	case postgres:
+		stmts, colType = s.postgres, posgresIncrementType
+	default:
+		return nil, fmt.Errorf("Unknown driver type")
+	}
+
+	if stmts == nil {
+		stmts = s.all
+	}
+
+	result := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		result[i] = strings.Replace(stmt, incrementTypePlaceholder, colType, -1)
+	}
+
+	return result, nil
+}
+
+// migration changes the DB schema from the previous version to the next one
+type migration struct {
+	description string
+	up          statements
+	down        statements
+}
+
+// migrations are applied in order; the version of each migration is its
+// position in the list, starting with 1. Applied migrations must never be
+// modified, schema changes must be added as new migrations
+var migrations = []migration{
+	{
+		// the tables are created only if they do not exist, to adopt the DBs
+		// bootstrapped before the migrations were introduced
+		description: "create users, experiments, file_pairs, assignments and features",
+		up: statements{all: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+			id <INCREMENT_TYPE>, login TEXT UNIQUE, username TEXT, avatar_url TEXT, role TEXT,
+			PRIMARY KEY (id))`,
+			`CREATE TABLE IF NOT EXISTS experiments (
+			id <INCREMENT_TYPE>, name TEXT UNIQUE, description TEXT,
+			PRIMARY KEY (id))`,
+			// identical pairs are not constrained, ImportFiles skips them
+			`CREATE TABLE IF NOT EXISTS file_pairs (
+			id <INCREMENT_TYPE>,
+			blob_id_a TEXT, repository_id_a TEXT, commit_hash_a TEXT, path_a TEXT, content_a TEXT, hash_a TEXT,
+			blob_id_b TEXT, repository_id_b TEXT, commit_hash_b TEXT, path_b TEXT, content_b TEXT, hash_b TEXT,
+			score DOUBLE PRECISION, diff TEXT, experiment_id INTEGER,
+			PRIMARY KEY (id),
+			FOREIGN KEY(experiment_id) REFERENCES experiments(id))`,
+			`CREATE TABLE IF NOT EXISTS assignments (
+			id <INCREMENT_TYPE>,
+			user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
+			answer TEXT, duration INTEGER,
+			PRIMARY KEY (id),
+			UNIQUE (user_id, pair_id, experiment_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
+			FOREIGN KEY (experiment_id) REFERENCES experiments(id))`,
+			`CREATE TABLE IF NOT EXISTS features (
+			blob_id TEXT,
+			name TEXT, weight REAL,
+			PRIMARY KEY (blob_id, name))`,
+		}},
+		down: statements{all: []string{
+			`DROP TABLE features`,
+			`DROP TABLE assignments`,
+			`DROP TABLE file_pairs`,
+			`DROP TABLE experiments`,
+			`DROP TABLE users`,
+		}},
+	},
+	{
+		description: "add the assignments distribution policy to experiments",
+		up: statements{all: []string{
+			`ALTER TABLE experiments ADD COLUMN workers_per_pair INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE experiments ADD COLUMN shared_pairs_ratio DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE experiments DROP COLUMN workers_per_pair`,
+				`ALTER TABLE experiments DROP COLUMN shared_pairs_ratio`,
+			},
+			// SQLite can not drop columns, the table is rebuilt
+			sqlite: []string{
+				`CREATE TABLE experiments_down (
+				id INTEGER, name TEXT UNIQUE, description TEXT,
+				PRIMARY KEY (id))`,
+				`INSERT INTO experiments_down SELECT id, name, description FROM experiments`,
+				`DROP TABLE experiments`,
+				`ALTER TABLE experiments_down RENAME TO experiments`,
+			},
+		},
+	},
+	{
+		description: "add gold answers and the minimum worker accuracy to experiments",
+		up: statements{all: []string{
+			`CREATE TABLE IF NOT EXISTS gold_answers (
+			pair_id INTEGER, experiment_id INTEGER, answer TEXT,
+			PRIMARY KEY (pair_id),
+			FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
+			FOREIGN KEY (experiment_id) REFERENCES experiments(id))`,
+			`ALTER TABLE experiments ADD COLUMN min_gold_accuracy DOUBLE PRECISION NOT NULL DEFAULT 0`,
+			`ALTER TABLE experiments ADD COLUMN min_gold_answers INTEGER NOT NULL DEFAULT 0`,
+		}},
+		down: statements{
+			postgres: []string{
+				`DROP TABLE gold_answers`,
+				`ALTER TABLE experiments DROP COLUMN min_gold_accuracy`,
+				`ALTER TABLE experiments DROP COLUMN min_gold_answers`,
+			},
+			sqlite: []string{
+				`DROP TABLE gold_answers`,
+				`CREATE TABLE experiments_down (
+				id INTEGER, name TEXT UNIQUE, description TEXT,
+				workers_per_pair INTEGER NOT NULL DEFAULT 0,
+				shared_pairs_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+				PRIMARY KEY (id))`,
+				`INSERT INTO experiments_down SELECT id, name, description,
+				workers_per_pair, shared_pairs_ratio FROM experiments`,
+				`DROP TABLE experiments`,
+				`ALTER TABLE experiments_down RENAME TO experiments`,
+			},
+		},
+	},
+	{
+		description: "add the answer schema to experiments",
+		up: statements{all: []string{
+			// empty for the default schema
+			`ALTER TABLE experiments ADD COLUMN answer_schema TEXT NOT NULL DEFAULT ''`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE experiments DROP COLUMN answer_schema`,
+			},
+			sqlite: []string{
+				`CREATE TABLE experiments_down (
+				id INTEGER, name TEXT UNIQUE, description TEXT,
+				workers_per_pair INTEGER NOT NULL DEFAULT 0,
+				shared_pairs_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+				min_gold_accuracy DOUBLE PRECISION NOT NULL DEFAULT 0,
+				min_gold_answers INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (id))`,
+				`INSERT INTO experiments_down SELECT id, name, description,
+				workers_per_pair, shared_pairs_ratio, min_gold_accuracy, min_gold_answers
+				FROM experiments`,
+				`DROP TABLE experiments`,
+				`ALTER TABLE experiments_down RENAME TO experiments`,
+			},
+		},
+	},
+	{
+		description: "add comments and flags to assignments",
+		up: statements{all: []string{
+			`ALTER TABLE assignments ADD COLUMN comment TEXT NOT NULL DEFAULT ''`,
+			// comma separated list
+			`ALTER TABLE assignments ADD COLUMN flags TEXT NOT NULL DEFAULT ''`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE assignments DROP COLUMN comment`,
+				`ALTER TABLE assignments DROP COLUMN flags`,
+			},
+			sqlite: []string{
+				`CREATE TABLE assignments_down (
+				id INTEGER,
+				user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
+				answer TEXT, duration INTEGER,
+				PRIMARY KEY (id),
+				UNIQUE (user_id, pair_id, experiment_id),
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
+				FOREIGN KEY (experiment_id) REFERENCES experiments(id))`,
+				`INSERT INTO assignments_down SELECT id, user_id, pair_id, experiment_id,
+				answer, duration FROM assignments`,
+				`DROP TABLE assignments`,
+				`ALTER TABLE assignments_down RENAME TO assignments`,
+			},
+		},
+	},
+	{
+		description: "add the assignment events log",
+		up: statements{all: []string{
+			`CREATE TABLE IF NOT EXISTS assignment_events (
+			id <INCREMENT_TYPE>,
+			assignment_id INTEGER, user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
+			created_at TIMESTAMP,
+			old_answer TEXT, new_answer TEXT,
+			old_duration INTEGER, new_duration INTEGER,
+			old_comment TEXT, new_comment TEXT,
+			old_flags TEXT, new_flags TEXT,
+			client_ip TEXT, user_agent TEXT,
+			PRIMARY KEY (id),
+			FOREIGN KEY (assignment_id) REFERENCES assignments(id))`,
+			`CREATE INDEX assignment_events_pair_id ON assignment_events (pair_id)`,
+			`CREATE INDEX assignment_events_user_id ON assignment_events (user_id)`,
+		}},
+		down: statements{all: []string{
+			`DROP TABLE assignment_events`,
+		}},
+	},
+	{
+		// the existing rows keep null timestamps, their times are unknown
+		description: "add timestamps to users, experiments, file pairs and assignments",
+		up: statements{all: []string{
+			`ALTER TABLE users ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN last_login_at TIMESTAMP`,
+			`ALTER TABLE experiments ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE experiments ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE file_pairs ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE file_pairs ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE assignments ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE assignments ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE assignments ADD COLUMN answered_at TIMESTAMP`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE users DROP COLUMN created_at`,
+				`ALTER TABLE users DROP COLUMN updated_at`,
+				`ALTER TABLE users DROP COLUMN last_login_at`,
+				`ALTER TABLE experiments DROP COLUMN created_at`,
+				`ALTER TABLE experiments DROP COLUMN updated_at`,
+				`ALTER TABLE file_pairs DROP COLUMN created_at`,
+				`ALTER TABLE file_pairs DROP COLUMN updated_at`,
+				`ALTER TABLE assignments DROP COLUMN created_at`,
+				`ALTER TABLE assignments DROP COLUMN updated_at`,
+				`ALTER TABLE assignments DROP COLUMN answered_at`,
+			},
+			sqlite: []string{
+				`CREATE TABLE users_down (
+				id INTEGER, login TEXT UNIQUE, username TEXT, avatar_url TEXT, role TEXT,
+				PRIMARY KEY (id))`,
+				`INSERT INTO users_down SELECT id, login, username, avatar_url, role FROM users`,
+				`DROP TABLE users`,
+				`ALTER TABLE users_down RENAME TO users`,
+
+				`CREATE TABLE experiments_down (
+				id INTEGER, name TEXT UNIQUE, description TEXT,
+				workers_per_pair INTEGER NOT NULL DEFAULT 0,
+				shared_pairs_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+				min_gold_accuracy DOUBLE PRECISION NOT NULL DEFAULT 0,
+				min_gold_answers INTEGER NOT NULL DEFAULT 0,
+				answer_schema TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (id))`,
+				`INSERT INTO experiments_down SELECT id, name, description,
+				workers_per_pair, shared_pairs_ratio, min_gold_accuracy, min_gold_answers,
+				answer_schema FROM experiments`,
+				`DROP TABLE experiments`,
+				`ALTER TABLE experiments_down RENAME TO experiments`,
+
+				`CREATE TABLE file_pairs_down (
+				id INTEGER,
+				blob_id_a TEXT, repository_id_a TEXT, commit_hash_a TEXT, path_a TEXT, content_a TEXT, hash_a TEXT,
+				blob_id_b TEXT, repository_id_b TEXT, commit_hash_b TEXT, path_b TEXT, content_b TEXT, hash_b TEXT,
+				score DOUBLE PRECISION, diff TEXT, experiment_id INTEGER,
+				PRIMARY KEY (id),
+				FOREIGN KEY(experiment_id) REFERENCES experiments(id))`,
+				`INSERT INTO file_pairs_down SELECT id,
+				blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a,
+				blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b,
+				score, diff, experiment_id FROM file_pairs`,
+				`DROP TABLE file_pairs`,
+				`ALTER TABLE file_pairs_down RENAME TO file_pairs`,
+
+				`CREATE TABLE assignments_down (
+				id INTEGER,
+				user_id INTEGER, pair_id INTEGER, experiment_id INTEGER,
+				answer TEXT, duration INTEGER,
+				comment TEXT NOT NULL DEFAULT '', flags TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (id),
+				UNIQUE (user_id, pair_id, experiment_id),
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (pair_id) REFERENCES file_pairs(id),
+				FOREIGN KEY (experiment_id) REFERENCES experiments(id))`,
+				`INSERT INTO assignments_down SELECT id, user_id, pair_id, experiment_id,
+				answer, duration, comment, flags FROM assignments`,
+				`DROP TABLE assignments`,
+				`ALTER TABLE assignments_down RENAME TO assignments`,
+			},
+		},
+	},
+	{
+		description: "add the sessions of the issued tokens",
+		up: statements{all: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT, user_id INTEGER,
+			created_at TIMESTAMP, expires_at TIMESTAMP, revoked_at TIMESTAMP,
+			PRIMARY KEY (id),
+			FOREIGN KEY (user_id) REFERENCES users(id))`,
+			`CREATE INDEX sessions_user_id ON sessions (user_id)`,
+		}},
+		down: statements{all: []string{
+			`DROP TABLE sessions`,
+		}},
+	},
+	{
+		// the existing users logged in with GitHub, the only provider until now
+		description: "add the authentication provider and password hash to users",
+		up: statements{all: []string{
+			`ALTER TABLE users ADD COLUMN provider TEXT NOT NULL DEFAULT 'github'`,
+			`ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE users DROP COLUMN provider`,
+				`ALTER TABLE users DROP COLUMN password_hash`,
+			},
+			sqlite: []string{
+				`CREATE TABLE users_down (
+				id INTEGER, login TEXT UNIQUE, username TEXT, avatar_url TEXT, role TEXT,
+				created_at TIMESTAMP, updated_at TIMESTAMP, last_login_at TIMESTAMP,
+				PRIMARY KEY (id))`,
+				`INSERT INTO users_down SELECT id, login, username, avatar_url, role,
+				created_at, updated_at, last_login_at FROM users`,
+				`DROP TABLE users`,
+				`ALTER TABLE users_down RENAME TO users`,
+			},
+		},
+	},
+	{
+		description: "add the deactivation time to users",
+		up: statements{all: []string{
+			`ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP`,
+		}},
+		down: statements{
+			postgres: []string{
+				`ALTER TABLE users DROP COLUMN deactivated_at`,
+			},
+			sqlite: []string{
+				`CREATE TABLE users_down (
+				id INTEGER, login TEXT UNIQUE, username TEXT, avatar_url TEXT, role TEXT,
+				created_at TIMESTAMP, updated_at TIMESTAMP, last_login_at TIMESTAMP,
+				provider TEXT NOT NULL DEFAULT 'github', password_hash TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (id))`,
+				`INSERT INTO users_down SELECT id, login, username, avatar_url, role,
+				created_at, updated_at, last_login_at, provider, password_hash FROM users`,
+				`DROP TABLE users`,
+				`ALTER TABLE users_down RENAME TO users`,
+			},
+		},
+	},
+	{
+		// the workers of the pairs are counted when assigning them
+		description: "index the assignments by experiment and pair",
+		up: statements{all: []string{
+			`CREATE INDEX assignments_experiment_id_pair_id ON assignments (experiment_id, pair_id)`,
+		}},
+		down: statements{all: []string{
+			`DROP INDEX assignments_experiment_id_pair_id`,
+		}},
+	},
+}
+
+// MigrationStatus describes a migration, and whether it is applied to a DB
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+}
+
+// LatestSchemaVersion returns the schema version required by this code
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// schemaMigrationsExist returns true if the DB has the schema_migrations
+// table
+func schemaMigrationsExist(db DB) (bool, error) {
+	var query string
+	switch db.driver {
+	case sqlite:
+		query = sqliteSchemaMigrationsExist
+	case postgres:
+		query = postgresSchemaMigrationsExist
+	default:
+		return false, fmt.Errorf("Unknown driver type")
+	}
+
+	var count int
+	err := db.QueryRow(query).Scan(&count)
+	return count > 0, err
+}
+
+// appliedVersions returns the set of versions applied to the DB. It does not
+// change the DB; if the schema_migrations table does not exist, no versions
+// are applied
+func appliedVersions(db DB) (map[int]bool, error) {
+	exist, err := schemaMigrationsExist(db)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]bool)
+	if !exist {
+		return versions, nil
+	}
+
+	rows, err := db.Query(selectSchemaMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		versions[version] = true
+	}
+
+	return versions, rows.Err()
+}
+
+// SchemaVersion returns the version of the DB schema, which is the last
+// applied migration. A DB without migrations has version 0
+func SchemaVersion(db DB) (int, error) {
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for version := range versions {
+		if version > current {
+			current = version
+		}
+	}
+
+	return current, nil
+}
+
+// Status returns the status of all the known migrations for the DB
+func Status(db DB) ([]MigrationStatus, error) {
+	versions, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{
+			Version:     i + 1,
+			Description: m.description,
+			Applied:     versions[i+1],
+		}
+	}
+
+	return status, nil
+}
+
+// CheckSchema returns an error if the DB schema version is not the one
+// required by this code
+func CheckSchema(db DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+
+	switch {
+	case current < latest:
+		return fmt.Errorf(
+			"DB schema is outdated; version %d found, version %d required. Run the migrate up command",
+			current, latest)
+	case current > latest:
+		return fmt.Errorf(
+			"DB schema version %d is newer than the supported version %d",
+			current, latest)
+	default:
+		return nil
+	}
+}
+
+// MigrateUp applies all the pending migrations to the DB, in order. It
+// returns the number of applied migrations
+func MigrateUp(db DB) (int, error) {
+	if _, err := db.Exec(createSchemaMigrations); err != nil {
+		return 0, err
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for version := current + 1; version <= len(migrations); version++ {
+		m := migrations[version-1]
+
+		stmts, err := m.up.forDriver(db.driver)
+		if err != nil {
+			return applied, err
+		}
+
+		if err := runMigration(db, stmts, insertSchemaMigrations, version); err != nil {
+			return applied, fmt.Errorf("Failed to apply migration %d (%s): %v",
+				version, m.description, err)
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps migrations applied to the DB. It returns
+// the number of reverted migrations
+func MigrateDown(db DB, steps int) (int, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if current > len(migrations) {
+		return 0, fmt.Errorf("DB schema version %d is newer than the supported version %d",
+			current, len(migrations))
+	}
+
+	reverted := 0
+	for version := current; version > 0 && reverted < steps; version-- {
+		m := migrations[version-1]
+
+		stmts, err := m.down.forDriver(db.driver)
+		if err != nil {
+			return reverted, err
+		}
+
+		if err := runMigration(db, stmts, deleteSchemaMigrations, version); err != nil {
+			return reverted, fmt.Errorf("Failed to revert migration %d (%s): %v",
+				version, m.description, err)
+		}
+
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// runMigration runs the given statements, and records the version with
+// versionCmd, in a single transaction
+func runMigration(db DB, stmts []string, versionCmd string, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := execAll(tx, stmts); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(versionCmd, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execAll(tx *sql.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
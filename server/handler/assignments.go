@@ -1,21 +1,64 @@
 package handler
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"database/sql"
+	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
 )
 
+// getRequestedExperiment returns the experiment identified by the
+// "experimentId" URL parameter, or a 404 error if it does not exist
+func getRequestedExperiment(r *http.Request, repo repository.ExperimentStore) (*model.Experiment, error) {
+	experimentID, err := urlParamInt(r, "experimentId")
+	if err != nil {
+		return nil, err
+	}
+
+	experiment, err := repo.GetByID(experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if experiment == nil {
+		return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+	}
+
+	return experiment, nil
+}
+
+// workerBlocked returns true if the accuracy of the user on the gold pairs of
+// the experiment is too low to get more assignments
+func workerBlocked(repo repository.GoldAnswerStore, experiment *model.Experiment, userID int) (bool, error) {
+	if experiment.MinGoldAccuracy <= 0 {
+		return false, nil
+	}
+
+	accuracy, err := repo.UserAccuracy(userID, experiment.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return experiment.BlocksWorker(accuracy), nil
+}
+
 // GetAssignmentsForUserExperiment returns a function that returns a *serializer.Response
 // with the assignments for the logged user and a passed experiment
-// if these assignments do not already exist, they are created in advance
-func GetAssignmentsForUserExperiment(repo *repository.Assignments) RequestProcessFunc {
+// if these assignments do not already exist, they are created in advance.
+// When the experiment limits the workers per pair, the assignments are
+// created one at a time, once all the previous ones are answered. Workers
+// with a low accuracy on gold pairs only get their answered assignments
+func GetAssignmentsForUserExperiment(repo repository.AssignmentStore,
+	experimentRepo repository.ExperimentStore, goldRepo repository.GoldAnswerStore) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
-		experimentID, err := urlParamInt(r, "experimentId")
+		experiment, err := getRequestedExperiment(r, experimentRepo)
 		if err != nil {
 			return nil, err
 		}
@@ -25,25 +68,198 @@ func GetAssignmentsForUserExperiment(repo *repository.Assignments) RequestProces
 			return nil, err
 		}
 
-		assignments, err := repo.GetAll(userID, experimentID)
-		if err == repository.ErrNoAssignmentsInitialized {
-			if assignments, err = repo.Initialize(userID, experimentID); err != nil {
-				return nil, err
+		assignments, err := repo.GetAll(userID, experiment.ID)
+		initialized := err != repository.ErrNoAssignmentsInitialized
+		if err != nil && initialized {
+			return nil, err
+		}
+
+		blocked, err := workerBlocked(goldRepo, experiment, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if blocked {
+			answered := []*model.Assignment{}
+			for _, a := range assignments {
+				if a.Answer.Valid {
+					answered = append(answered, a)
+				}
+			}
+
+			return serializer.NewAssignmentsResponse(answered), nil
+		}
+
+		if experiment.WorkersPerPair == 0 {
+			if !initialized {
+				if assignments, err = repo.Initialize(userID, experiment.ID); err != nil {
+					return nil, err
+				}
 			}
+
+			return serializer.NewAssignmentsResponse(assignments), nil
+		}
+
+		for _, a := range assignments {
+			if !a.Answer.Valid {
+				return serializer.NewAssignmentsResponse(assignments), nil
+			}
+		}
+
+		next, _, err := repo.AssignNext(userID, experiment)
+		if err != nil {
+			return nil, err
+		}
+
+		if next != nil {
+			assignments = append(assignments, next)
 		}
 
 		return serializer.NewAssignmentsResponse(assignments), nil
 	}
 }
 
+// GetNextAssignment returns a function that returns a *serializer.Response
+// with the next unanswered assignment for the logged user and a passed
+// experiment, and the progress of the user. The order can be chosen with the
+// "order" query parameter: import (default), random, score-asc or score-desc.
+// If the assignments do not already exist, they are created in advance, unless
+// the experiment limits the workers per pair; then they are created as needed.
+// Workers with a low accuracy on gold pairs get a 403 error
+func GetNextAssignment(repo repository.AssignmentStore,
+	experimentRepo repository.ExperimentStore, goldRepo repository.GoldAnswerStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		experimentID := experiment.ID
+
+		userID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		order := repository.ImportOrder
+		if param := r.URL.Query().Get("order"); param != "" {
+			order = repository.QueueOrder(param)
+		}
+
+		if !order.Valid() {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Wrong order %q", order))
+		}
+
+		blocked, err := workerBlocked(goldRepo, experiment, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if blocked {
+			return nil, serializer.NewHTTPError(http.StatusForbidden,
+				"accuracy on the gold pairs is too low to get more assignments")
+		}
+
+		if experiment.WorkersPerPair > 0 {
+			return nextDistributedAssignment(repo, userID, experiment, order)
+		}
+
+		answered, total, err := repo.Progress(userID, experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if total == 0 {
+			if _, err = repo.Initialize(userID, experimentID); err != nil &&
+				err != repository.ErrNoAssignmentsInitialized {
+				return nil, err
+			}
+
+			if answered, total, err = repo.Progress(userID, experimentID); err != nil {
+				return nil, err
+			}
+		}
+
+		assignment, err := repo.GetNextUnanswered(userID, experimentID, order)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewNextAssignmentResponse(assignment, answered, total), nil
+	}
+}
+
+// nextDistributedAssignment returns the next unanswered assignment for an
+// experiment with a limited number of workers per pair, creating a new one if
+// needed. The total reported in the progress includes the pairs that could
+// still be assigned to the user
+func nextDistributedAssignment(repo repository.AssignmentStore, userID int,
+	experiment *model.Experiment, order repository.QueueOrder) (*serializer.Response, error) {
+	assignment, err := repo.GetNextUnanswered(userID, experiment.ID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	var available int
+	if assignment == nil {
+		assignment, available, err = repo.AssignNext(userID, experiment)
+	} else {
+		available, err = repo.Available(userID, experiment)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	answered, total, err := repo.Progress(userID, experiment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return serializer.NewNextAssignmentResponse(assignment, answered, total+available), nil
+}
+
 type assignmentRequest struct {
-	Answer   string `json:"answer"`
-	Duration int    `json:"duration"`
+	Answer   string   `json:"answer"`
+	Duration int      `json:"duration"`
+	Comment  string   `json:"comment"`
+	Flags    []string `json:"flags"`
+}
+
+// readFlags returns the sorted Flags of the request, or a
+// serializer.NewHTTPError if any of them is unknown
+func readFlags(flags []string) ([]model.Flag, error) {
+	seen := make(map[model.Flag]bool, len(flags))
+	result := make([]model.Flag, 0, len(flags))
+	for _, f := range flags {
+		flag, ok := model.Flags[model.Flag(f)]
+		if !ok {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Wrong flag %q", f))
+		}
+
+		if !seen[flag] {
+			seen[flag] = true
+			result = append(result, flag)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
 }
 
-// SaveAssignment returns a function that saves the user answers as passed in the body request
-func SaveAssignment(repo *repository.Assignments) RequestProcessFunc {
+// SaveAssignment returns a function that saves the user answers as passed in the body request,
+// validated against the answer schema of the experiment, along with an optional comment and flags.
+// Workers with a low accuracy on gold pairs can only change the assignments already answered
+func SaveAssignment(repo repository.AssignmentStore,
+	experimentRepo repository.ExperimentStore, goldRepo repository.GoldAnswerStore) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
 		assignmentID, err := urlParamInt(r, "assignmentId")
 		if err != nil {
 			return nil, err
@@ -54,7 +270,7 @@ func SaveAssignment(repo *repository.Assignments) RequestProcessFunc {
 			return nil, err
 		}
 
-		if assignment == nil {
+		if assignment == nil || assignment.ExperimentID != experimentID {
 			return nil, serializer.NewHTTPError(http.StatusNotFound, "assignment not found")
 		}
 
@@ -69,17 +285,47 @@ func SaveAssignment(repo *repository.Assignments) RequestProcessFunc {
 		}
 
 		var assignmentRequest assignmentRequest
-		body, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
-		if err == nil {
-			err = json.Unmarshal(body, &assignmentRequest)
+		if err := readJSON(r, &assignmentRequest); err != nil {
+			return nil, err
+		}
+
+		experiment, err := experimentRepo.GetByID(assignment.ExperimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if !assignment.Answer.Valid {
+			blocked, err := workerBlocked(goldRepo, experiment, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			if blocked {
+				return nil, serializer.NewHTTPError(http.StatusForbidden,
+					"accuracy on the gold pairs is too low to answer more assignments")
+			}
+		}
+
+		answer, err := experiment.AnswerSchema.Normalize(assignmentRequest.Answer)
+		if err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 
+		flags, err := readFlags(assignmentRequest.Flags)
 		if err != nil {
 			return nil, err
 		}
 
-		err = repo.Update(assignmentID, assignmentRequest.Answer, assignmentRequest.Duration)
+		assignment.Answer = sql.NullString{String: answer, Valid: true}
+		assignment.Duration = assignmentRequest.Duration
+		assignment.Comment = strings.TrimSpace(assignmentRequest.Comment)
+		assignment.Flags = flags
+
+		err = repo.Update(assignment, clientInfo(r))
 		if err != nil {
 			return nil, err
 		}
@@ -87,3 +333,65 @@ func SaveAssignment(repo *repository.Assignments) RequestProcessFunc {
 		return serializer.NewCountResponse(1), nil
 	}
 }
+
+// clientInfo returns the model.ClientInfo of the client making the request
+func clientInfo(r *http.Request) model.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return model.ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
+
+// GetAssignmentEvents returns a function that returns a *serializer.Response
+// with a page of the changes made to the assignments of the requested
+// experiment, optionally only for the "pairId" or "userId" query parameters
+func GetAssignmentEvents(experimentRepo repository.ExperimentStore, repo repository.AssignmentEventStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		filter := repository.EventsFilter{ExperimentID: experiment.ID}
+		if filter.PairID, err = queryParamInt(r, "pairId", 0); err != nil {
+			return nil, err
+		}
+
+		if filter.UserID, err = queryParamInt(r, "userId", 0); err != nil {
+			return nil, err
+		}
+
+		limit, offset, err := pagination(r)
+		if err != nil {
+			return nil, err
+		}
+
+		events, err := repo.List(filter, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewAssignmentEventsResponse(events), nil
+	}
+}
+
+// GetFlaggedPairs returns a function that returns a *serializer.Response
+// with the file pairs of the requested experiment flagged by any worker,
+// along with their flagged assignments
+func GetFlaggedPairs(experimentRepo repository.ExperimentStore, repo repository.AssignmentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments, err := repo.GetFlagged(experiment.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewFlaggedPairsResponse(assignments), nil
+	}
+}
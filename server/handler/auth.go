@@ -12,50 +12,79 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Login handler redirects user to oauth provider
-func Login(oAuth *service.OAuth) http.HandlerFunc {
+// Login handler starts the log in with the authentication provider
+func Login(provider service.Provider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		url := oAuth.MakeAuthURL(w, r)
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		provider.Login(w, r)
 	}
 }
 
-// OAuthCallback makes exchange with oauth provider, gets&creates user and redirects to index page with JWT token
+// OAuthCallback finishes the log in with the authentication provider, gets&creates user and redirects to index page with JWT token
 func OAuthCallback(
-	oAuth *service.OAuth,
+	provider service.Provider,
 	jwt *service.JWT,
-	userRepo *repository.Users,
+	access *service.Access,
+	userRepo repository.UserStore,
 	uiDomain string,
 	logger logrus.FieldLogger,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := oAuth.ValidateState(r, r.FormValue("state")); err != nil {
+		authUser, err := provider.Callback(r)
+		switch {
+		case err == service.ErrInvalidState:
 			write(w, r, serializer.NewEmptyResponse(), serializer.NewHTTPError(http.StatusBadRequest))
 			return
-		}
-
-		code := r.FormValue("code")
-		ghUser, err := oAuth.GetUser(r.Context(), code)
-		if err != nil {
-			logger.Errorf("oauth get user error: %s", err)
+		case err == service.ErrInvalidCredentials:
+			write(w, r, serializer.NewEmptyResponse(),
+				serializer.NewHTTPError(http.StatusUnauthorized, err.Error()))
+			return
+		case err == service.ErrNotAllowed:
+			logger.Warnf("%s user is not allowed to log in", provider.Name())
+			write(w, r, serializer.NewEmptyResponse(),
+				serializer.NewHTTPError(http.StatusForbidden, err.Error()))
+			return
+		case err != nil:
+			logger.Errorf("%s get user error: %s", provider.Name(), err)
 			// FIXME can it be not server error? for wrong code
 			write(w, r, serializer.NewEmptyResponse(), err)
 			return
 		}
 
-		user, err := userRepo.Get(ghUser.Login)
+		if !access.Allowed(authUser.Login) {
+			logger.Warnf("user %q is not allowed to log in", authUser.Login)
+			write(w, r, serializer.NewEmptyResponse(),
+				serializer.NewHTTPError(http.StatusForbidden, "user is not allowed to log in"))
+			return
+		}
+
+		user, err := userRepo.Get(authUser.Login)
 		if err != nil {
 			logger.Error(err)
 			write(w, r, serializer.NewEmptyResponse(), err)
 			return
 		}
 
+		if user != nil && user.Provider != provider.Name() {
+			logger.Warnf("user %q is registered with the %s provider", user.Login, user.Provider)
+			write(w, r, serializer.NewEmptyResponse(),
+				serializer.NewHTTPError(http.StatusForbidden, "user is registered with another provider"))
+			return
+		}
+
+		if user != nil && !user.Active() {
+			logger.Warnf("user %q is deactivated", user.Login)
+			write(w, r, serializer.NewEmptyResponse(),
+				serializer.NewHTTPError(http.StatusForbidden, "user is deactivated"))
+			return
+		}
+
 		if user == nil {
 			user = &model.User{
-				Login:     ghUser.Login,
-				Username:  ghUser.Username,
-				AvatarURL: ghUser.AvatarURL,
-				Role:      model.Requester}
+				Login:     authUser.Login,
+				Username:  authUser.Username,
+				AvatarURL: authUser.AvatarURL,
+				Role:      userRole(access, authUser, ""),
+				Provider:  provider.Name()}
 
 			err = userRepo.Create(user)
 			if err != nil {
@@ -63,6 +92,21 @@ func OAuthCallback(
 				write(w, r, serializer.NewEmptyResponse(), err)
 				return
 			}
+		} else if role := userRole(access, authUser, user.Role); role != user.Role {
+			user.Role = role
+
+			err = userRepo.Update(user)
+			if err != nil {
+				logger.Errorf("can't update user role: %s", err)
+				write(w, r, serializer.NewEmptyResponse(), err)
+				return
+			}
+		}
+
+		if err := userRepo.RecordLogin(user); err != nil {
+			logger.Errorf("can't record user login: %s", err)
+			write(w, r, serializer.NewEmptyResponse(), err)
+			return
 		}
 
 		token, err := jwt.MakeToken(user)
@@ -75,3 +119,145 @@ func OAuthCallback(
 		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	}
 }
+
+// userRole returns the Role of the user that logs in, decided by access.
+// current is the Role already stored for the user, or an empty one for new
+// users, that get the Role given by the provider, if any. So the Roles changed
+// by the requesters are kept on the next log in
+func userRole(access *service.Access, authUser *service.AuthUser, current model.Role) model.Role {
+	if current == "" {
+		current = authUser.Role
+	}
+
+	return access.Role(authUser.Login, current)
+}
+
+// RefreshToken returns a function that returns a *serializer.Response with a
+// new token for the user of the request token, that can be expired. The old
+// token is revoked, and the new one can be refreshed until the same time. The
+// new token gets the current role of the user, and it is refused to
+// deactivated users
+func RefreshToken(jwt *service.JWT, userRepo repository.UserStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, tokenID, err := jwt.ParseRefresh(r)
+		if err == service.ErrInvalidToken {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := userRepo.GetByID(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, "user not found")
+		}
+
+		if !user.Active() {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, "user is deactivated")
+		}
+
+		token, err := jwt.Refresh(user, tokenID)
+		if err == service.ErrInvalidToken {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewTokenResponse(token), nil
+	}
+}
+
+// Logout returns a function that revokes the token of the request
+func Logout(jwt *service.JWT) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		tokenID, err := service.GetTokenID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		err = jwt.Revoke(tokenID)
+		if err == service.ErrInvalidToken {
+			return nil, serializer.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewCountResponse(1), nil
+	}
+}
+
+// RequireActiveUser returns a middleware that only lets through the requests of
+// users that still exist, are active and have the role of their token. It
+// must be used after the JWT middleware. The tokens are revoked when a user is
+// deactivated or its role changes; this rejects the ones used meanwhile
+func RequireActiveUser(userRepo repository.UserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := service.GetUserID(r.Context())
+			if err != nil {
+				write(w, r, serializer.NewEmptyResponse(), err)
+				return
+			}
+
+			role, err := service.GetUserRole(r.Context())
+			if err != nil {
+				write(w, r, serializer.NewEmptyResponse(), err)
+				return
+			}
+
+			user, err := userRepo.GetByID(userID)
+			if err != nil {
+				write(w, r, serializer.NewEmptyResponse(), err)
+				return
+			}
+
+			switch {
+			case user == nil:
+				err = serializer.NewHTTPError(http.StatusUnauthorized, "user not found")
+			case !user.Active():
+				err = serializer.NewHTTPError(http.StatusUnauthorized, "user is deactivated")
+			case user.Role != role:
+				err = serializer.NewHTTPError(http.StatusUnauthorized, "the role of the user changed")
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			write(w, r, serializer.NewEmptyResponse(), err)
+		})
+	}
+}
+
+// RequireRole returns a middleware that only lets through the requests of
+// logged users with one of the given roles. It must be used after the
+// JWT middleware
+func RequireRole(roles ...model.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, err := service.GetUserRole(r.Context())
+			if err != nil {
+				write(w, r, serializer.NewEmptyResponse(), err)
+				return
+			}
+
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			write(w, r, serializer.NewEmptyResponse(), serializer.NewHTTPError(
+				http.StatusForbidden, fmt.Sprintf("%s users are not allowed", role)))
+		})
+	}
+}
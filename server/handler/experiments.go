@@ -1,15 +1,160 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
 
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
+	"github.com/src-d/code-annotation/server/service"
 )
 
+// GetExperiments returns a function that returns a *serializer.Response
+// with a page of the existing experiments
+func GetExperiments(repo repository.ExperimentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		limit, offset, err := pagination(r)
+		if err != nil {
+			return nil, err
+		}
+
+		experiments, err := repo.List(limit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentsResponse(experiments), nil
+	}
+}
+
 // GetExperimentDetails returns a function that returns a *serializer.Response
 // with the details of a requested experiment
-func GetExperimentDetails(repo *repository.Experiments) RequestProcessFunc {
+func GetExperimentDetails(repo repository.ExperimentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		return serializer.NewExperimentResponse(experiment), nil
+	}
+}
+
+type experimentRequest struct {
+	Name             string  `json:"name"`
+	Description      string  `json:"description"`
+	WorkersPerPair   int     `json:"workersPerPair"`
+	SharedPairsRatio float64 `json:"sharedPairsRatio"`
+	MinGoldAccuracy  float64 `json:"minGoldAccuracy"`
+	MinGoldAnswers   int     `json:"minGoldAnswers"`
+	// AnswerSchema is optional, experiments are created with the default one
+	// and keep theirs on updates
+	AnswerSchema *model.AnswerSchema `json:"answerSchema"`
+}
+
+// readExperimentRequest reads and validates the experiment sent in the body
+// request
+func readExperimentRequest(r *http.Request) (*experimentRequest, error) {
+	var req experimentRequest
+	if err := readJSON(r, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, "experiment name is required")
+	}
+
+	if req.WorkersPerPair < 0 {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, "workersPerPair can not be negative")
+	}
+
+	if req.SharedPairsRatio < 0 || req.SharedPairsRatio > 1 {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, "sharedPairsRatio must be between 0 and 1")
+	}
+
+	if req.MinGoldAccuracy < 0 || req.MinGoldAccuracy > 1 {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, "minGoldAccuracy must be between 0 and 1")
+	}
+
+	if req.MinGoldAnswers < 0 {
+		return nil, serializer.NewHTTPError(http.StatusBadRequest, "minGoldAnswers can not be negative")
+	}
+
+	if req.AnswerSchema != nil {
+		if err := req.AnswerSchema.Check(); err != nil {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("wrong answerSchema: %s", err))
+		}
+	}
+
+	return &req, nil
+}
+
+// checkExperimentName returns a serializer.NewHTTPError if there is an
+// experiment with the given name, other than the one identified by ID
+func checkExperimentName(repo repository.ExperimentStore, name string, id int) error {
+	existing, err := repo.GetByName(name)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && existing.ID != id {
+		return serializer.NewHTTPError(http.StatusConflict, "experiment name already exists")
+	}
+
+	return nil
+}
+
+// CreateExperiment returns a function that creates the experiment passed in
+// the body request
+func CreateExperiment(repo repository.ExperimentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		req, err := readExperimentRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkExperimentName(repo, req.Name, 0); err != nil {
+			return nil, err
+		}
+
+		experiment := &model.Experiment{
+			Name:             req.Name,
+			Description:      req.Description,
+			WorkersPerPair:   req.WorkersPerPair,
+			SharedPairsRatio: req.SharedPairsRatio,
+			MinGoldAccuracy:  req.MinGoldAccuracy,
+			MinGoldAnswers:   req.MinGoldAnswers,
+			AnswerSchema:     model.DefaultAnswerSchema,
+		}
+
+		if req.AnswerSchema != nil {
+			experiment.AnswerSchema = *req.AnswerSchema
+		}
+		if err := repo.Create(experiment); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewExperimentResponse(experiment), nil
+	}
+}
+
+// UpdateExperiment returns a function that updates the requested experiment
+// with the values passed in the body request. Its answer schema can only be
+// changed to one that accepts the answers and gold answers already stored
+func UpdateExperiment(repo repository.ExperimentStore, assignmentsRepo repository.AssignmentStore,
+	goldRepo repository.GoldAnswerStore) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		experimentID, err := urlParamInt(r, "experimentId")
 		if err != nil {
@@ -25,6 +170,133 @@ func GetExperimentDetails(repo *repository.Experiments) RequestProcessFunc {
 			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
 		}
 
+		req, err := readExperimentRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkExperimentName(repo, req.Name, experiment.ID); err != nil {
+			return nil, err
+		}
+
+		experiment.Name = req.Name
+		experiment.Description = req.Description
+		experiment.WorkersPerPair = req.WorkersPerPair
+		experiment.SharedPairsRatio = req.SharedPairsRatio
+		experiment.MinGoldAccuracy = req.MinGoldAccuracy
+		experiment.MinGoldAnswers = req.MinGoldAnswers
+		if req.AnswerSchema != nil && !reflect.DeepEqual(*req.AnswerSchema, experiment.AnswerSchema) {
+			if err := checkStoredAnswers(req.AnswerSchema, experiment.ID, assignmentsRepo, goldRepo); err != nil {
+				return nil, err
+			}
+
+			experiment.AnswerSchema = *req.AnswerSchema
+		}
+		if err := repo.Update(experiment); err != nil {
+			return nil, err
+		}
+
 		return serializer.NewExperimentResponse(experiment), nil
 	}
 }
+
+// checkStoredAnswers returns a serializer.NewHTTPError if any answer or gold
+// answer already stored for the experiment is not accepted as is by the given
+// AnswerSchema, so its schema can not be changed to it
+func checkStoredAnswers(schema *model.AnswerSchema, experimentID int,
+	assignmentsRepo repository.AssignmentStore, goldRepo repository.GoldAnswerStore) error {
+	answered, err := assignmentsRepo.GetAnswered(experimentID)
+	if err != nil {
+		return err
+	}
+
+	for _, as := range answered {
+		if answer, err := schema.Normalize(as.Answer.String); err != nil || answer != as.Answer.String {
+			return serializer.NewHTTPError(http.StatusConflict,
+				fmt.Sprintf("answer %q of assignment %d does not match the new answer schema",
+					as.Answer.String, as.ID))
+		}
+	}
+
+	gold, err := goldRepo.GetByExperiment(experimentID)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range gold {
+		if answer, err := schema.Normalize(g.Answer); err != nil || answer != g.Answer {
+			return serializer.NewHTTPError(http.StatusConflict,
+				fmt.Sprintf("gold answer %q of pair %d does not match the new answer schema",
+					g.Answer, g.PairID))
+		}
+	}
+
+	return nil
+}
+
+// DeleteExperiment returns a function that deletes the requested experiment,
+// along with its file pairs and assignments
+func DeleteExperiment(repo repository.ExperimentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		if err := repo.Delete(experimentID); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewCountResponse(1), nil
+	}
+}
+
+// GetExperimentStats returns a function that returns a *serializer.Response
+// with the inter-annotator agreement statistics of a requested experiment
+func GetExperimentStats(repo repository.ExperimentStore, assignmentsRepo repository.AssignmentStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		experiment, err := repo.GetByID(experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		if experiment == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no experiment found")
+		}
+
+		assignments, err := assignmentsRepo.GetAnswered(experimentID)
+		if err != nil {
+			return nil, err
+		}
+
+		categories := experiment.AnswerSchema.Categories()
+		if categories == nil {
+			// every answer given is a different category
+			seen := make(map[string]bool)
+			for _, as := range assignments {
+				if !seen[as.Answer.String] {
+					seen[as.Answer.String] = true
+					categories = append(categories, as.Answer.String)
+				}
+			}
+		}
+
+		agreement := service.NewAgreement(assignments, categories)
+
+		return serializer.NewExperimentStatsResponse(experiment, agreement), nil
+	}
+}
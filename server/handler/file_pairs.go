@@ -1,16 +1,77 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 )
 
+// filePairFields returns the FilePair fields requested with the "fields"
+// query parameter, a comma-separated list. If a field is not valid, it
+// returns a serializer.NewHTTPError
+func filePairFields(r *http.Request) ([]string, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(param, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if !serializer.FilePairFields[fields[i]] {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Wrong file-pair field %q", fields[i]))
+		}
+	}
+
+	return fields, nil
+}
+
 // GetFilePairDetails returns a function that returns a *serializer.Response
-// with the details of the requested FilePair
-func GetFilePairDetails(repo *repository.FilePairs) RequestProcessFunc {
+// with the details of the requested FilePair. The returned fields can be
+// chosen with the "fields" query parameter
+func GetFilePairDetails(repo repository.FilePairStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
+		pairID, err := urlParamInt(r, "pairId")
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := filePairFields(r)
+		if err != nil {
+			return nil, err
+		}
+
+		filePair, err := repo.GetByID(pairID)
+		if err != nil {
+			return nil, err
+		}
+
+		if filePair == nil || filePair.ExperimentID != experimentID {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
+		}
+
+		return serializer.NewFilePairResponse(filePair, fields...), nil
+	}
+}
+
+// GetFilePairFeatures returns a function that returns a *serializer.Response
+// with the features of both files of the requested FilePair, compared
+func GetFilePairFeatures(repo repository.FilePairStore, featuresRepo repository.FeatureStore) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
+		experimentID, err := urlParamInt(r, "experimentId")
+		if err != nil {
+			return nil, err
+		}
+
 		pairID, err := urlParamInt(r, "pairId")
 		if err != nil {
 			return nil, err
@@ -21,10 +82,20 @@ func GetFilePairDetails(repo *repository.FilePairs) RequestProcessFunc {
 			return nil, err
 		}
 
-		if filePair == nil {
+		if filePair == nil || filePair.ExperimentID != experimentID {
 			return nil, serializer.NewHTTPError(http.StatusNotFound, "no file-pair found")
 		}
 
-		return serializer.NewFilePairResponse(filePair), nil
+		left, err := featuresRepo.GetByBlobID(filePair.Left.BlobID)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := featuresRepo.GetByBlobID(filePair.Right.BlobID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewFeaturesResponse(left, right), nil
 	}
 }
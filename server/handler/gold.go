@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/serializer"
+)
+
+type goldAnswerRequest struct {
+	PairID int    `json:"pairId"`
+	Answer string `json:"answer"`
+}
+
+// readGoldAnswer returns the GoldAnswer of the request, or a
+// serializer.NewHTTPError if the answer is not a valid gold answer for a pair
+// of the given experiment. An empty answer is valid, it removes the gold answer
+func readGoldAnswer(repo repository.FilePairStore, experiment *model.Experiment,
+	req goldAnswerRequest) (*model.GoldAnswer, error) {
+	answer := req.Answer
+	if answer != "" {
+		var err error
+		answer, err = experiment.AnswerSchema.Normalize(answer)
+		if err != nil || answer == model.SkipAnswer {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("wrong gold answer %q for pair %d", req.Answer, req.PairID))
+		}
+	}
+
+	pair, err := repo.GetByID(req.PairID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil || pair.ExperimentID != experiment.ID {
+		return nil, serializer.NewHTTPError(http.StatusNotFound,
+			fmt.Sprintf("file pair %d not found in the experiment", req.PairID))
+	}
+
+	return &model.GoldAnswer{PairID: req.PairID, ExperimentID: experiment.ID, Answer: answer}, nil
+}
+
+// GetGoldAnswers returns a function that returns a *serializer.Response
+// with the gold answers of the requested experiment
+func GetGoldAnswers(experimentRepo repository.ExperimentStore, repo repository.GoldAnswerStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		answers, err := repo.GetByExperiment(experiment.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewGoldAnswersResponse(answers), nil
+	}
+}
+
+// SetGoldAnswer returns a function that sets the gold answer of the requested
+// file pair to the answer passed in the body request. An empty answer removes
+// it
+func SetGoldAnswer(experimentRepo repository.ExperimentStore,
+	pairRepo repository.FilePairStore, repo repository.GoldAnswerStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		pairID, err := urlParamInt(r, "pairId")
+		if err != nil {
+			return nil, err
+		}
+
+		var req goldAnswerRequest
+		if err := readJSON(r, &req); err != nil {
+			return nil, err
+		}
+
+		req.PairID = pairID
+		answer, err := readGoldAnswer(pairRepo, experiment, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := repo.Set(answer); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewCountResponse(1), nil
+	}
+}
+
+// SetGoldAnswers returns a function that sets the gold answers passed in the
+// body request, as a list of pairId and answer objects. Nothing is stored if
+// any of them is wrong
+func SetGoldAnswers(experimentRepo repository.ExperimentStore,
+	pairRepo repository.FilePairStore, repo repository.GoldAnswerStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		var reqs []goldAnswerRequest
+		if err := readJSON(r, &reqs); err != nil {
+			return nil, err
+		}
+
+		answers := make([]*model.GoldAnswer, len(reqs))
+		for i, req := range reqs {
+			if answers[i], err = readGoldAnswer(pairRepo, experiment, req); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := repo.Set(answers...); err != nil {
+			return nil, err
+		}
+
+		return serializer.NewCountResponse(len(answers)), nil
+	}
+}
+
+// GetWorkersAccuracy returns a function that returns a *serializer.Response
+// with the accuracy of each worker on the gold pairs of the requested
+// experiment
+func GetWorkersAccuracy(experimentRepo repository.ExperimentStore, repo repository.GoldAnswerStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		experiment, err := getRequestedExperiment(r, experimentRepo)
+		if err != nil {
+			return nil, err
+		}
+
+		workers, err := repo.Accuracy(experiment.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewWorkersAccuracyResponse(experiment, workers), nil
+	}
+}
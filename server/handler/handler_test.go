@@ -0,0 +1,804 @@
+package handler_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/service"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+// testUIDomain is where the users are redirected after logging in
+const testUIDomain = "http://ui"
+
+// testRouter returns the server.Router with the stores of the given Memory.
+// The users log in with the local provider
+func testRouter(jwt *service.JWT, m *repository.Memory) http.Handler {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	access, err := service.NewAccess("worker", nil, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	proxies, err := service.NewTrustedProxies(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	stores := m.Stores()
+	provider := service.NewLocal(stores.Users)
+	return server.Router(logger, jwt, provider, access, proxies, testUIDomain, stores, "")
+}
+
+var testJWTConfig = service.JWTConfig{
+	SigningKey:    "testing",
+	Issuer:        "issuer",
+	Audience:      "audience",
+	Expiry:        time.Hour,
+	RefreshExpiry: 24 * time.Hour,
+}
+
+type HandlerSuite struct {
+	suite.Suite
+
+	jwt    *service.JWT
+	mem    *repository.Memory
+	router http.Handler
+
+	requester *model.User
+	worker    *model.User
+	other     *model.User
+
+	experiment *model.Experiment
+	pairs      []*model.FilePair
+}
+
+func (suite *HandlerSuite) SetupTest() {
+	require := suite.Require()
+
+	suite.mem = repository.NewMemory()
+	suite.jwt = service.NewJWT(testJWTConfig, suite.mem.Sessions())
+	suite.router = testRouter(suite.jwt, suite.mem)
+
+	users := suite.mem.Users()
+	suite.requester = &model.User{Login: "boss", Role: model.Requester}
+	suite.worker = &model.User{Login: "worker", Role: model.Worker}
+	suite.other = &model.User{Login: "other", Role: model.Worker}
+	for _, u := range []*model.User{suite.requester, suite.worker, suite.other} {
+		require.NoError(users.Create(u))
+	}
+
+	suite.experiment = &model.Experiment{Name: "test", AnswerSchema: model.DefaultAnswerSchema}
+	require.NoError(suite.mem.Experiments().Create(suite.experiment))
+
+	suite.pairs = nil
+	for _, blob := range []string{"a", "b"} {
+		pair := &model.FilePair{ExperimentID: suite.experiment.ID,
+			Left: model.File{BlobID: blob + "1"}, Right: model.File{BlobID: blob + "2"}}
+		suite.mem.AddFilePair(pair)
+		suite.pairs = append(suite.pairs, pair)
+	}
+}
+
+func (suite *HandlerSuite) token(user *model.User) string {
+	token, err := suite.jwt.MakeToken(user)
+	suite.Require().NoError(err)
+
+	return token
+}
+
+// request serves the request made by the given user, or by an anonymous one if
+// it is nil, and returns the response
+func (suite *HandlerSuite) request(user *model.User, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if user != nil {
+		r.Header.Set("Authorization", "Bearer "+suite.token(user))
+	}
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	return w
+}
+
+// assertStatus checks the status of the response, and that the errors are
+// returned with the same status
+func (suite *HandlerSuite) assertStatus(w *httptest.ResponseRecorder, status int) {
+	suite.Equal(status, w.Code, w.Body.String())
+	if status < http.StatusBadRequest || w.Body.Len() == 0 {
+		return
+	}
+
+	var response struct {
+		Status int
+		Errors []struct{ Status int }
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Equal(status, response.Status)
+	suite.Require().Len(response.Errors, 1)
+	suite.Equal(status, response.Errors[0].Status)
+}
+
+// assignment returns the first Assignment of the user in the experiment,
+// creating them if needed
+func (suite *HandlerSuite) assignment(user *model.User) *model.Assignment {
+	assignments, err := suite.mem.Assignments().Initialize(user.ID, suite.experiment.ID)
+	suite.Require().NoError(err)
+
+	return assignments[0]
+}
+
+// requestWithToken serves the request with the given token, and returns the
+// response
+func (suite *HandlerSuite) requestWithToken(token, method, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	return w
+}
+
+// tokenWith returns a token for the user, made by a JWT service with the
+// given changes to the test configuration, sharing the sessions
+func (suite *HandlerSuite) tokenWith(user *model.User, change func(*service.JWTConfig)) string {
+	conf := testJWTConfig
+	change(&conf)
+
+	token, err := service.NewJWT(conf, suite.mem.Sessions()).MakeToken(user)
+	suite.Require().NoError(err)
+
+	return token
+}
+
+// sign returns a token with the given claims, signed with the test key
+func (suite *HandlerSuite) sign(claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTConfig.SigningKey))
+	suite.Require().NoError(err)
+
+	return token
+}
+
+func (suite *HandlerSuite) TestAuthFailures() {
+	w := suite.request(nil, "GET", "/api/me", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	noRole := &model.User{ID: suite.worker.ID}
+	w = suite.request(noRole, "GET", "/api/me", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	tokens := map[string]string{
+		"signing key": suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.SigningKey = "other" }),
+		"issuer":      suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.Issuer = "other" }),
+		"audience":    suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.Audience = "other" }),
+		"expiry":      suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.Expiry = -time.Minute }),
+		// tokens issued before the sessions
+		"session": suite.sign(jwt.MapClaims{"ID": suite.worker.ID, "Role": "worker",
+			"iss": testJWTConfig.Issuer, "aud": testJWTConfig.Audience}),
+		"none": suite.sign(jwt.MapClaims{"ID": suite.worker.ID, "Role": "worker",
+			"iss": testJWTConfig.Issuer, "aud": testJWTConfig.Audience, "jti": "made-up"}),
+	}
+
+	for wrong, token := range tokens {
+		w := suite.requestWithToken(token, "GET", "/api/me")
+		suite.Equal(http.StatusUnauthorized, w.Code, "wrong %s", wrong)
+	}
+
+	w = suite.request(suite.worker, "GET", "/api/me", "")
+	suite.assertStatus(w, http.StatusOK)
+}
+
+func (suite *HandlerSuite) TestLogout() {
+	token := suite.token(suite.worker)
+
+	w := suite.requestWithToken(token, "POST", "/api/logout")
+	suite.assertStatus(w, http.StatusOK)
+
+	for _, path := range []string{"/api/me", "/api/logout", "/api/refresh"} {
+		method := "POST"
+		if path == "/api/me" {
+			method = "GET"
+		}
+
+		w = suite.requestWithToken(token, method, path)
+		suite.Equal(http.StatusUnauthorized, w.Code, path)
+	}
+}
+
+// logIn posts the local log in form, and returns the response
+func (suite *HandlerSuite) logIn(login, password string) *httptest.ResponseRecorder {
+	form := url.Values{"login": {login}, "password": {password}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	return w
+}
+
+func (suite *HandlerSuite) TestLocalLogin() {
+	hash, err := service.HashPassword("secret")
+	suite.Require().NoError(err)
+
+	user := &model.User{Login: "local", Role: model.Requester,
+		Provider: service.LocalProvider, PasswordHash: hash}
+	suite.Require().NoError(suite.mem.Users().Create(user))
+
+	w := suite.request(nil, "GET", "/login", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = suite.logIn("local", "secret")
+	suite.Require().Equal(http.StatusTemporaryRedirect, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	suite.Require().NoError(err)
+	suite.Equal(testUIDomain+"/", location.Scheme+"://"+location.Host+location.Path)
+
+	token := location.Query().Get("token")
+	w = suite.requestWithToken(token, "GET", "/api/experiments/1/stats")
+	suite.assertStatus(w, http.StatusOK)
+
+	got, err := suite.mem.Users().GetByID(user.ID)
+	suite.Require().NoError(err)
+	suite.NotNil(got.LastLoginAt)
+
+	suite.assertStatus(suite.logIn("local", "wrong"), http.StatusUnauthorized)
+	// the users of other providers can not log in with a password
+	suite.assertStatus(suite.logIn("worker", ""), http.StatusUnauthorized)
+}
+
+// refresh returns the token returned by the refresh endpoint
+func (suite *HandlerSuite) refresh(token string) string {
+	w := suite.requestWithToken(token, "POST", "/api/refresh")
+	suite.assertStatus(w, http.StatusOK)
+
+	var response struct {
+		Data struct{ Token string }
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().NotEmpty(response.Data.Token)
+
+	return response.Data.Token
+}
+
+func (suite *HandlerSuite) TestRefresh() {
+	expired := suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.Expiry = -time.Minute })
+
+	// the new token gets the current role
+	suite.worker.Role = model.Requester
+	suite.Require().NoError(suite.mem.Users().Update(suite.worker))
+
+	token := suite.refresh(expired)
+	w := suite.requestWithToken(token, "GET", "/api/experiments/1/stats")
+	suite.assertStatus(w, http.StatusOK)
+
+	// the refreshed token is revoked
+	w = suite.requestWithToken(expired, "POST", "/api/refresh")
+	suite.assertStatus(w, http.StatusUnauthorized)
+
+	suite.refresh(token)
+	w = suite.requestWithToken(token, "GET", "/api/me")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	old := suite.tokenWith(suite.worker, func(c *service.JWTConfig) {
+		c.Expiry, c.RefreshExpiry = -time.Minute, -time.Second
+	})
+	w = suite.requestWithToken(old, "POST", "/api/refresh")
+	suite.assertStatus(w, http.StatusUnauthorized)
+
+	other := suite.tokenWith(suite.worker, func(c *service.JWTConfig) { c.Issuer = "other" })
+	w = suite.requestWithToken(other, "POST", "/api/refresh")
+	suite.assertStatus(w, http.StatusUnauthorized)
+}
+
+// session returns the stored Session of the given token
+func (suite *HandlerSuite) session(token string) *model.Session {
+	var claims jwt.StandardClaims
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(testJWTConfig.SigningKey), nil
+	})
+	suite.Require().NoError(err)
+
+	session, err := suite.mem.Sessions().Get(claims.Id)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(session)
+	return session
+}
+
+func (suite *HandlerSuite) TestRefreshExpiry() {
+	require := suite.Require()
+
+	expired := suite.tokenWith(suite.worker, func(c *service.JWTConfig) {
+		c.Expiry, c.RefreshExpiry = -time.Minute, time.Minute
+	})
+	expiresAt := suite.session(expired).ExpiresAt
+
+	// the refreshed tokens keep the expiry of the first session
+	token := suite.refresh(expired)
+	suite.True(expiresAt.Equal(suite.session(token).ExpiresAt))
+
+	token = suite.refresh(token)
+	suite.True(expiresAt.Equal(suite.session(token).ExpiresAt))
+
+	// only one of the concurrent refreshes of a token gets a new one
+	tokenID := suite.session(token).ID
+	_, err := suite.jwt.Refresh(suite.worker, tokenID)
+	require.NoError(err)
+	_, err = suite.jwt.Refresh(suite.worker, tokenID)
+	suite.Equal(service.ErrInvalidToken, err)
+}
+
+func (suite *HandlerSuite) TestRequesterOnly() {
+	routes := [][]string{
+		{"POST", "/api/experiments", `{"name": "new"}`},
+		{"PUT", "/api/experiments/1", `{"name": "new"}`},
+		{"DELETE", "/api/experiments/1", ""},
+		{"GET", "/api/experiments/1/stats", ""},
+		{"GET", "/api/experiments/1/accuracy", ""},
+		{"GET", "/api/experiments/1/flagged", ""},
+		{"GET", "/api/experiments/1/history", ""},
+		{"GET", "/api/experiments/1/gold", ""},
+		{"PUT", "/api/experiments/1/gold", `[]`},
+		{"PUT", "/api/experiments/1/gold/1", `{"answer": "yes"}`},
+		{"GET", "/api/users", ""},
+		{"PUT", "/api/users/2", `{"role": "requester"}`},
+	}
+
+	for _, route := range routes {
+		w := suite.request(suite.worker, route[0], route[1], route[2])
+		suite.assertStatus(w, http.StatusForbidden)
+	}
+
+	exp, err := suite.mem.Experiments().GetByID(suite.experiment.ID)
+	suite.Require().NoError(err)
+	suite.Equal("test", exp.Name)
+}
+
+func (suite *HandlerSuite) TestNotFound() {
+	paths := []string{
+		"/api/experiments/99",
+		"/api/experiments/99/assignments",
+		"/api/experiments/99/assignments/next",
+		"/api/experiments/99/stats",
+		"/api/experiments/99/history",
+		"/api/experiments/99/gold",
+		"/api/experiments/1/file-pairs/99",
+		"/api/experiments/1/file-pairs/99/features",
+	}
+
+	for _, path := range paths {
+		w := suite.request(suite.requester, "GET", path, "")
+		suite.assertStatus(w, http.StatusNotFound)
+	}
+
+	w := suite.request(suite.requester, "PUT", "/api/experiments/99", `{"name": "new"}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	w = suite.request(suite.requester, "PUT", "/api/experiments/1/gold/99", `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	w = suite.request(suite.worker, "PUT", "/api/experiments/1/assignments/99", `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	// the assignments are only saved through the URL of their experiment
+	other := &model.Experiment{Name: "other", AnswerSchema: model.DefaultAnswerSchema}
+	suite.Require().NoError(suite.mem.Experiments().Create(other))
+	as := suite.assignment(suite.worker)
+	w = suite.request(suite.worker, "PUT",
+		fmt.Sprintf("/api/experiments/%d/assignments/%d", other.ID, as.ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	// the file pairs are only returned through the URL of their experiment
+	for _, path := range []string{"/file-pairs/%d", "/file-pairs/%d/features"} {
+		w = suite.request(suite.requester, "GET",
+			fmt.Sprintf("/api/experiments/%d"+path, other.ID, suite.pairs[0].ID), "")
+		suite.assertStatus(w, http.StatusNotFound)
+	}
+
+	w = suite.request(suite.requester, "PUT", "/api/users/99", `{"active": false}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	deleted := &model.User{ID: 99, Role: model.Worker}
+	w = suite.request(deleted, "GET", "/api/me", "")
+	suite.assertStatus(w, http.StatusUnauthorized)
+}
+
+func (suite *HandlerSuite) TestForbiddenAssignmentUpdate() {
+	as := suite.assignment(suite.other)
+
+	w := suite.request(suite.worker, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusForbidden)
+
+	w = suite.request(suite.requester, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusForbidden)
+
+	// the assignment must be updated in its own experiment
+	other := &model.Experiment{Name: "other"}
+	suite.Require().NoError(suite.mem.Experiments().Create(other))
+	w = suite.request(suite.other, "PUT",
+		"/api/experiments/"+strconv.Itoa(other.ID)+"/assignments/"+strconv.Itoa(as.ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusNotFound)
+
+	got, err := suite.mem.Assignments().GetByID(as.ID)
+	suite.Require().NoError(err)
+	suite.False(got.Answer.Valid)
+
+	w = suite.request(suite.other, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	got, err = suite.mem.Assignments().GetByID(as.ID)
+	suite.Require().NoError(err)
+	suite.Equal("yes", got.Answer.String)
+}
+
+func (suite *HandlerSuite) TestBadPayloads() {
+	as := suite.assignment(suite.worker)
+	assignmentPath := "/api/experiments/1/assignments/" + strconv.Itoa(as.ID)
+
+	requests := []struct {
+		user   *model.User
+		method string
+		path   string
+		body   string
+	}{
+		{suite.worker, "PUT", assignmentPath, `{"answer": `},
+		{suite.worker, "PUT", assignmentPath, `{"answer": "perhaps"}`},
+		{suite.worker, "PUT", assignmentPath, `{"answer": "yes", "flags": ["ugly"]}`},
+		{suite.worker, "PUT", assignmentPath, `{"answer": "yes", "duration": "long"}`},
+		{suite.worker, "PUT", "/api/experiments/1/assignments/first", `{"answer": "yes"}`},
+		{suite.worker, "GET", "/api/experiments/1/assignments/next?order=best", ""},
+		{suite.worker, "GET", "/api/experiments/one", ""},
+		{suite.worker, "GET", "/api/experiments/1/file-pairs/1?fields=secret", ""},
+		{suite.requester, "POST", "/api/experiments", `not json`},
+		{suite.requester, "POST", "/api/experiments", `{"description": "no name"}`},
+		{suite.requester, "POST", "/api/experiments", `{"name": "new", "workersPerPair": -1}`},
+		{suite.requester, "POST", "/api/experiments", `{"name": "new", "sharedPairsRatio": 2}`},
+		{suite.requester, "POST", "/api/experiments", `{"name": "new", "answerSchema": {"type": "scale", "min": 5, "max": 1}}`},
+		{suite.requester, "PUT", "/api/experiments/1", `{"name": ""}`},
+		{suite.requester, "PUT", "/api/experiments/1/gold/1", `{"answer": "skip"}`},
+		{suite.requester, "PUT", "/api/experiments/1/gold/1", `{"answer": 1}`},
+		{suite.requester, "PUT", "/api/experiments/1/gold", `[{"pairId": 1, "answer": "perhaps"}]`},
+		{suite.requester, "GET", "/api/experiments?limit=0", ""},
+		{suite.requester, "GET", "/api/experiments/1/history?userId=me", ""},
+		{suite.requester, "GET", "/api/users?role=admin", ""},
+		{suite.requester, "GET", "/api/users?active=yes", ""},
+		{suite.requester, "PUT", "/api/users/2", `{"role": "admin"}`},
+		{suite.requester, "PUT", "/api/users/2", `{"active": "no"}`},
+		{suite.requester, "PUT", "/api/users/1", `{"role": "worker"}`},
+		{suite.requester, "PUT", "/api/users/1", `{"active": false}`},
+	}
+
+	for _, req := range requests {
+		w := suite.request(req.user, req.method, req.path, req.body)
+		suite.assertStatus(w, http.StatusBadRequest)
+	}
+
+	got, err := suite.mem.Assignments().GetByID(as.ID)
+	suite.Require().NoError(err)
+	suite.False(got.Answer.Valid)
+
+	gold, err := suite.mem.GoldAnswers().GetByExperiment(suite.experiment.ID)
+	suite.Require().NoError(err)
+	suite.Empty(gold)
+
+	requester, err := suite.mem.Users().GetByID(suite.requester.ID)
+	suite.Require().NoError(err)
+	suite.Equal(model.Requester, requester.Role)
+	suite.True(requester.Active())
+}
+
+func (suite *HandlerSuite) TestConflict() {
+	w := suite.request(suite.requester, "POST", "/api/experiments", `{"name": "test"}`)
+	suite.assertStatus(w, http.StatusConflict)
+}
+
+func (suite *HandlerSuite) TestSaveAssignment() {
+	as := suite.assignment(suite.worker)
+
+	w := suite.request(suite.worker, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID),
+		`{"answer": "no", "duration": 10, "comment": " odd ", "flags": ["truncated", "binary", "binary"]}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	got, err := suite.mem.Assignments().GetByID(as.ID)
+	suite.Require().NoError(err)
+	suite.Equal("no", got.Answer.String)
+	suite.Equal(10, got.Duration)
+	suite.Equal("odd", got.Comment)
+	suite.Equal([]model.Flag{model.BinaryFlag, model.TruncatedFlag}, got.Flags)
+
+	w = suite.request(suite.requester, "GET", "/api/experiments/1/history", "")
+	suite.assertStatus(w, http.StatusOK)
+
+	var response struct {
+		Data []struct {
+			AssignmentID int
+			NewAnswer    *string
+		}
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().Len(response.Data, 1)
+	suite.Equal(as.ID, response.Data[0].AssignmentID)
+	suite.Equal("no", *response.Data[0].NewAnswer)
+}
+
+func (suite *HandlerSuite) TestWorkerBlocked() {
+	require := suite.Require()
+
+	suite.experiment.MinGoldAccuracy = 0.5
+	suite.experiment.MinGoldAnswers = 2
+	require.NoError(suite.mem.Experiments().Update(suite.experiment))
+	require.NoError(suite.mem.GoldAnswers().Set(
+		&model.GoldAnswer{PairID: suite.pairs[0].ID, ExperimentID: suite.experiment.ID, Answer: "yes"},
+		&model.GoldAnswer{PairID: suite.pairs[1].ID, ExperimentID: suite.experiment.ID, Answer: "yes"}))
+
+	// the third pair is not gold
+	suite.mem.AddFilePair(&model.FilePair{ExperimentID: suite.experiment.ID,
+		Left: model.File{BlobID: "c1"}, Right: model.File{BlobID: "c2"}})
+
+	assignmentsOf := func(user *model.User) []*model.Assignment {
+		assignments, err := suite.mem.Assignments().GetAll(user.ID, suite.experiment.ID)
+		if err == repository.ErrNoAssignmentsInitialized {
+			assignments, err = suite.mem.Assignments().Initialize(user.ID, suite.experiment.ID)
+		}
+		require.NoError(err)
+		require.Len(assignments, 3)
+		return assignments
+	}
+
+	answer := func(user *model.User, pair int, answer string) {
+		as := assignmentsOf(user)[pair]
+		as.Answer = sql.NullString{String: answer, Valid: true}
+		require.NoError(suite.mem.Assignments().Update(as, model.ClientInfo{}))
+	}
+
+	// a single wrong answer is under minGoldAnswers
+	answer(suite.worker, 0, "no")
+	w := suite.request(suite.worker, "GET", "/api/experiments/1/assignments/next", "")
+	suite.assertStatus(w, http.StatusOK)
+
+	answer(suite.worker, 1, "no")
+	w = suite.request(suite.worker, "GET", "/api/experiments/1/assignments/next", "")
+	suite.assertStatus(w, http.StatusForbidden)
+
+	// the blocked worker only gets and changes the assignments already answered
+	assignments := assignmentsOf(suite.worker)
+	w = suite.request(suite.worker, "GET", "/api/experiments/1/assignments", "")
+	suite.assertStatus(w, http.StatusOK)
+	var response struct {
+		Data []struct{ ID int }
+	}
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Len(response.Data, 2)
+	for _, as := range response.Data {
+		suite.NotEqual(assignments[2].ID, as.ID)
+	}
+
+	w = suite.request(suite.worker, "PUT",
+		"/api/experiments/1/assignments/"+strconv.Itoa(assignments[2].ID), `{"answer": "yes"}`)
+	suite.assertStatus(w, http.StatusForbidden)
+
+	w = suite.request(suite.worker, "PUT",
+		"/api/experiments/1/assignments/"+strconv.Itoa(assignments[0].ID), `{"answer": "no"}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	// the skipped gold pairs do not count
+	answer(suite.other, 0, model.SkipAnswer)
+	answer(suite.other, 1, "no")
+	w = suite.request(suite.other, "GET", "/api/experiments/1/assignments/next", "")
+	suite.assertStatus(w, http.StatusOK)
+
+	// an accuracy equal to minGoldAccuracy is enough
+	answer(suite.other, 0, "yes")
+	w = suite.request(suite.other, "GET", "/api/experiments/1/assignments/next", "")
+	suite.assertStatus(w, http.StatusOK)
+}
+
+func (suite *HandlerSuite) TestUpdateAnswerSchema() {
+	require := suite.Require()
+
+	as := suite.assignment(suite.worker)
+	as.Answer = sql.NullString{String: "maybe", Valid: true}
+	require.NoError(suite.mem.Assignments().Update(as, model.ClientInfo{}))
+
+	update := func(labels string) *httptest.ResponseRecorder {
+		return suite.request(suite.requester, "PUT", "/api/experiments/1",
+			`{"name": "test", "answerSchema": {"type": "single", "labels": [`+labels+`]}}`)
+	}
+
+	suite.assertStatus(update(`"yes", "no"`), http.StatusConflict)
+
+	require.NoError(suite.mem.GoldAnswers().Set(
+		&model.GoldAnswer{PairID: suite.pairs[1].ID, ExperimentID: suite.experiment.ID, Answer: "no"}))
+	suite.assertStatus(update(`"yes", "maybe"`), http.StatusConflict)
+
+	experiment, err := suite.mem.Experiments().GetByID(suite.experiment.ID)
+	require.NoError(err)
+	suite.Equal(model.DefaultAnswerSchema, experiment.AnswerSchema)
+
+	suite.assertStatus(update(`"no", "maybe", "yes", "unsure"`), http.StatusOK)
+
+	experiment, err = suite.mem.Experiments().GetByID(suite.experiment.ID)
+	require.NoError(err)
+	suite.Equal([]string{"no", "maybe", "yes", "unsure"}, experiment.AnswerSchema.Labels)
+}
+
+func (suite *HandlerSuite) TestFlaggedPairs() {
+	require := suite.Require()
+
+	save := func(user *model.User, body string) {
+		as := suite.assignment(user)
+		w := suite.request(user, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID), body)
+		suite.assertStatus(w, http.StatusOK)
+	}
+
+	save(suite.worker, `{"answer": "no", "flags": ["binary", "truncated"]}`)
+	save(suite.other, `{"answer": "skip", "comment": "empty", "flags": ["binary"]}`)
+	save(suite.requester, `{"answer": "yes"}`)
+
+	w := suite.request(suite.requester, "GET", "/api/experiments/1/flagged", "")
+	suite.assertStatus(w, http.StatusOK)
+
+	var response struct {
+		Data []struct {
+			PairID      int
+			Flags       map[string]int
+			Assignments []struct {
+				UserID  int
+				Comment string
+			}
+		}
+	}
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(response.Data, 1)
+	suite.Equal(suite.pairs[0].ID, response.Data[0].PairID)
+	suite.Equal(map[string]int{"binary": 2, "truncated": 1}, response.Data[0].Flags)
+	require.Len(response.Data[0].Assignments, 2)
+	suite.Equal(suite.worker.ID, response.Data[0].Assignments[0].UserID)
+	suite.Equal("empty", response.Data[0].Assignments[1].Comment)
+}
+
+// users returns the logins of the users listed by the request
+func (suite *HandlerSuite) users(path string) []string {
+	w := suite.request(suite.requester, "GET", path, "")
+	suite.assertStatus(w, http.StatusOK)
+
+	var response struct {
+		Data []struct{ Login string }
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+
+	logins := make([]string, len(response.Data))
+	for i, u := range response.Data {
+		logins[i] = u.Login
+	}
+
+	return logins
+}
+
+func (suite *HandlerSuite) TestUsers() {
+	suite.Equal([]string{"boss", "worker", "other"}, suite.users("/api/users"))
+	suite.Equal([]string{"worker", "other"}, suite.users("/api/users?role=worker"))
+	suite.Equal([]string{"other"}, suite.users("/api/users?q=OTH"))
+	suite.Equal([]string{"worker"}, suite.users("/api/users?offset=1&limit=1"))
+
+	w := suite.request(suite.requester, "PUT", "/api/users/3",
+		`{"username": "Other Worker", "role": "requester"}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	var response struct {
+		Data struct {
+			Username string
+			Role     model.Role
+			Active   bool
+		}
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Equal("Other Worker", response.Data.Username)
+	suite.Equal(model.Requester, response.Data.Role)
+	suite.True(response.Data.Active)
+
+	got, err := suite.mem.Users().GetByID(suite.other.ID)
+	suite.Require().NoError(err)
+	suite.Equal("Other Worker", got.Username)
+	suite.Equal(model.Requester, got.Role)
+
+	suite.Equal([]string{"worker"}, suite.users("/api/users?role=worker"))
+	suite.Equal([]string{"other"}, suite.users("/api/users?q=r%20w"))
+}
+
+func (suite *HandlerSuite) TestDeactivateUser() {
+	hash, err := service.HashPassword("secret")
+	suite.Require().NoError(err)
+
+	user := &model.User{Login: "local", Role: model.Worker,
+		Provider: service.LocalProvider, PasswordHash: hash}
+	suite.Require().NoError(suite.mem.Users().Create(user))
+
+	as := suite.assignment(user)
+	suite.Require().NoError(suite.mem.Assignments().Update(&model.Assignment{ID: as.ID,
+		Answer: sql.NullString{String: "yes", Valid: true}}, model.ClientInfo{}))
+
+	token := suite.token(user)
+	expired := suite.tokenWith(user, func(c *service.JWTConfig) { c.Expiry = -time.Minute })
+
+	path := "/api/users/" + strconv.Itoa(user.ID)
+	w := suite.request(suite.requester, "PUT", path, `{"active": false}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	// the tokens stop working, and the user can not log in again
+	w = suite.requestWithToken(token, "GET", "/api/me")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	w = suite.requestWithToken(expired, "POST", "/api/refresh")
+	suite.assertStatus(w, http.StatusUnauthorized)
+
+	suite.assertStatus(suite.logIn("local", "secret"), http.StatusForbidden)
+
+	// the answers are kept
+	got, err := suite.mem.Assignments().GetByID(as.ID)
+	suite.Require().NoError(err)
+	suite.Equal("yes", got.Answer.String)
+
+	suite.Equal([]string{"local"}, suite.users("/api/users?active=false"))
+	suite.Equal([]string{"boss", "worker", "other"}, suite.users("/api/users?active=true"))
+
+	w = suite.request(suite.requester, "PUT", path, `{"active": true}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	suite.Equal(http.StatusTemporaryRedirect, suite.logIn("local", "secret").Code)
+}
+
+func (suite *HandlerSuite) TestChangeRole() {
+	token := suite.token(suite.worker)
+
+	path := "/api/users/" + strconv.Itoa(suite.worker.ID)
+	w := suite.request(suite.requester, "PUT", path, `{"role": "requester"}`)
+	suite.assertStatus(w, http.StatusOK)
+
+	// the tokens with the old role are revoked
+	w = suite.requestWithToken(token, "GET", "/api/me")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	got, err := suite.mem.Users().GetByID(suite.worker.ID)
+	suite.Require().NoError(err)
+	w = suite.request(got, "GET", "/api/experiments/1/stats", "")
+	suite.assertStatus(w, http.StatusOK)
+}
+
+func (suite *HandlerSuite) TestStaleToken() {
+	token := suite.token(suite.worker)
+	otherToken := suite.token(suite.other)
+
+	// the users changed without revoking their tokens are rejected anyway
+	suite.worker.Role = model.Requester
+	suite.Require().NoError(suite.mem.Users().Update(suite.worker))
+	w := suite.requestWithToken(token, "GET", "/api/experiments/1/stats")
+	suite.assertStatus(w, http.StatusUnauthorized)
+
+	now := time.Now().UTC()
+	suite.other.DeactivatedAt = &now
+	suite.Require().NoError(suite.mem.Users().Update(suite.other))
+	w = suite.requestWithToken(otherToken, "GET", "/api/me")
+	suite.assertStatus(w, http.StatusUnauthorized)
+}
+
+func TestHandlerSuite(t *testing.T) {
+	suite.Run(t, new(HandlerSuite))
+}
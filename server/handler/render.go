@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 
@@ -81,3 +82,64 @@ func urlParamInt(r *http.Request, key string) (int, error) {
 
 	return val, err
 }
+
+// queryParamInt returns the query parameter from an http.Request object, or
+// the given defaultValue if it is not set. If the param cannot be converted to
+// int, it returns a serializer.NewHTTPError
+func queryParamInt(r *http.Request, key string, defaultValue int) (int, error) {
+	str := r.URL.Query().Get(key)
+	if str == "" {
+		return defaultValue, nil
+	}
+
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		err = serializer.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Wrong format for query parameter %q; received %q", key, str))
+	}
+
+	return val, err
+}
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pagination returns the limit and offset requested with the "limit" and
+// "offset" query parameters. If they are not valid, it returns a
+// serializer.NewHTTPError
+func pagination(r *http.Request) (limit, offset int, err error) {
+	if limit, err = queryParamInt(r, "limit", defaultPageLimit); err != nil {
+		return 0, 0, err
+	}
+
+	if offset, err = queryParamInt(r, "offset", 0); err != nil {
+		return 0, 0, err
+	}
+
+	if limit < 1 || limit > maxPageLimit || offset < 0 {
+		return 0, 0, serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Wrong pagination; limit must be between 1 and %d, and offset can not be negative", maxPageLimit))
+	}
+
+	return limit, offset, nil
+}
+
+// readJSON decodes the JSON body of an http.Request into v. If the body is not
+// valid, it returns a serializer.NewHTTPError
+func readJSON(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return serializer.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Wrong request body; %s", err))
+	}
+
+	return nil
+}
@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/serializer"
 	"github.com/src-d/code-annotation/server/service"
@@ -10,7 +13,7 @@ import (
 
 // Me handler returns a function that returns a *serializer.Response
 // with the information about the current user
-func Me(usersRepo *repository.Users) RequestProcessFunc {
+func Me(usersRepo repository.UserStore) RequestProcessFunc {
 	return func(r *http.Request) (*serializer.Response, error) {
 		userID, err := service.GetUserID(r.Context())
 		if err != nil {
@@ -29,3 +32,133 @@ func Me(usersRepo *repository.Users) RequestProcessFunc {
 		return serializer.NewUserResponse(u), nil
 	}
 }
+
+// GetUsers returns a function that returns a *serializer.Response with the
+// users, filtered by the "q" (part of the login or username), "role" and
+// "active" query parameters
+func GetUsers(usersRepo repository.UserStore) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		query := r.URL.Query()
+		filter := repository.UsersFilter{Query: query.Get("q")}
+
+		if role := query.Get("role"); role != "" {
+			if _, ok := model.Roles[model.Role(role)]; !ok {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("Wrong role %q", role))
+			}
+
+			filter.Role = model.Role(role)
+		}
+
+		switch active := query.Get("active"); active {
+		case "":
+		case "true", "false":
+			deactivated := active == "false"
+			filter.Deactivated = &deactivated
+		default:
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Wrong format for query parameter \"active\"; received %q", active))
+		}
+
+		limit, offset, err := pagination(r)
+		if err != nil {
+			return nil, err
+		}
+
+		users, err := usersRepo.List(filter, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		return serializer.NewUsersResponse(users), nil
+	}
+}
+
+// updateUserRequest contains the fields of a User that can be changed; the
+// ones not sent are left as they are
+type updateUserRequest struct {
+	Username  *string     `json:"username"`
+	AvatarURL *string     `json:"avatarURL"`
+	Role      *model.Role `json:"role"`
+	Active    *bool       `json:"active"`
+}
+
+// UpdateUser returns a function that updates the requested user with the
+// values passed in the body request. Deactivated users can not log in, and
+// all their tokens are revoked, but their answers are kept. The tokens are
+// revoked too when the role changes. The requesters can not deactivate
+// themselves or change their own role
+func UpdateUser(usersRepo repository.UserStore, jwt *service.JWT) RequestProcessFunc {
+	return func(r *http.Request) (*serializer.Response, error) {
+		userID, err := urlParamInt(r, "userId")
+		if err != nil {
+			return nil, err
+		}
+
+		currentUserID, err := service.GetUserID(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := usersRepo.GetByID(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, serializer.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+
+		var req updateUserRequest
+		if err := readJSON(r, &req); err != nil {
+			return nil, err
+		}
+
+		if req.Role != nil {
+			if _, ok := model.Roles[*req.Role]; !ok {
+				return nil, serializer.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("Wrong role %q", *req.Role))
+			}
+		}
+
+		if user.ID == currentUserID &&
+			((req.Role != nil && *req.Role != user.Role) || (req.Active != nil && !*req.Active)) {
+			return nil, serializer.NewHTTPError(http.StatusBadRequest,
+				"you can not deactivate yourself or change your own role")
+		}
+
+		if req.Username != nil {
+			user.Username = *req.Username
+		}
+
+		if req.AvatarURL != nil {
+			user.AvatarURL = *req.AvatarURL
+		}
+
+		roleChanged := req.Role != nil && *req.Role != user.Role
+		if req.Role != nil {
+			user.Role = *req.Role
+		}
+
+		if req.Active != nil && *req.Active != user.Active() {
+			user.DeactivatedAt = nil
+			if !*req.Active {
+				now := time.Now().UTC()
+				user.DeactivatedAt = &now
+			}
+		}
+
+		if err := usersRepo.Update(user); err != nil {
+			return nil, err
+		}
+
+		// the tokens keep the role they were issued with
+		if !user.Active() || roleChanged {
+			if err := jwt.RevokeUser(user.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		return serializer.NewUserResponse(user), nil
+	}
+}
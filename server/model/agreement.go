@@ -0,0 +1,35 @@
+package model
+
+// PairwiseKappa is the Cohen's kappa between the answers of two workers, for
+// the pairs answered by both
+type PairwiseKappa struct {
+	UserA int
+	UserB int
+	Pairs int
+	Kappa float64
+}
+
+// Agreement contains the inter-annotator agreement statistics of the answers
+// to an experiment. Statistics that can not be computed, because there are not
+// enough answers or all of them are the same, are NaN
+type Agreement struct {
+	// Categories are the answers taken into account
+	Categories []string
+	// Distributions contains the number of answers of each category, by pair ID
+	Distributions map[int]map[string]int
+	// Workers is the number of workers with any answer
+	Workers int
+	// Answers is the total number of answers taken into account
+	Answers int
+	// CohenKappa is the mean of the pairwise Cohen's kappas
+	CohenKappa float64
+	// PairwiseCohenKappa contains the kappa of each pair of workers with
+	// answers for the same pairs
+	PairwiseCohenKappa []PairwiseKappa
+	// FleissKappa is the Fleiss' kappa for all the workers, with the pairs
+	// answered by at least two of them
+	FleissKappa float64
+	// KrippendorffAlpha is the Krippendorff's alpha for all the workers,
+	// using the nominal metric
+	KrippendorffAlpha float64
+}
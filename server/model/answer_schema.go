@@ -0,0 +1,150 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SkipAnswer is the answer of a worker that does not annotate a pair. It is
+// accepted by every AnswerSchema
+const SkipAnswer = "skip"
+
+// AnswerType is the kind of answers accepted by an AnswerSchema
+type AnswerType string
+
+const (
+	// SingleChoice answers are one of the schema labels
+	SingleChoice AnswerType = "single"
+	// MultipleChoice answers are one or more of the schema labels, separated
+	// by commas
+	MultipleChoice AnswerType = "multiple"
+	// ScaleAnswer answers are an integer between the schema Min and Max
+	ScaleAnswer AnswerType = "scale"
+)
+
+// AnswerSchema defines the answers accepted by an Experiment. It is stored as
+// JSON along with the Experiment
+type AnswerSchema struct {
+	Type AnswerType `json:"type"`
+	// Labels are the choices of SingleChoice and MultipleChoice schemas, or
+	// optional names for each value of ScaleAnswer schemas
+	Labels []string `json:"labels,omitempty"`
+	Min    int      `json:"min,omitempty"`
+	Max    int      `json:"max,omitempty"`
+}
+
+// DefaultAnswerSchema is the AnswerSchema of the Experiments without one
+var DefaultAnswerSchema = AnswerSchema{
+	Type:   SingleChoice,
+	Labels: []string{"yes", "maybe", "no"},
+}
+
+// Check returns an error if the AnswerSchema is not well defined
+func (s *AnswerSchema) Check() error {
+	switch s.Type {
+	case SingleChoice, MultipleChoice:
+		if len(s.Labels) == 0 {
+			return fmt.Errorf("%s answers need labels", s.Type)
+		}
+	case ScaleAnswer:
+		if s.Min >= s.Max {
+			return fmt.Errorf("scale answers need a min lower than the max")
+		}
+
+		if n := s.Max - s.Min + 1; len(s.Labels) != 0 && len(s.Labels) != n {
+			return fmt.Errorf("scale answers need no labels or %d of them", n)
+		}
+	default:
+		return fmt.Errorf("unknown answer type %q", s.Type)
+	}
+
+	seen := make(map[string]bool, len(s.Labels))
+	for _, l := range s.Labels {
+		switch {
+		case strings.TrimSpace(l) != l || l == "":
+			return fmt.Errorf("wrong label %q", l)
+		case l == SkipAnswer:
+			return fmt.Errorf("%q is always accepted, it can not be a label", SkipAnswer)
+		case s.Type == MultipleChoice && strings.Contains(l, ","):
+			return fmt.Errorf("multiple choice label %q can not contain commas", l)
+		case seen[l]:
+			return fmt.Errorf("duplicated label %q", l)
+		}
+
+		seen[l] = true
+	}
+
+	return nil
+}
+
+// Normalize checks the given answer against the AnswerSchema, and returns it
+// in its canonical form: multiple choice answers follow the order of the
+// labels, and scale answers are plain integers
+func (s *AnswerSchema) Normalize(answer string) (string, error) {
+	if answer == SkipAnswer {
+		return answer, nil
+	}
+
+	switch s.Type {
+	case SingleChoice:
+		if s.hasLabel(answer) {
+			return answer, nil
+		}
+	case MultipleChoice:
+		chosen := make(map[string]bool)
+		for _, a := range strings.Split(answer, ",") {
+			a = strings.TrimSpace(a)
+			if !s.hasLabel(a) {
+				return "", fmt.Errorf("Wrong answer provided: '%s'", a)
+			}
+
+			chosen[a] = true
+		}
+
+		var labels []string
+		for _, l := range s.Labels {
+			if chosen[l] {
+				labels = append(labels, l)
+			}
+		}
+
+		return strings.Join(labels, ","), nil
+	case ScaleAnswer:
+		v, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err == nil && v >= s.Min && v <= s.Max {
+			return strconv.Itoa(v), nil
+		}
+	}
+
+	return "", fmt.Errorf("Wrong answer provided: '%s'", answer)
+}
+
+// Categories returns the possible answers, skip excluded, to measure the
+// agreement between workers. Multiple choice schemas return nil, any
+// combination of labels is a different category
+func (s *AnswerSchema) Categories() []string {
+	switch s.Type {
+	case SingleChoice:
+		return s.Labels
+	case ScaleAnswer:
+		var values []string
+		for v := s.Min; v <= s.Max; v++ {
+			values = append(values, strconv.Itoa(v))
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
+
+func (s *AnswerSchema) hasLabel(l string) bool {
+	for _, label := range s.Labels {
+		if label == l {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AnswerSchemaSuite struct {
+	suite.Suite
+}
+
+func (suite *AnswerSchemaSuite) TestCheck() {
+	suite.NoError(DefaultAnswerSchema.Check())
+	suite.NoError((&AnswerSchema{Type: ScaleAnswer, Min: 1, Max: 5}).Check())
+
+	wrong := []AnswerSchema{
+		{Type: "other", Labels: []string{"a"}},
+		{Type: SingleChoice},
+		{Type: SingleChoice, Labels: []string{"a", "a"}},
+		{Type: SingleChoice, Labels: []string{"a", SkipAnswer}},
+		{Type: SingleChoice, Labels: []string{" a"}},
+		{Type: MultipleChoice, Labels: []string{"a,b"}},
+		{Type: ScaleAnswer, Min: 5, Max: 1},
+		{Type: ScaleAnswer, Min: 1, Max: 3, Labels: []string{"low", "high"}},
+	}
+
+	for _, s := range wrong {
+		suite.Error(s.Check(), "%+v", s)
+	}
+}
+
+func (suite *AnswerSchemaSuite) TestNormalize() {
+	multiple := AnswerSchema{Type: MultipleChoice, Labels: []string{"renamed", "moved", "reformatted"}}
+	scale := AnswerSchema{Type: ScaleAnswer, Min: 1, Max: 5}
+
+	cases := []struct {
+		schema   AnswerSchema
+		answer   string
+		expected string
+	}{
+		{DefaultAnswerSchema, "maybe", "maybe"},
+		{DefaultAnswerSchema, SkipAnswer, SkipAnswer},
+		{multiple, "reformatted, renamed", "renamed,reformatted"},
+		{multiple, "moved,moved", "moved"},
+		{scale, " 3", "3"},
+		{scale, SkipAnswer, SkipAnswer},
+	}
+
+	for _, c := range cases {
+		answer, err := c.schema.Normalize(c.answer)
+		suite.NoError(err, c.answer)
+		suite.Equal(c.expected, answer)
+	}
+
+	wrong := []struct {
+		schema AnswerSchema
+		answer string
+	}{
+		{DefaultAnswerSchema, "Yes"},
+		{DefaultAnswerSchema, ""},
+		{multiple, ""},
+		{multiple, "renamed,other"},
+		{scale, "0"},
+		{scale, "2.5"},
+	}
+
+	for _, c := range wrong {
+		_, err := c.schema.Normalize(c.answer)
+		suite.Error(err, c.answer)
+	}
+}
+
+func (suite *AnswerSchemaSuite) TestCategories() {
+	suite.Equal([]string{"yes", "maybe", "no"}, DefaultAnswerSchema.Categories())
+	suite.Equal([]string{"1", "2", "3"}, (&AnswerSchema{Type: ScaleAnswer, Min: 1, Max: 3}).Categories())
+	suite.Nil((&AnswerSchema{Type: MultipleChoice, Labels: []string{"a"}}).Categories())
+}
+
+func TestAnswerSchema(t *testing.T) {
+	suite.Run(t, new(AnswerSchemaSuite))
+}
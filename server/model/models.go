@@ -1,14 +1,34 @@
 package model
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
 
 // User of the application; can be Requester or Workers
 type User struct {
 	ID        int
-	Login     string // GitHub account username
-	Username  string // Real name, as returned by GitHub
+	Login     string // account username in the authentication provider
+	Username  string // Real name, as returned by the provider
 	AvatarURL string
 	Role      Role
+	// CreatedAt, UpdatedAt and LastLoginAt are nil when unknown
+	CreatedAt   *time.Time
+	UpdatedAt   *time.Time
+	LastLoginAt *time.Time
+	// Provider is the name of the authentication provider the user logs in with
+	Provider string
+	// PasswordHash is the bcrypt hash of the password of the users of the
+	// local provider, empty for the rest
+	PasswordHash string
+	// DeactivatedAt is nil unless the user was deactivated
+	DeactivatedAt *time.Time
+}
+
+// Active returns true if the User was not deactivated. Deactivated users can
+// not log in nor get new assignments, but their answers are kept
+func (u *User) Active() bool {
+	return u.DeactivatedAt == nil
 }
 
 // Experiment groups a certain amount of FilePairs
@@ -16,6 +36,23 @@ type Experiment struct {
 	ID          int
 	Name        string
 	Description string
+	// WorkersPerPair is the number of workers that must answer each pair; with
+	// 0 every worker answers every pair
+	WorkersPerPair int
+	// SharedPairsRatio is the share of pairs answered by every worker, when
+	// WorkersPerPair is set
+	SharedPairsRatio float64
+	// MinGoldAccuracy is the accuracy on gold pairs below which a worker does
+	// not get more assignments; 0 disables the check
+	MinGoldAccuracy float64
+	// MinGoldAnswers is the number of gold pairs a worker must answer before
+	// MinGoldAccuracy is checked
+	MinGoldAnswers int
+	// AnswerSchema defines the answers accepted for the pairs
+	AnswerSchema AnswerSchema
+	// CreatedAt and UpdatedAt are nil when unknown
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
 }
 
 // Assignment tracks the answer of a worker to a given FilePair of an Experiment
@@ -26,6 +63,15 @@ type Assignment struct {
 	ExperimentID int
 	Answer       sql.NullString
 	Duration     int
+	// Comment is an optional note of the worker about the answer
+	Comment string
+	// Flags are the problems of the FilePair reported by the worker
+	Flags []Flag
+	// CreatedAt and UpdatedAt are nil when unknown; AnsweredAt is the time of
+	// the first answer, nil if unanswered
+	CreatedAt  *time.Time
+	UpdatedAt  *time.Time
+	AnsweredAt *time.Time
 }
 
 // FilePair represents the pairs of files to annotate
@@ -36,6 +82,9 @@ type FilePair struct {
 	ExperimentID int
 	Left         File
 	Right        File
+	// CreatedAt and UpdatedAt are nil when unknown
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
 }
 
 // File contains the info of a File
@@ -48,6 +97,49 @@ type File struct {
 	Hash         string
 }
 
+// Feature is a weighted feature of a File, as used by the similarity model
+type Feature struct {
+	BlobID string
+	Name   string
+	Weight float64
+}
+
+// BlocksWorker returns true if the worker accuracy on gold pairs is under the
+// minimum required by the Experiment, so it should not get more assignments
+func (e *Experiment) BlocksWorker(w *WorkerAccuracy) bool {
+	if e.MinGoldAccuracy <= 0 || w.Answered == 0 || w.Answered < e.MinGoldAnswers {
+		return false
+	}
+
+	return w.Accuracy() < e.MinGoldAccuracy
+}
+
+// GoldAnswer is the expected answer of a FilePair, used to score the workers
+type GoldAnswer struct {
+	PairID       int
+	ExperimentID int
+	Answer       string
+}
+
+// WorkerAccuracy is the score of a worker on the gold pairs of an Experiment
+type WorkerAccuracy struct {
+	UserID int
+	Login  string
+	// Answered is the number of gold pairs answered, skipped ones excluded
+	Answered int
+	// Correct is the number of answers matching the gold answer
+	Correct int
+}
+
+// Accuracy returns the ratio of correct answers, or 0 without answers
+func (w *WorkerAccuracy) Accuracy() float64 {
+	if w.Answered == 0 {
+		return 0
+	}
+
+	return float64(w.Correct) / float64(w.Answered)
+}
+
 // Role represents the position of a app User
 type Role string
 
@@ -58,10 +150,73 @@ const (
 	Worker Role = "worker"
 )
 
-// Answers lists the accepted answers
-var Answers = map[string]string{
-	"yes":   "yes",
-	"maybe": "maybe",
-	"no":    "no",
-	"skip":  "skip",
+// Roles lists the accepted roles
+var Roles = map[Role]Role{
+	Requester: Requester,
+	Worker:    Worker,
+}
+
+// AssignmentEvent records a change of an Assignment made by its worker
+type AssignmentEvent struct {
+	ID           int
+	AssignmentID int
+	UserID       int
+	PairID       int
+	ExperimentID int
+	CreatedAt    time.Time
+	OldAnswer    sql.NullString
+	NewAnswer    sql.NullString
+	OldDuration  int
+	NewDuration  int
+	OldComment   string
+	NewComment   string
+	OldFlags     []Flag
+	NewFlags     []Flag
+	Client       ClientInfo
+}
+
+// ClientInfo identifies the client that made a request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is the server side record of the tokens issued to a User on a log
+// in. Its ID is the token ID; a refreshed token gets a new Session
+type Session struct {
+	ID        string
+	UserID    int
+	CreatedAt time.Time
+	// ExpiresAt is the time until the token can be refreshed
+	ExpiresAt time.Time
+	// RevokedAt is nil unless the session was closed
+	RevokedAt *time.Time
+}
+
+// Active returns true if the Session was not revoked and can still be
+// refreshed at the given time
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Flag is a problem of a FilePair reported by a worker
+type Flag string
+
+const (
+	// BinaryFlag marks pairs with binary files
+	BinaryFlag Flag = "binary"
+	// TruncatedFlag marks pairs with incomplete file contents
+	TruncatedFlag Flag = "truncated"
+	// GeneratedFlag marks pairs with generated or vendored code
+	GeneratedFlag Flag = "generated"
+	// BrokenDiffFlag marks pairs whose diff can not be read
+	BrokenDiffFlag Flag = "broken-diff"
+)
+
+// Flags lists the accepted flags
+var Flags = map[Flag]Flag{
+	BinaryFlag:     BinaryFlag,
+	TruncatedFlag:  TruncatedFlag,
+	GeneratedFlag:  GeneratedFlag,
+	BrokenDiffFlag: BrokenDiffFlag,
 }
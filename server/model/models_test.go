@@ -0,0 +1,60 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ModelsSuite struct {
+	suite.Suite
+}
+
+func (suite *ModelsSuite) TestAccuracy() {
+	cases := []struct {
+		answered, correct int
+		expected          float64
+	}{
+		{0, 0, 0},
+		{4, 0, 0},
+		{4, 1, 0.25},
+		{3, 2, 2.0 / 3},
+		{5, 5, 1},
+	}
+
+	for _, c := range cases {
+		w := &WorkerAccuracy{Answered: c.answered, Correct: c.correct}
+		suite.InDelta(c.expected, w.Accuracy(), 1e-9, "%+v", c)
+	}
+}
+
+func (suite *ModelsSuite) TestBlocksWorker() {
+	exp := &Experiment{MinGoldAccuracy: 0.5, MinGoldAnswers: 2}
+	cases := []struct {
+		answered, correct int
+		blocked           bool
+	}{
+		{0, 0, false},
+		// not enough gold answers yet
+		{1, 0, false},
+		{2, 0, true},
+		{2, 1, false},
+		{4, 1, true},
+		{4, 2, false},
+	}
+
+	for _, c := range cases {
+		w := &WorkerAccuracy{Answered: c.answered, Correct: c.correct}
+		suite.Equal(c.blocked, exp.BlocksWorker(w), "%+v", c)
+	}
+
+	// without minimum accuracy nobody is blocked
+	suite.False((&Experiment{MinGoldAnswers: 1}).BlocksWorker(&WorkerAccuracy{Answered: 3}))
+
+	// without minimum answers the first answer counts
+	suite.True((&Experiment{MinGoldAccuracy: 1}).BlocksWorker(&WorkerAccuracy{Answered: 1}))
+}
+
+func TestModels(t *testing.T) {
+	suite.Run(t, new(ModelsSuite))
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// AssignmentEvents repository. The events are written by Assignments.Update
+type AssignmentEvents struct {
+	db *sql.DB
+}
+
+// NewAssignmentEvents returns a new AssignmentEvents repository
+func NewAssignmentEvents(db *sql.DB) *AssignmentEvents {
+	return &AssignmentEvents{db: db}
+}
+
+const (
+	insertAssignmentEventsSQL = `INSERT INTO assignment_events (
+		assignment_id, user_id, pair_id, experiment_id, created_at,
+		old_answer, new_answer, old_duration, new_duration,
+		old_comment, new_comment, old_flags, new_flags,
+		client_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	// a 0 pair or user ID matches all of them
+	selectAssignmentEventsSQL = `SELECT * FROM assignment_events
+		WHERE experiment_id=$1 AND ($2=0 OR pair_id=$2) AND ($3=0 OR user_id=$3)
+		ORDER BY id LIMIT $4 OFFSET $5`
+)
+
+// EventsFilter selects the AssignmentEvents of an experiment; zero PairID or
+// UserID match any pair or user
+type EventsFilter struct {
+	ExperimentID int
+	PairID       int
+	UserID       int
+}
+
+// List returns at most limit AssignmentEvents matching the filter, in the
+// order they happened and skipping the first offset ones
+func (repo *AssignmentEvents) List(filter EventsFilter, limit, offset int) ([]*model.AssignmentEvent, error) {
+	rows, err := repo.db.Query(selectAssignmentEventsSQL,
+		filter.ExperimentID, filter.PairID, filter.UserID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting assignment events from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.AssignmentEvent, 0)
+
+	for rows.Next() {
+		var e model.AssignmentEvent
+		var oldFlags, newFlags string
+
+		err := rows.Scan(&e.ID, &e.AssignmentID, &e.UserID, &e.PairID, &e.ExperimentID,
+			&e.CreatedAt, &e.OldAnswer, &e.NewAnswer, &e.OldDuration, &e.NewDuration,
+			&e.OldComment, &e.NewComment, &oldFlags, &newFlags,
+			&e.Client.IP, &e.Client.UserAgent)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting assignment events from the DB: %v", err)
+		}
+
+		e.OldFlags = decodeFlags(oldFlags)
+		e.NewFlags = decodeFlags(newFlags)
+		results = append(results, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -22,12 +25,102 @@ func NewAssignments(db *sql.DB) *Assignments {
 var ErrNoAssignmentsInitialized = fmt.Errorf("No assignments initialized")
 
 const (
-	insertAssignmentsSQL = `INSERT INTO assignments (user_id, pair_id, experiment_id, answer, duration) VALUES ($1, $2, $3, $4, $5)`
-	selectIDFilePairsSQL = `SELECT id FROM file_pairs WHERE experiment_id=$1`
-	selectAssignmentsSQL = `SELECT * FROM assignments WHERE user_id=$1 AND experiment_id=$2`
-	updateAssignmentsSQL = `UPDATE assignments SET answer='%v', duration=%v WHERE id=%v`
+	insertAssignmentsSQL        = `INSERT INTO assignments (user_id, pair_id, experiment_id, answer, duration, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $6)`
+	selectIDFilePairsSQL        = `SELECT id FROM file_pairs WHERE experiment_id=$1`
+	selectAssignmentsSQL        = `SELECT * FROM assignments WHERE user_id=$1 AND experiment_id=$2`
+	selectAssignmentsWhereIDSQL = `SELECT * FROM assignments WHERE id=$1`
+	selectAssignmentsPairSQL    = `SELECT * FROM assignments WHERE user_id=$1 AND pair_id=$2`
+	updateAssignmentsSQL        = `UPDATE assignments SET answer=$1, duration=$2, comment=$3, flags=$4,
+		updated_at=$5, answered_at=COALESCE(answered_at, $5) WHERE id=$6`
+
+	selectAnsweredAssignmentsSQL = `SELECT * FROM assignments WHERE experiment_id=$1 AND answer IS NOT NULL`
+	selectFlaggedAssignmentsSQL  = `SELECT * FROM assignments WHERE experiment_id=$1 AND flags <> '' ORDER BY pair_id, user_id`
+
+	selectProgressSQL       = `SELECT COUNT(*), COUNT(answer) FROM assignments WHERE user_id=$1 AND experiment_id=$2`
+	selectNextAssignmentSQL = `SELECT assignments.* FROM assignments
+		JOIN file_pairs ON file_pairs.id = assignments.pair_id
+		WHERE assignments.user_id=$1 AND assignments.experiment_id=$2 AND assignments.answer IS NULL
+		ORDER BY <ORDER>, assignments.pair_id LIMIT 1`
+	// selectNextRandomAssignmentSQL sorts by the randomOrderKey of the pairs
+	selectNextRandomAssignmentSQL = `SELECT * FROM assignments
+		WHERE user_id=$1 AND experiment_id=$2 AND answer IS NULL
+		ORDER BY (CAST(pair_id AS BIGINT) * $3 + $4) % $5, pair_id LIMIT 1`
+
+	// candidatePairsSQL selects, as the candidates table, the file pairs of
+	// an experiment not yet assigned to a user that can still be assigned,
+	// following the distribution policy of the experiment: gold and shared
+	// pairs are always available, the rest only while they have less workers
+	// than WorkersPerPair. The shared pairs are chosen as isSharedPair does.
+	// The unanswered assignments of deactivated users are not counted, so the
+	// pairs are given to other workers.
+	// SQLite numbers the parameters in order of appearance, so they must
+	// appear sorted
+	candidatePairsSQL = `WITH unassigned AS (
+			SELECT id FROM file_pairs WHERE experiment_id=$1 AND NOT EXISTS (
+				SELECT 1 FROM assignments WHERE assignments.experiment_id=$1
+				AND assignments.pair_id = file_pairs.id AND assignments.user_id=$2)
+		), counted AS (
+			SELECT id,
+				(EXISTS (SELECT 1 FROM gold_answers WHERE gold_answers.pair_id = unassigned.id)
+					OR (CAST(id AS BIGINT) * $3 + $4) % $5 < $6) AS shared,
+				(SELECT COUNT(*) FROM assignments
+					WHERE assignments.experiment_id=$1 AND assignments.pair_id = unassigned.id
+					AND (assignments.answer IS NOT NULL OR assignments.user_id NOT IN (
+						SELECT id FROM users WHERE deactivated_at IS NOT NULL))) AS workers
+			FROM unassigned
+		), candidates AS (
+			SELECT * FROM counted WHERE shared OR workers < $7
+		)`
+	// selectNextCandidateSQL returns the candidate pair to assign next, as
+	// nextCandidate chooses it, and whether it is shared
+	selectNextCandidateSQL = candidatePairsSQL + ` SELECT id, shared FROM candidates
+		ORDER BY shared DESC, CASE WHEN shared THEN 0 ELSE workers END, id LIMIT 1`
+	countCandidatePairsSQL = candidatePairsSQL + ` SELECT COUNT(*) FROM candidates`
+	// lockFilePairsSQL locks the row of a file pair until the end of the
+	// transaction, so its workers are counted and assigned by one
+	// transaction at a time
+	lockFilePairsSQL = `UPDATE file_pairs SET updated_at=updated_at WHERE id=$1`
+	// selectPairWorkersSQL counts the workers of a pair as
+	// candidatePairsSQL does
+	selectPairWorkersSQL = `SELECT COUNT(*) FROM assignments WHERE pair_id=$1
+		AND (answer IS NOT NULL OR user_id NOT IN (SELECT id FROM users WHERE deactivated_at IS NOT NULL))`
 )
 
+// assignNextAttempts is the number of times AssignNext looks for a pair when
+// the chosen one is filled by other workers at the same time
+const assignNextAttempts = 5
+
+// QueueOrder is the order in which the unanswered Assignments are returned by
+// GetNextUnanswered
+type QueueOrder string
+
+const (
+	// ImportOrder follows the order in which the file pairs were imported
+	ImportOrder QueueOrder = "import"
+	// RandomOrder is a random order, different but stable for each user
+	RandomOrder QueueOrder = "random"
+	// ScoreAscOrder returns first the file pairs with a lower score
+	ScoreAscOrder QueueOrder = "score-asc"
+	// ScoreDescOrder returns first the file pairs with a higher score
+	ScoreDescOrder QueueOrder = "score-desc"
+)
+
+// queueOrderBy contains the ORDER BY clause for each QueueOrder sorted by SQL
+var queueOrderBy = map[QueueOrder]string{
+	ImportOrder:    "file_pairs.id",
+	ScoreAscOrder:  "file_pairs.score ASC",
+	ScoreDescOrder: "file_pairs.score DESC",
+}
+
+// Valid returns true if the QueueOrder is one of the known orders
+func (o QueueOrder) Valid() bool {
+	_, ok := queueOrderBy[o]
+	return ok || o == RandomOrder
+}
+
+// ErrWrongQueueOrder is returned by GetNextUnanswered for unknown orders
+var ErrWrongQueueOrder = fmt.Errorf("Wrong queue order")
+
 // Initialize builds the assignments for the given user and experiment IDs
 func (repo *Assignments) Initialize(userID int, experimentID int) ([]*model.Assignment, error) {
 	tx, err := repo.db.Begin()
@@ -47,12 +140,13 @@ func (repo *Assignments) Initialize(userID int, experimentID int) ([]*model.Assi
 	defer rows.Close()
 
 	duration := 0
+	now := time.Now().UTC()
 
 	for rows.Next() {
 		var pairID int
 		rows.Scan(&pairID)
 
-		_, err := insert.Exec(userID, pairID, experimentID, nil, duration)
+		_, err := insert.Exec(userID, pairID, experimentID, nil, duration, now)
 		if err != nil {
 			return nil, fmt.Errorf("DB error: %v", err)
 		}
@@ -66,13 +160,50 @@ func (repo *Assignments) Initialize(userID int, experimentID int) ([]*model.Assi
 	return repo.GetAll(userID, experimentID)
 }
 
+// scanAssignment builds an Assignment from the given scanner
+func scanAssignment(row scanner) (*model.Assignment, error) {
+	var as model.Assignment
+	var flags string
+
+	err := row.Scan(&as.ID, &as.UserID, &as.PairID, &as.ExperimentID,
+		&as.Answer, &as.Duration, &as.Comment, &flags,
+		&as.CreatedAt, &as.UpdatedAt, &as.AnsweredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	as.Flags = decodeFlags(flags)
+	return &as, nil
+}
+
+// decodeFlags returns the Flags stored as a comma separated list
+func decodeFlags(flags string) []model.Flag {
+	result := make([]model.Flag, 0)
+	if flags == "" {
+		return result
+	}
+
+	for _, f := range strings.Split(flags, ",") {
+		result = append(result, model.Flag(f))
+	}
+
+	return result
+}
+
+// encodeFlags returns the Flags as a comma separated list to be stored
+func encodeFlags(flags []model.Flag) string {
+	values := make([]string, len(flags))
+	for i, f := range flags {
+		values[i] = string(f)
+	}
+
+	return strings.Join(values, ",")
+}
+
 // getWithQuery builds a Assignment from the given sql QueryRow. If the
 // Assignment does not exist, it returns nil, nil
 func (repo *Assignments) getWithQuery(queryRow *sql.Row) (*model.Assignment, error) {
-	var as model.Assignment
-
-	err := queryRow.Scan(&as.ID, &as.UserID, &as.PairID, &as.ExperimentID,
-		&as.Answer, &as.Duration)
+	as, err := scanAssignment(queryRow)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -80,7 +211,7 @@ func (repo *Assignments) getWithQuery(queryRow *sql.Row) (*model.Assignment, err
 	case err != nil:
 		return nil, fmt.Errorf("Error getting assignment from the DB: %v", err)
 	default:
-		return &as, nil
+		return as, nil
 	}
 }
 
@@ -88,7 +219,7 @@ func (repo *Assignments) getWithQuery(queryRow *sql.Row) (*model.Assignment, err
 // exist, it returns nil, nil
 func (repo *Assignments) GetByID(id int) (*model.Assignment, error) {
 	return repo.getWithQuery(
-		repo.db.QueryRow("SELECT * FROM assignments WHERE id=$1", id))
+		repo.db.QueryRow(selectAssignmentsWhereIDSQL, id))
 }
 
 // GetAll returns all the Assignments for the given user and experiment IDs.
@@ -103,11 +234,12 @@ func (repo *Assignments) GetAll(userID, experimentID int) ([]*model.Assignment,
 	results := make([]*model.Assignment, 0)
 
 	for rows.Next() {
-		var as model.Assignment
-		rows.Scan(&as.ID, &as.UserID, &as.PairID, &as.ExperimentID,
-			&as.Answer, &as.Duration)
+		as, err := scanAssignment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting assignments from the DB: %v", err)
+		}
 
-		results = append(results, &as)
+		results = append(results, as)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -121,16 +253,324 @@ func (repo *Assignments) GetAll(userID, experimentID int) ([]*model.Assignment,
 	return results, nil
 }
 
-// Update updates the Assignment identified by the given user and pair IDs,
-// with the given answer and duration
-func (repo *Assignments) Update(assignmentID int, answer string, duration int) error {
-	if _, ok := model.Answers[answer]; !ok {
-		return fmt.Errorf("Wrong answer provided: '%s'", answer)
+// GetAnswered returns the answered Assignments of all the users for the given
+// experiment ID
+func (repo *Assignments) GetAnswered(experimentID int) ([]*model.Assignment, error) {
+	rows, err := repo.db.Query(selectAnsweredAssignmentsSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting assignments from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Assignment, 0)
+
+	for rows.Next() {
+		as, err := scanAssignment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting assignments from the DB: %v", err)
+		}
+
+		results = append(results, as)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// Progress returns the number of answered Assignments, and the total number of
+// Assignments, for the given user and experiment IDs
+func (repo *Assignments) Progress(userID, experimentID int) (answered, total int, err error) {
+	err = repo.db.QueryRow(selectProgressSQL, userID, experimentID).Scan(&total, &answered)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error getting assignments progress from the DB: %v", err)
+	}
+
+	return answered, total, nil
+}
+
+// GetNextUnanswered returns the first unanswered Assignment for the given user
+// and experiment IDs, following the given order. If all the Assignments are
+// answered, it returns nil, nil
+func (repo *Assignments) GetNextUnanswered(userID, experimentID int, order QueueOrder) (*model.Assignment, error) {
+	if order == RandomOrder {
+		return repo.getNextRandom(userID, experimentID)
+	}
+
+	orderBy, ok := queueOrderBy[order]
+	if !ok {
+		return nil, ErrWrongQueueOrder
+	}
+
+	query := strings.Replace(selectNextAssignmentSQL, "<ORDER>", orderBy, 1)
+	return repo.getWithQuery(repo.db.QueryRow(query, userID, experimentID))
+}
+
+// getNextRandom returns the unanswered Assignment with the lowest
+// randomOrderKey, so each user gets a different order that is kept between
+// requests
+func (repo *Assignments) getNextRandom(userID, experimentID int) (*model.Assignment, error) {
+	mult, offset := randomOrderParams(userID)
+	return repo.getWithQuery(repo.db.QueryRow(selectNextRandomAssignmentSQL,
+		userID, experimentID, mult, offset, randomOrderModulus))
+}
+
+// randomOrderModulus is a prime larger than any pair ID, so randomOrderKey
+// gives a different key to each pair
+const randomOrderModulus = 2147483647
+
+// randomOrderParams returns the multiplier and offset of the RandomOrder of
+// the given user, taken from a hash of its ID
+func randomOrderParams(userID int) (mult, offset int64) {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d", userID)
+	sum := h.Sum64()
+
+	return 1 + int64(sum%(randomOrderModulus-1)), int64((sum >> 32) % randomOrderModulus)
+}
+
+// randomOrderKey returns the position of the pair in the RandomOrder of the
+// given user, as computed by selectNextRandomAssignmentSQL
+func randomOrderKey(userID, pairID int) int64 {
+	mult, offset := randomOrderParams(userID)
+	return (int64(pairID)*mult + offset) % randomOrderModulus
+}
+
+// candidatePair is a file pair that can be assigned to a user
+type candidatePair struct {
+	ID      int
+	Workers int
+	Gold    bool
+	Shared  bool
+}
+
+// sharedPairsParams returns the multiplier, offset and threshold that choose
+// the shared pairs of the experiment: the pairs whose key, computed as
+// randomOrderKey does with the multiplier and offset, is under the threshold,
+// the SharedPairsRatio of randomOrderModulus.
+// They are taken from a hash of the experiment ID, so the choice is stable and
+// does not need to be stored
+func sharedPairsParams(exp *model.Experiment) (mult, offset, threshold int64) {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "shared:%d", exp.ID)
+	sum := h.Sum64()
+
+	mult = 1 + int64(sum%(randomOrderModulus-1))
+	offset = int64((sum >> 32) % randomOrderModulus)
+	if exp.SharedPairsRatio > 0 {
+		threshold = int64(exp.SharedPairsRatio * randomOrderModulus)
 	}
 
-	cmd := fmt.Sprintf(updateAssignmentsSQL, answer, duration, assignmentID)
+	return mult, offset, threshold
+}
+
+// isSharedPair returns true if the pair must be answered by every worker of
+// the experiment, as candidatePairsSQL decides it
+func isSharedPair(exp *model.Experiment, pairID int) bool {
+	mult, offset, threshold := sharedPairsParams(exp)
+	return (int64(pairID)*mult+offset)%randomOrderModulus < threshold
+}
+
+// candidatePairsArgs returns the arguments of the queries made with
+// candidatePairsSQL
+func candidatePairsArgs(userID int, exp *model.Experiment) []interface{} {
+	mult, offset, threshold := sharedPairsParams(exp)
+	return []interface{}{exp.ID, userID, mult, offset, randomOrderModulus, threshold, exp.WorkersPerPair}
+}
 
-	_, err := repo.db.Exec(cmd)
+// filterCandidates returns the pairs, sorted by ID, that can still be
+// assigned following the distribution policy of the experiment, as
+// candidatePairsSQL does
+func filterCandidates(exp *model.Experiment, pairs []candidatePair) []candidatePair {
+	var candidates []candidatePair
+	for _, c := range pairs {
+		// every worker answers the gold pairs, to measure its accuracy
+		c.Shared = c.Gold || isSharedPair(exp, c.ID)
+		if c.Shared || c.Workers < exp.WorkersPerPair {
+			candidates = append(candidates, c)
+		}
+	}
 
-	return err
+	return candidates
+}
+
+// nextCandidate returns the candidate to assign next: the first shared pair,
+// or else the pair with fewer workers
+func nextCandidate(candidates []candidatePair) candidatePair {
+	next := candidates[0]
+	for _, c := range candidates[1:] {
+		switch {
+		case next.Shared:
+			// candidates are sorted by ID, the first shared pair wins
+		case c.Shared || c.Workers < next.Workers:
+			next = c
+		}
+	}
+
+	return next
+}
+
+// Available returns the number of file pairs of the experiment that could
+// still be assigned to the given user, following the distribution policy of
+// the experiment
+func (repo *Assignments) Available(userID int, exp *model.Experiment) (int, error) {
+	var available int
+	err := repo.db.QueryRow(countCandidatePairsSQL, candidatePairsArgs(userID, exp)...).Scan(&available)
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return available, nil
+}
+
+// AssignNext creates a new Assignment for the given user, following the
+// distribution policy of the experiment. Shared pairs are assigned first, then
+// the pairs with fewer workers. It also returns the number of pairs that are
+// still available to the user, as Available. If there are no pairs left for
+// the user, it returns nil, 0, nil.
+// The chosen pair is locked while its workers are counted again, so
+// concurrent calls do not give it more than WorkersPerPair workers
+func (repo *Assignments) AssignNext(userID int, exp *model.Experiment) (*model.Assignment, int, error) {
+	for attempt := 0; attempt < assignNextAttempts; attempt++ {
+		pairID, err := repo.assignNext(userID, exp)
+		if err == errPairFilled {
+			continue
+		}
+
+		if err != nil || pairID == 0 {
+			return nil, 0, err
+		}
+
+		as, err := repo.getWithQuery(repo.db.QueryRow(selectAssignmentsPairSQL, userID, pairID))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		available, err := repo.Available(userID, exp)
+		return as, available, err
+	}
+
+	return nil, 0, fmt.Errorf("Failed to assign a file pair after %d attempts", assignNextAttempts)
+}
+
+// errPairFilled is returned by assignNext when the chosen pair got all its
+// workers from other transactions
+var errPairFilled = fmt.Errorf("file pair already filled")
+
+// assignNext makes an attempt of AssignNext. It returns the ID of the
+// assigned pair, or 0 if there are no pairs left
+func (repo *Assignments) assignNext(userID int, exp *model.Experiment) (int, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var next candidatePair
+	err = tx.QueryRow(selectNextCandidateSQL, candidatePairsArgs(userID, exp)...).Scan(&next.ID, &next.Shared)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("Error getting file_pairs from the DB: %v", err)
+	}
+
+	if !next.Shared {
+		if _, err := tx.Exec(lockFilePairsSQL, next.ID); err != nil {
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		var workers int
+		if err := tx.QueryRow(selectPairWorkersSQL, next.ID).Scan(&workers); err != nil {
+			return 0, fmt.Errorf("DB error: %v", err)
+		}
+
+		if workers >= exp.WorkersPerPair {
+			return 0, errPairFilled
+		}
+	}
+
+	if _, err := tx.Exec(insertAssignmentsSQL, userID, next.ID, exp.ID, nil, 0, time.Now().UTC()); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DB error: %v", err)
+	}
+
+	return next.ID, nil
+}
+
+// Update stores the answer, duration, comment and flags of the given
+// Assignment, identified by its ID. The answer must be already validated
+// against the AnswerSchema of the Experiment. The change is recorded as an
+// AssignmentEvent made from the given client, in the same transaction
+func (repo *Assignments) Update(as *model.Assignment, client model.ClientInfo) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanAssignment(tx.QueryRow(selectAssignmentsWhereIDSQL, as.ID))
+	if err != nil {
+		return fmt.Errorf("Error getting assignment from the DB: %v", err)
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(updateAssignmentsSQL,
+		as.Answer, as.Duration, as.Comment, encodeFlags(as.Flags), now, as.ID)
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	_, err = tx.Exec(insertAssignmentEventsSQL,
+		as.ID, old.UserID, old.PairID, old.ExperimentID, now,
+		old.Answer, as.Answer, old.Duration, as.Duration,
+		old.Comment, as.Comment, encodeFlags(old.Flags), encodeFlags(as.Flags),
+		client.IP, client.UserAgent)
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	as.UpdatedAt = &now
+	if old.AnsweredAt == nil {
+		as.AnsweredAt = &now
+	}
+
+	return nil
+}
+
+// GetFlagged returns the Assignments of the given experiment ID with any
+// Flag, ordered by pair ID
+func (repo *Assignments) GetFlagged(experimentID int) ([]*model.Assignment, error) {
+	rows, err := repo.db.Query(selectFlaggedAssignmentsSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting assignments from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Assignment, 0)
+
+	for rows.Next() {
+		as, err := scanAssignment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting assignments from the DB: %v", err)
+		}
+
+		results = append(results, as)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
 }
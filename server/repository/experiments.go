@@ -2,7 +2,9 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -17,12 +19,49 @@ func NewExperiments(db *sql.DB) *Experiments {
 	return &Experiments{db: db}
 }
 
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanExperiment builds an Experiment from the given scanner
+func scanExperiment(row scanner) (*model.Experiment, error) {
+	var exp model.Experiment
+	var schema string
+
+	err := row.Scan(&exp.ID, &exp.Name, &exp.Description,
+		&exp.WorkersPerPair, &exp.SharedPairsRatio,
+		&exp.MinGoldAccuracy, &exp.MinGoldAnswers, &schema,
+		&exp.CreatedAt, &exp.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	exp.AnswerSchema = model.DefaultAnswerSchema
+	if schema != "" {
+		if err := json.Unmarshal([]byte(schema), &exp.AnswerSchema); err != nil {
+			return nil, fmt.Errorf("wrong answer schema: %v", err)
+		}
+	}
+
+	return &exp, nil
+}
+
+// encodeAnswerSchema returns the JSON of the given AnswerSchema to be stored
+// in the DB
+func encodeAnswerSchema(schema model.AnswerSchema) (string, error) {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", fmt.Errorf("wrong answer schema: %v", err)
+	}
+
+	return string(b), nil
+}
+
 // getWithQuery builds an Experiment from the given sql QueryRow. If the
 // Experiment does not exist, it returns nil, nil
 func (repo *Experiments) getWithQuery(queryRow *sql.Row) (*model.Experiment, error) {
-	var exp model.Experiment
-
-	err := queryRow.Scan(&exp.ID, &exp.Name, &exp.Description)
+	exp, err := scanExperiment(queryRow)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -30,14 +69,122 @@ func (repo *Experiments) getWithQuery(queryRow *sql.Row) (*model.Experiment, err
 	case err != nil:
 		return nil, fmt.Errorf("Error getting experiment from the DB: %v", err)
 	default:
-		return &exp, nil
+		return exp, nil
 	}
 }
 
-const selectExperimentsSQL = `SELECT * FROM experiments WHERE id=$1`
+const (
+	selectExperimentsSQL           = `SELECT * FROM experiments WHERE id=$1`
+	selectExperimentsWhereNameSQL  = `SELECT * FROM experiments WHERE name=$1`
+	selectExperimentsPageSQL       = `SELECT * FROM experiments ORDER BY id LIMIT $1 OFFSET $2`
+	insertExperimentsSQL           = `INSERT INTO experiments (name, description, workers_per_pair, shared_pairs_ratio, min_gold_accuracy, min_gold_answers, answer_schema, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+	updateExperimentsSQL           = `UPDATE experiments SET name=$1, description=$2, workers_per_pair=$3, shared_pairs_ratio=$4, min_gold_accuracy=$5, min_gold_answers=$6, answer_schema=$7, updated_at=$8 WHERE id=$9`
+	deleteExperimentsSQL           = `DELETE FROM experiments WHERE id=$1`
+	deleteExperimentAssignmentsSQL = `DELETE FROM assignments WHERE experiment_id=$1`
+	deleteExperimentFilePairsSQL   = `DELETE FROM file_pairs WHERE experiment_id=$1`
+	deleteExperimentGoldSQL        = `DELETE FROM gold_answers WHERE experiment_id=$1`
+	deleteExperimentEventsSQL      = `DELETE FROM assignment_events WHERE experiment_id=$1`
+)
 
 // GetByID returns the Experiment with the given ID. If the Experiment does not
 // exist, it returns nil, nil
 func (repo *Experiments) GetByID(id int) (*model.Experiment, error) {
 	return repo.getWithQuery(repo.db.QueryRow(selectExperimentsSQL, id))
 }
+
+// GetByName returns the Experiment with the given name. If the Experiment does
+// not exist, it returns nil, nil
+func (repo *Experiments) GetByName(name string) (*model.Experiment, error) {
+	return repo.getWithQuery(repo.db.QueryRow(selectExperimentsWhereNameSQL, name))
+}
+
+// List returns at most limit Experiments, ordered by ID and skipping the first
+// offset ones
+func (repo *Experiments) List(limit, offset int) ([]*model.Experiment, error) {
+	rows, err := repo.db.Query(selectExperimentsPageSQL, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting experiments from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Experiment, 0)
+
+	for rows.Next() {
+		exp, err := scanExperiment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting experiments from the DB: %v", err)
+		}
+
+		results = append(results, exp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// Create stores an Experiment into the DB. If the Experiment is created, the
+// argument is updated to point to that new Experiment
+func (repo *Experiments) Create(exp *model.Experiment) error {
+	schema, err := encodeAnswerSchema(exp.AnswerSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.db.Exec(insertExperimentsSQL, exp.Name, exp.Description,
+		exp.WorkersPerPair, exp.SharedPairsRatio, exp.MinGoldAccuracy, exp.MinGoldAnswers,
+		schema, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	newExp, err := repo.GetByName(exp.Name)
+	if newExp != nil {
+		*exp = *newExp
+	}
+
+	return err
+}
+
+// Update stores the name, description, distribution policy, gold settings and
+// answer schema of the given Experiment, identified by its ID
+func (repo *Experiments) Update(exp *model.Experiment) error {
+	schema, err := encodeAnswerSchema(exp.AnswerSchema)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = repo.db.Exec(updateExperimentsSQL, exp.Name, exp.Description,
+		exp.WorkersPerPair, exp.SharedPairsRatio, exp.MinGoldAccuracy, exp.MinGoldAnswers,
+		schema, now, exp.ID)
+	if err == nil {
+		exp.UpdatedAt = &now
+	}
+
+	return err
+}
+
+// Delete removes the Experiment with the given ID, together with its
+// FilePairs, their GoldAnswers and the Assignments of those pairs with their
+// AssignmentEvents
+func (repo *Experiments) Delete(id int) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	cmds := []string{deleteExperimentEventsSQL, deleteExperimentAssignmentsSQL, deleteExperimentGoldSQL,
+		deleteExperimentFilePairsSQL, deleteExperimentsSQL}
+
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd, id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
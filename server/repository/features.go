@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// Features repository
+type Features struct {
+	db *sql.DB
+}
+
+// NewFeatures returns a new Features repository
+func NewFeatures(db *sql.DB) *Features {
+	return &Features{db: db}
+}
+
+const selectFeaturesWhereBlobIDSQL = `SELECT * FROM features WHERE blob_id=$1 ORDER BY name`
+
+// GetByBlobID returns the Features of the file with the given blob ID, ordered
+// by name. If there are no Features for the file, it returns an empty slice
+func (repo *Features) GetByBlobID(blobID string) ([]*model.Feature, error) {
+	rows, err := repo.db.Query(selectFeaturesWhereBlobIDSQL, blobID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting features from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Feature, 0)
+
+	for rows.Next() {
+		var f model.Feature
+		if err := rows.Scan(&f.BlobID, &f.Name, &f.Weight); err != nil {
+			return nil, fmt.Errorf("Error getting features from the DB: %v", err)
+		}
+
+		results = append(results, &f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
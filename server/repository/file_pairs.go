@@ -29,7 +29,8 @@ func (repo *FilePairs) getWithQuery(queryRow *sql.Row) (*model.FilePair, error)
 		&pair.Right.BlobID, &pair.Right.RepositoryID, &pair.Right.CommitHash,
 		&pair.Right.Path, &pair.Right.Content, &pair.Right.Hash,
 
-		&pair.Score, &pair.Diff, &pair.ExperimentID)
+		&pair.Score, &pair.Diff, &pair.ExperimentID,
+		&pair.CreatedAt, &pair.UpdatedAt)
 
 	switch {
 	case err == sql.ErrNoRows:
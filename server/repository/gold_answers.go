@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// GoldAnswers repository
+type GoldAnswers struct {
+	db *sql.DB
+}
+
+// NewGoldAnswers returns a new GoldAnswers repository
+func NewGoldAnswers(db *sql.DB) *GoldAnswers {
+	return &GoldAnswers{db: db}
+}
+
+const (
+	selectGoldAnswersSQL    = `SELECT * FROM gold_answers WHERE experiment_id=$1 ORDER BY pair_id`
+	deleteGoldAnswersSQL    = `DELETE FROM gold_answers WHERE pair_id=$1`
+	insertGoldAnswersSQL    = `INSERT INTO gold_answers (pair_id, experiment_id, answer) VALUES ($1, $2, $3)`
+	selectWorkerAccuracySQL = `SELECT users.id, users.login, COUNT(*),
+		SUM(CASE WHEN assignments.answer = gold_answers.answer THEN 1 ELSE 0 END)
+		FROM assignments
+		JOIN gold_answers ON gold_answers.pair_id = assignments.pair_id
+		JOIN users ON users.id = assignments.user_id
+		WHERE assignments.experiment_id=$1 AND assignments.answer IS NOT NULL AND assignments.answer <> $2
+		<USER>
+		GROUP BY users.id, users.login
+		ORDER BY users.id`
+	workerAccuracyUserFilter = `AND assignments.user_id=$3`
+)
+
+// GetByExperiment returns the GoldAnswers of the given experiment ID, ordered
+// by pair ID
+func (repo *GoldAnswers) GetByExperiment(experimentID int) ([]*model.GoldAnswer, error) {
+	rows, err := repo.db.Query(selectGoldAnswersSQL, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting gold answers from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.GoldAnswer, 0)
+
+	for rows.Next() {
+		var g model.GoldAnswer
+		if err := rows.Scan(&g.PairID, &g.ExperimentID, &g.Answer); err != nil {
+			return nil, fmt.Errorf("Error getting gold answers from the DB: %v", err)
+		}
+
+		results = append(results, &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// Set stores the given GoldAnswers in a single transaction, replacing the
+// existing ones for the same pairs. An empty answer removes the GoldAnswer
+func (repo *GoldAnswers) Set(answers ...*model.GoldAnswer) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, g := range answers {
+		if _, err := tx.Exec(deleteGoldAnswersSQL, g.PairID); err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+
+		if g.Answer == "" {
+			continue
+		}
+
+		if _, err := tx.Exec(insertGoldAnswersSQL, g.PairID, g.ExperimentID, g.Answer); err != nil {
+			return fmt.Errorf("DB error: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// Accuracy returns the WorkerAccuracy of every worker that answered gold
+// pairs of the given experiment ID, ordered by user ID. Skipped pairs are not
+// taken into account
+func (repo *GoldAnswers) Accuracy(experimentID int) ([]*model.WorkerAccuracy, error) {
+	query := strings.Replace(selectWorkerAccuracySQL, "<USER>", "", 1)
+	rows, err := repo.db.Query(query, experimentID, model.SkipAnswer)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting worker accuracy from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.WorkerAccuracy, 0)
+
+	for rows.Next() {
+		var w model.WorkerAccuracy
+		if err := rows.Scan(&w.UserID, &w.Login, &w.Answered, &w.Correct); err != nil {
+			return nil, fmt.Errorf("Error getting worker accuracy from the DB: %v", err)
+		}
+
+		results = append(results, &w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// UserAccuracy returns the WorkerAccuracy of the given user on the gold pairs
+// of the given experiment ID. If the user did not answer any gold pair, the
+// returned WorkerAccuracy has no answers
+func (repo *GoldAnswers) UserAccuracy(userID, experimentID int) (*model.WorkerAccuracy, error) {
+	query := strings.Replace(selectWorkerAccuracySQL, "<USER>", workerAccuracyUserFilter, 1)
+
+	w := model.WorkerAccuracy{UserID: userID}
+	err := repo.db.QueryRow(query, experimentID, model.SkipAnswer, userID).
+		Scan(&w.UserID, &w.Login, &w.Answered, &w.Correct)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return &w, nil
+	case err != nil:
+		return nil, fmt.Errorf("Error getting worker accuracy from the DB: %v", err)
+	default:
+		return &w, nil
+	}
+}
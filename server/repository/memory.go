@@ -0,0 +1,850 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// Memory keeps all the data in memory, and gives access to it with the same
+// stores as the SQL repositories. It is meant for tests. The values are
+// copied in and out, so changing them does not change the stored data until
+// they are saved, as with a DB
+type Memory struct {
+	mu sync.Mutex
+
+	users       []*model.User
+	experiments []*model.Experiment
+	pairs       []*model.FilePair
+	features    []*model.Feature
+	assignments []*model.Assignment
+	gold        map[int]*model.GoldAnswer
+	events      []*model.AssignmentEvent
+	sessions    map[string]*model.Session
+
+	lastID map[string]int
+}
+
+// NewMemory returns an empty Memory
+func NewMemory() *Memory {
+	return &Memory{
+		gold:     make(map[int]*model.GoldAnswer),
+		sessions: make(map[string]*model.Session),
+		lastID:   make(map[string]int),
+	}
+}
+
+// Stores returns all the stores of the Memory
+func (m *Memory) Stores() *Stores {
+	return &Stores{
+		Users:            m.Users(),
+		Experiments:      m.Experiments(),
+		Assignments:      m.Assignments(),
+		AssignmentEvents: m.AssignmentEvents(),
+		FilePairs:        m.FilePairs(),
+		Features:         m.Features(),
+		GoldAnswers:      m.GoldAnswers(),
+		Sessions:         m.Sessions(),
+	}
+}
+
+// Users returns the UserStore of the Memory
+func (m *Memory) Users() UserStore { return memoryUsers{m} }
+
+// Experiments returns the ExperimentStore of the Memory
+func (m *Memory) Experiments() ExperimentStore { return memoryExperiments{m} }
+
+// Assignments returns the AssignmentStore of the Memory
+func (m *Memory) Assignments() AssignmentStore { return memoryAssignments{m} }
+
+// AssignmentEvents returns the AssignmentEventStore of the Memory
+func (m *Memory) AssignmentEvents() AssignmentEventStore { return memoryAssignmentEvents{m} }
+
+// FilePairs returns the FilePairStore of the Memory
+func (m *Memory) FilePairs() FilePairStore { return memoryFilePairs{m} }
+
+// Features returns the FeatureStore of the Memory
+func (m *Memory) Features() FeatureStore { return memoryFeatures{m} }
+
+// GoldAnswers returns the GoldAnswerStore of the Memory
+func (m *Memory) GoldAnswers() GoldAnswerStore { return memoryGoldAnswers{m} }
+
+// Sessions returns the SessionStore of the Memory
+func (m *Memory) Sessions() SessionStore { return memorySessions{m} }
+
+// AddFilePair stores a copy of the given FilePair, that is imported with the
+// CLI in the SQL repositories. The argument gets the new ID
+func (m *Memory) AddFilePair(pair *model.FilePair) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	pair.ID = m.nextID("file_pairs")
+	pair.CreatedAt, pair.UpdatedAt = &now, &now
+
+	stored := *pair
+	m.pairs = append(m.pairs, &stored)
+}
+
+// AddFeature stores a copy of the given Feature, that is imported with the
+// CLI in the SQL repositories
+func (m *Memory) AddFeature(feature *model.Feature) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *feature
+	m.features = append(m.features, &stored)
+}
+
+// nextID returns a new ID for the given table, as a DB sequence
+func (m *Memory) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func (m *Memory) user(id int) *model.User {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) pair(id int) *model.FilePair {
+	for _, p := range m.pairs {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+func copyUser(u *model.User) *model.User {
+	c := *u
+	return &c
+}
+
+func copyExperiment(e *model.Experiment) *model.Experiment {
+	c := *e
+	c.AnswerSchema.Labels = append([]string(nil), e.AnswerSchema.Labels...)
+	return &c
+}
+
+func copyAssignment(a *model.Assignment) *model.Assignment {
+	c := *a
+	c.Flags = append([]model.Flag{}, a.Flags...)
+	return &c
+}
+
+type memoryUsers struct {
+	m *Memory
+}
+
+func (s memoryUsers) Create(user *model.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, u := range s.m.users {
+		if u.Login == user.Login {
+			return fmt.Errorf("user %q already exists", user.Login)
+		}
+	}
+
+	now := time.Now().UTC()
+	user.ID = s.m.nextID("users")
+	user.CreatedAt, user.UpdatedAt, user.LastLoginAt = &now, &now, nil
+
+	s.m.users = append(s.m.users, copyUser(user))
+	return nil
+}
+
+func (s memoryUsers) Get(login string) (*model.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, u := range s.m.users {
+		if u.Login == login {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s memoryUsers) GetByID(id int) (*model.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if u := s.m.user(id); u != nil {
+		return copyUser(u), nil
+	}
+
+	return nil, nil
+}
+
+func (s memoryUsers) Update(user *model.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if u := s.m.user(user.ID); u != nil {
+		u.Username, u.AvatarURL, u.Role, u.UpdatedAt = user.Username, user.AvatarURL, user.Role, &now
+		u.DeactivatedAt = nil
+		if user.DeactivatedAt != nil {
+			deactivatedAt := *user.DeactivatedAt
+			u.DeactivatedAt = &deactivatedAt
+		}
+	}
+
+	user.UpdatedAt = &now
+	return nil
+}
+
+func (s memoryUsers) List(filter UsersFilter, limit, offset int) ([]*model.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	match := func(u *model.User) bool {
+		if query != "" && !strings.Contains(strings.ToLower(u.Login), query) &&
+			!strings.Contains(strings.ToLower(u.Username), query) {
+			return false
+		}
+
+		if filter.Role != "" && u.Role != filter.Role {
+			return false
+		}
+
+		return filter.Deactivated == nil || *filter.Deactivated != u.Active()
+	}
+
+	results := make([]*model.User, 0)
+	for _, u := range s.m.users {
+		if match(u) {
+			results = append(results, copyUser(u))
+		}
+	}
+
+	if offset > len(results) {
+		offset = len(results)
+	}
+
+	results = results[offset:]
+	if limit < len(results) {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (s memoryUsers) RecordLogin(user *model.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if u := s.m.user(user.ID); u != nil {
+		u.LastLoginAt = &now
+	}
+
+	user.LastLoginAt = &now
+	return nil
+}
+
+type memoryExperiments struct {
+	m *Memory
+}
+
+func (s memoryExperiments) find(match func(*model.Experiment) bool) *model.Experiment {
+	for _, e := range s.m.experiments {
+		if match(e) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (s memoryExperiments) GetByID(id int) (*model.Experiment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if e := s.find(func(e *model.Experiment) bool { return e.ID == id }); e != nil {
+		return copyExperiment(e), nil
+	}
+
+	return nil, nil
+}
+
+func (s memoryExperiments) GetByName(name string) (*model.Experiment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if e := s.find(func(e *model.Experiment) bool { return e.Name == name }); e != nil {
+		return copyExperiment(e), nil
+	}
+
+	return nil, nil
+}
+
+func (s memoryExperiments) List(limit, offset int) ([]*model.Experiment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := make([]*model.Experiment, 0)
+	for i := offset; i < len(s.m.experiments) && len(results) < limit; i++ {
+		results = append(results, copyExperiment(s.m.experiments[i]))
+	}
+
+	return results, nil
+}
+
+func (s memoryExperiments) Create(exp *model.Experiment) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if s.find(func(e *model.Experiment) bool { return e.Name == exp.Name }) != nil {
+		return fmt.Errorf("experiment %q already exists", exp.Name)
+	}
+
+	now := time.Now().UTC()
+	exp.ID = s.m.nextID("experiments")
+	exp.CreatedAt, exp.UpdatedAt = &now, &now
+
+	s.m.experiments = append(s.m.experiments, copyExperiment(exp))
+	return nil
+}
+
+func (s memoryExperiments) Update(exp *model.Experiment) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if e := s.find(func(e *model.Experiment) bool { return e.Name == exp.Name && e.ID != exp.ID }); e != nil {
+		return fmt.Errorf("experiment %q already exists", exp.Name)
+	}
+
+	now := time.Now().UTC()
+	exp.UpdatedAt = &now
+
+	for i, e := range s.m.experiments {
+		if e.ID == exp.ID {
+			updated := copyExperiment(exp)
+			updated.CreatedAt = e.CreatedAt
+			s.m.experiments[i] = updated
+		}
+	}
+
+	return nil
+}
+
+func (s memoryExperiments) Delete(id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var events []*model.AssignmentEvent
+	for _, e := range s.m.events {
+		if e.ExperimentID != id {
+			events = append(events, e)
+		}
+	}
+
+	var assignments []*model.Assignment
+	for _, a := range s.m.assignments {
+		if a.ExperimentID != id {
+			assignments = append(assignments, a)
+		}
+	}
+
+	for pairID, g := range s.m.gold {
+		if g.ExperimentID == id {
+			delete(s.m.gold, pairID)
+		}
+	}
+
+	var pairs []*model.FilePair
+	for _, p := range s.m.pairs {
+		if p.ExperimentID != id {
+			pairs = append(pairs, p)
+		}
+	}
+
+	var experiments []*model.Experiment
+	for _, e := range s.m.experiments {
+		if e.ID != id {
+			experiments = append(experiments, e)
+		}
+	}
+
+	s.m.events, s.m.assignments, s.m.pairs, s.m.experiments = events, assignments, pairs, experiments
+	return nil
+}
+
+type memoryAssignments struct {
+	m *Memory
+}
+
+// filter returns copies of the stored Assignments that match, sorted by ID
+func (s memoryAssignments) filter(match func(*model.Assignment) bool) []*model.Assignment {
+	results := make([]*model.Assignment, 0)
+	for _, a := range s.m.assignments {
+		if match(a) {
+			results = append(results, copyAssignment(a))
+		}
+	}
+
+	return results
+}
+
+func (s memoryAssignments) create(userID, pairID, experimentID int) *model.Assignment {
+	now := time.Now().UTC()
+	a := &model.Assignment{
+		ID:           s.m.nextID("assignments"),
+		UserID:       userID,
+		PairID:       pairID,
+		ExperimentID: experimentID,
+		Flags:        []model.Flag{},
+		CreatedAt:    &now,
+		UpdatedAt:    &now,
+	}
+
+	s.m.assignments = append(s.m.assignments, a)
+	return copyAssignment(a)
+}
+
+func (s memoryAssignments) Initialize(userID int, experimentID int) ([]*model.Assignment, error) {
+	s.m.mu.Lock()
+	for _, p := range s.m.pairs {
+		if p.ExperimentID == experimentID {
+			s.create(userID, p.ID, experimentID)
+		}
+	}
+	s.m.mu.Unlock()
+
+	return s.GetAll(userID, experimentID)
+}
+
+func (s memoryAssignments) GetByID(id int) (*model.Assignment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if found := s.filter(func(a *model.Assignment) bool { return a.ID == id }); len(found) > 0 {
+		return found[0], nil
+	}
+
+	return nil, nil
+}
+
+func (s memoryAssignments) GetAll(userID, experimentID int) ([]*model.Assignment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := s.filter(func(a *model.Assignment) bool {
+		return a.UserID == userID && a.ExperimentID == experimentID
+	})
+
+	if len(results) == 0 {
+		return nil, ErrNoAssignmentsInitialized
+	}
+
+	return results, nil
+}
+
+func (s memoryAssignments) GetAnswered(experimentID int) ([]*model.Assignment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.filter(func(a *model.Assignment) bool {
+		return a.ExperimentID == experimentID && a.Answer.Valid
+	}), nil
+}
+
+func (s memoryAssignments) Progress(userID, experimentID int) (answered, total int, err error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, a := range s.m.assignments {
+		if a.UserID == userID && a.ExperimentID == experimentID {
+			total++
+			if a.Answer.Valid {
+				answered++
+			}
+		}
+	}
+
+	return answered, total, nil
+}
+
+func (s memoryAssignments) GetNextUnanswered(userID, experimentID int, order QueueOrder) (*model.Assignment, error) {
+	if !order.Valid() {
+		return nil, ErrWrongQueueOrder
+	}
+
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	unanswered := s.filter(func(a *model.Assignment) bool {
+		return a.UserID == userID && a.ExperimentID == experimentID && !a.Answer.Valid
+	})
+
+	if len(unanswered) == 0 {
+		return nil, nil
+	}
+
+	score := func(a *model.Assignment) float64 {
+		if p := s.m.pair(a.PairID); p != nil {
+			return p.Score
+		}
+
+		return 0
+	}
+
+	sort.SliceStable(unanswered, func(i, j int) bool {
+		a, b := unanswered[i], unanswered[j]
+		switch {
+		case order == RandomOrder:
+			return randomOrderKey(userID, a.PairID) < randomOrderKey(userID, b.PairID)
+		case order == ScoreAscOrder && score(a) != score(b):
+			return score(a) < score(b)
+		case order == ScoreDescOrder && score(a) != score(b):
+			return score(a) > score(b)
+		default:
+			return a.PairID < b.PairID
+		}
+	})
+
+	return unanswered[0], nil
+}
+
+// candidatePairs returns the file pairs that can still be assigned to the
+// given user, as candidatePairsSQL
+func (s memoryAssignments) candidatePairs(userID int, exp *model.Experiment) []candidatePair {
+	var pairs []candidatePair
+	for _, p := range s.m.pairs {
+		if p.ExperimentID != exp.ID {
+			continue
+		}
+
+		_, gold := s.m.gold[p.ID]
+		c := candidatePair{ID: p.ID, Gold: gold}
+		assigned := false
+		for _, a := range s.m.assignments {
+			if a.PairID != p.ID {
+				continue
+			}
+
+			if u := s.m.user(a.UserID); a.Answer.Valid || u == nil || u.Active() {
+				c.Workers++
+			}
+
+			assigned = assigned || (a.UserID == userID && a.ExperimentID == exp.ID)
+		}
+
+		if !assigned {
+			pairs = append(pairs, c)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].ID < pairs[j].ID })
+	return filterCandidates(exp, pairs)
+}
+
+func (s memoryAssignments) Available(userID int, exp *model.Experiment) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return len(s.candidatePairs(userID, exp)), nil
+}
+
+func (s memoryAssignments) AssignNext(userID int, exp *model.Experiment) (*model.Assignment, int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	candidates := s.candidatePairs(userID, exp)
+	if len(candidates) == 0 {
+		return nil, 0, nil
+	}
+
+	return s.create(userID, nextCandidate(candidates).ID, exp.ID), len(candidates) - 1, nil
+}
+
+func (s memoryAssignments) Update(as *model.Assignment, client model.ClientInfo) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var old *model.Assignment
+	for _, a := range s.m.assignments {
+		if a.ID == as.ID {
+			old = a
+		}
+	}
+
+	if old == nil {
+		return fmt.Errorf("Error getting assignment from the DB: assignment %d not found", as.ID)
+	}
+
+	now := time.Now().UTC()
+	s.m.events = append(s.m.events, &model.AssignmentEvent{
+		ID:           s.m.nextID("assignment_events"),
+		AssignmentID: as.ID,
+		UserID:       old.UserID,
+		PairID:       old.PairID,
+		ExperimentID: old.ExperimentID,
+		CreatedAt:    now,
+		OldAnswer:    old.Answer,
+		NewAnswer:    as.Answer,
+		OldDuration:  old.Duration,
+		NewDuration:  as.Duration,
+		OldComment:   old.Comment,
+		NewComment:   as.Comment,
+		OldFlags:     append([]model.Flag{}, old.Flags...),
+		NewFlags:     append([]model.Flag{}, as.Flags...),
+		Client:       client,
+	})
+
+	as.UpdatedAt = &now
+	if old.AnsweredAt == nil {
+		as.AnsweredAt = &now
+	}
+
+	old.Answer, old.Duration, old.Comment = as.Answer, as.Duration, as.Comment
+	old.Flags = append([]model.Flag{}, as.Flags...)
+	old.UpdatedAt, old.AnsweredAt = as.UpdatedAt, as.AnsweredAt
+	return nil
+}
+
+func (s memoryAssignments) GetFlagged(experimentID int) ([]*model.Assignment, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := s.filter(func(a *model.Assignment) bool {
+		return a.ExperimentID == experimentID && len(a.Flags) > 0
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].PairID != results[j].PairID {
+			return results[i].PairID < results[j].PairID
+		}
+
+		return results[i].UserID < results[j].UserID
+	})
+
+	return results, nil
+}
+
+type memoryAssignmentEvents struct {
+	m *Memory
+}
+
+func (s memoryAssignmentEvents) List(filter EventsFilter, limit, offset int) ([]*model.AssignmentEvent, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := make([]*model.AssignmentEvent, 0)
+	for _, e := range s.m.events {
+		if e.ExperimentID != filter.ExperimentID ||
+			(filter.PairID != 0 && e.PairID != filter.PairID) ||
+			(filter.UserID != 0 && e.UserID != filter.UserID) {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		if len(results) == limit {
+			break
+		}
+
+		c := *e
+		results = append(results, &c)
+	}
+
+	return results, nil
+}
+
+type memoryFilePairs struct {
+	m *Memory
+}
+
+func (s memoryFilePairs) GetByID(id int) (*model.FilePair, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if p := s.m.pair(id); p != nil {
+		c := *p
+		return &c, nil
+	}
+
+	return nil, nil
+}
+
+type memoryFeatures struct {
+	m *Memory
+}
+
+func (s memoryFeatures) GetByBlobID(blobID string) ([]*model.Feature, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := make([]*model.Feature, 0)
+	for _, f := range s.m.features {
+		if f.BlobID == blobID {
+			c := *f
+			results = append(results, &c)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+type memoryGoldAnswers struct {
+	m *Memory
+}
+
+func (s memoryGoldAnswers) GetByExperiment(experimentID int) ([]*model.GoldAnswer, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := make([]*model.GoldAnswer, 0)
+	for _, g := range s.m.gold {
+		if g.ExperimentID == experimentID {
+			c := *g
+			results = append(results, &c)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].PairID < results[j].PairID })
+	return results, nil
+}
+
+func (s memoryGoldAnswers) Set(answers ...*model.GoldAnswer) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, g := range answers {
+		delete(s.m.gold, g.PairID)
+		if g.Answer != "" {
+			c := *g
+			s.m.gold[g.PairID] = &c
+		}
+	}
+
+	return nil
+}
+
+// accuracy returns the WorkerAccuracy of the workers matching the filter,
+// sorted by user ID
+func (s memoryGoldAnswers) accuracy(experimentID int, match func(userID int) bool) []*model.WorkerAccuracy {
+	byUser := make(map[int]*model.WorkerAccuracy)
+	for _, a := range s.m.assignments {
+		g, ok := s.m.gold[a.PairID]
+		user := s.m.user(a.UserID)
+		if !ok || user == nil || a.ExperimentID != experimentID || !match(a.UserID) ||
+			!a.Answer.Valid || a.Answer.String == model.SkipAnswer {
+			continue
+		}
+
+		w, ok := byUser[a.UserID]
+		if !ok {
+			w = &model.WorkerAccuracy{UserID: user.ID, Login: user.Login}
+			byUser[a.UserID] = w
+		}
+
+		w.Answered++
+		if a.Answer.String == g.Answer {
+			w.Correct++
+		}
+	}
+
+	results := make([]*model.WorkerAccuracy, 0, len(byUser))
+	for _, w := range byUser {
+		results = append(results, w)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].UserID < results[j].UserID })
+	return results
+}
+
+func (s memoryGoldAnswers) Accuracy(experimentID int) ([]*model.WorkerAccuracy, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.accuracy(experimentID, func(int) bool { return true }), nil
+}
+
+func (s memoryGoldAnswers) UserAccuracy(userID, experimentID int) (*model.WorkerAccuracy, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	results := s.accuracy(experimentID, func(id int) bool { return id == userID })
+	if len(results) == 0 {
+		return &model.WorkerAccuracy{UserID: userID}, nil
+	}
+
+	return results[0], nil
+}
+
+type memorySessions struct {
+	m *Memory
+}
+
+func (s memorySessions) Create(session *model.Session) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, ok := s.m.sessions[session.ID]; ok {
+		return fmt.Errorf("session %q already exists", session.ID)
+	}
+
+	c := *session
+	s.m.sessions[session.ID] = &c
+	return nil
+}
+
+func (s memorySessions) Get(id string) (*model.Session, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if session, ok := s.m.sessions[id]; ok {
+		c := *session
+		return &c, nil
+	}
+
+	return nil, nil
+}
+
+func (s memorySessions) Revoke(id string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	session, ok := s.m.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+
+	now := time.Now().UTC()
+	session.RevokedAt = &now
+	return nil
+}
+
+func (s memorySessions) RevokeUser(userID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, session := range s.m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
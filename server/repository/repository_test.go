@@ -0,0 +1,1124 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// trickyText breaks any statement that quotes it into the SQL instead of
+// passing it as a parameter
+const trickyText = `it's "quoted"; DROP TABLE users; -- $1 ? \ ñ`
+
+// postgresDSNEnv is the environment variable with the connection string of a
+// PostgreSQL DB used by the tests. The DB is migrated up and down by them
+const postgresDSNEnv = "POSTGRES_TEST_DSN"
+
+const insertTestFilePairsSQL = `INSERT INTO file_pairs (
+		blob_id_a, repository_id_a, commit_hash_a, path_a, content_a, hash_a,
+		blob_id_b, repository_id_b, commit_hash_b, path_b, content_b, hash_b,
+		score, diff, experiment_id, created_at, updated_at ) VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16)`
+
+const insertTestFeaturesSQL = `INSERT INTO features (blob_id, name, weight) VALUES ($1, $2, $3)`
+
+// stores groups the repositories tested by RepositorySuite, and the way to
+// add the data imported with the CLI
+type stores struct {
+	users       UserStore
+	experiments ExperimentStore
+	assignments AssignmentStore
+	events      AssignmentEventStore
+	pairs       FilePairStore
+	features    FeatureStore
+	gold        GoldAnswerStore
+	sessions    SessionStore
+
+	addPair    func(*model.FilePair) error
+	addFeature func(*model.Feature) error
+}
+
+// sqlStores bootstraps and initializes the DB, and returns its SQL
+// repositories
+func sqlStores(db dbutil.DB) (stores, error) {
+	if err := dbutil.Bootstrap(db); err != nil {
+		return stores{}, err
+	}
+
+	if err := dbutil.Initialize(db); err != nil {
+		return stores{}, err
+	}
+
+	sqlDB := db.SQLDB()
+	return stores{
+		users:       NewUsers(sqlDB),
+		experiments: NewExperiments(sqlDB),
+		assignments: NewAssignments(sqlDB),
+		events:      NewAssignmentEvents(sqlDB),
+		pairs:       NewFilePairs(sqlDB),
+		features:    NewFeatures(sqlDB),
+		gold:        NewGoldAnswers(sqlDB),
+		sessions:    NewSessions(sqlDB),
+
+		addPair: func(p *model.FilePair) error {
+			_, err := sqlDB.Exec(insertTestFilePairsSQL,
+				p.Left.BlobID, p.Left.RepositoryID, p.Left.CommitHash, p.Left.Path, p.Left.Content, p.Left.Hash,
+				p.Right.BlobID, p.Right.RepositoryID, p.Right.CommitHash, p.Right.Path, p.Right.Content, p.Right.Hash,
+				p.Score, p.Diff, p.ExperimentID, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+
+			return sqlDB.QueryRow(`SELECT id FROM file_pairs WHERE blob_id_a=$1`, p.Left.BlobID).Scan(&p.ID)
+		},
+		addFeature: func(f *model.Feature) error {
+			_, err := sqlDB.Exec(insertTestFeaturesSQL, f.BlobID, f.Name, f.Weight)
+			return err
+		},
+	}, nil
+}
+
+// memoryStores returns the stores of a new Memory, with the default
+// experiment created by dbutil.Initialize
+func memoryStores() (stores, error) {
+	m := NewMemory()
+
+	err := m.Experiments().Create(&model.Experiment{Name: "default", Description: "Default experiment"})
+	return stores{
+		users:       m.Users(),
+		experiments: m.Experiments(),
+		assignments: m.Assignments(),
+		events:      m.AssignmentEvents(),
+		pairs:       m.FilePairs(),
+		features:    m.Features(),
+		gold:        m.GoldAnswers(),
+		sessions:    m.Sessions(),
+
+		addPair:    func(p *model.FilePair) error { m.AddFilePair(p); return nil },
+		addFeature: func(f *model.Feature) error { m.AddFeature(f); return nil },
+	}, err
+}
+
+// RepositorySuite runs the repositories returned by open
+type RepositorySuite struct {
+	suite.Suite
+	open func() (stores, func())
+
+	stores
+	close func()
+}
+
+func (suite *RepositorySuite) SetupTest() {
+	suite.stores, suite.close = suite.open()
+}
+
+func (suite *RepositorySuite) TearDownTest() {
+	suite.close()
+}
+
+func (suite *RepositorySuite) createUser(login string, role model.Role) *model.User {
+	user := &model.User{Login: login, Username: trickyText, AvatarURL: trickyText, Role: role,
+		Provider: "local", PasswordHash: trickyText}
+	suite.Require().NoError(suite.users.Create(user))
+	suite.Require().NotZero(user.ID)
+
+	return user
+}
+
+func (suite *RepositorySuite) createExperiment(name string) *model.Experiment {
+	exp := &model.Experiment{
+		Name:        name,
+		Description: trickyText,
+		AnswerSchema: model.AnswerSchema{
+			Type:   model.SingleChoice,
+			Labels: []string{"yes", trickyText},
+		},
+	}
+	suite.Require().NoError(suite.experiments.Create(exp))
+	suite.Require().NotZero(exp.ID)
+
+	return exp
+}
+
+// createPairs adds n file pairs to the experiment and returns their IDs
+func (suite *RepositorySuite) createPairs(exp *model.Experiment, n int) []int {
+	var ids []int
+	for i := 0; i < n; i++ {
+		file := model.File{RepositoryID: trickyText, CommitHash: trickyText,
+			Path: trickyText, Content: trickyText, Hash: trickyText}
+
+		pair := &model.FilePair{Left: file, Right: file,
+			Score: float64(i), Diff: trickyText, ExperimentID: exp.ID}
+		pair.Left.BlobID = fmt.Sprintf("%s a%d", trickyText, i)
+		pair.Right.BlobID = fmt.Sprintf("%s b%d", trickyText, i)
+
+		suite.Require().NoError(suite.addPair(pair))
+		ids = append(ids, pair.ID)
+	}
+
+	return ids
+}
+
+func (suite *RepositorySuite) TestUsers() {
+	require := suite.Require()
+	repo := suite.users
+
+	user := suite.createUser(trickyText, model.Worker)
+	require.Equal(trickyText, user.Login)
+	require.Equal(trickyText, user.Username)
+	require.Equal(trickyText, user.AvatarURL)
+	require.Equal("local", user.Provider)
+	require.Equal(trickyText, user.PasswordHash)
+	require.NotNil(user.CreatedAt)
+	require.Nil(user.LastLoginAt)
+
+	user.Username = trickyText + " updated"
+	user.Role = model.Requester
+	require.NoError(repo.Update(user))
+	require.NoError(repo.RecordLogin(user))
+
+	got, err := repo.GetByID(user.ID)
+	require.NoError(err)
+	require.Equal(trickyText+" updated", got.Username)
+	require.Equal(model.Requester, got.Role)
+	require.NotNil(got.LastLoginAt)
+
+	got, err = repo.Get(trickyText + "'")
+	require.NoError(err)
+	require.Nil(got)
+
+	// the passwords are only set with the SQL repository, by the CLI
+	if users, ok := repo.(*Users); ok {
+		user.PasswordHash = "new hash"
+		require.NoError(users.UpdatePassword(user))
+
+		got, err = repo.GetByID(user.ID)
+		require.NoError(err)
+		require.Equal("new hash", got.PasswordHash)
+		require.Equal(model.Requester, got.Role)
+	}
+
+	worker := suite.createUser("Worker_1", model.Worker)
+	deactivated := suite.createUser("worker%2", model.Worker)
+	now := time.Now().UTC()
+	deactivated.DeactivatedAt = &now
+	require.NoError(repo.Update(deactivated))
+
+	got, err = repo.GetByID(deactivated.ID)
+	require.NoError(err)
+	require.False(got.Active())
+
+	yes, no := true, false
+	filters := []struct {
+		filter UsersFilter
+		users  []*model.User
+	}{
+		{UsersFilter{}, []*model.User{user, worker, deactivated}},
+		{UsersFilter{Query: "WORKER"}, []*model.User{worker, deactivated}},
+		// the wildcards are matched literally
+		{UsersFilter{Query: "r_"}, []*model.User{worker}},
+		{UsersFilter{Query: "%"}, []*model.User{deactivated}},
+		{UsersFilter{Query: `$1 ? \`}, []*model.User{user, worker, deactivated}},
+		{UsersFilter{Role: model.Requester}, []*model.User{user}},
+		{UsersFilter{Deactivated: &no}, []*model.User{user, worker}},
+		{UsersFilter{Query: "worker", Deactivated: &yes}, []*model.User{deactivated}},
+	}
+
+	for _, f := range filters {
+		list, err := repo.List(f.filter, 10, 0)
+		require.NoError(err)
+		require.Len(list, len(f.users), "%+v", f.filter)
+		for i, u := range f.users {
+			require.Equal(u.ID, list[i].ID, "%+v", f.filter)
+		}
+	}
+
+	list, err := repo.List(UsersFilter{}, 1, 1)
+	require.NoError(err)
+	require.Len(list, 1)
+	require.Equal(worker.Login, list[0].Login)
+
+	deactivated.DeactivatedAt = nil
+	require.NoError(repo.Update(deactivated))
+
+	got, err = repo.GetByID(deactivated.ID)
+	require.NoError(err)
+	require.True(got.Active())
+}
+
+func (suite *RepositorySuite) TestExperiments() {
+	require := suite.Require()
+	repo := suite.experiments
+
+	exp := suite.createExperiment(trickyText)
+	require.Equal(trickyText, exp.Name)
+	require.Equal(trickyText, exp.Description)
+	require.Equal([]string{"yes", trickyText}, exp.AnswerSchema.Labels)
+
+	exp.Description = trickyText + " updated"
+	exp.WorkersPerPair = 2
+	exp.SharedPairsRatio = 0.5
+	require.NoError(repo.Update(exp))
+
+	got, err := repo.GetByName(trickyText)
+	require.NoError(err)
+	require.Equal(trickyText+" updated", got.Description)
+	require.Equal(2, got.WorkersPerPair)
+	require.Equal(0.5, got.SharedPairsRatio)
+
+	list, err := repo.List(10, 0)
+	require.NoError(err)
+	require.Len(list, 2)
+	require.Equal(trickyText, list[1].Name)
+
+	// the pairs, assignments, events and gold answers are deleted with it
+	user := suite.createUser("worker", model.Worker)
+	pairs := suite.createPairs(exp, 1)
+	all, err := suite.assignments.Initialize(user.ID, exp.ID)
+	require.NoError(err)
+	all[0].Answer = sql.NullString{String: "yes", Valid: true}
+	require.NoError(suite.assignments.Update(all[0], model.ClientInfo{}))
+	require.NoError(suite.gold.Set(
+		&model.GoldAnswer{PairID: pairs[0], ExperimentID: exp.ID, Answer: "yes"}))
+
+	require.NoError(repo.Delete(exp.ID))
+
+	got, err = repo.GetByID(exp.ID)
+	require.NoError(err)
+	require.Nil(got)
+
+	pair, err := suite.pairs.GetByID(pairs[0])
+	require.NoError(err)
+	require.Nil(pair)
+
+	_, err = suite.assignments.GetAll(user.ID, exp.ID)
+	require.Equal(ErrNoAssignmentsInitialized, err)
+
+	as, err := suite.assignments.GetByID(all[0].ID)
+	require.NoError(err)
+	require.Nil(as)
+
+	answered, err := suite.assignments.GetAnswered(exp.ID)
+	require.NoError(err)
+	require.Empty(answered)
+
+	events, err := suite.events.List(EventsFilter{ExperimentID: exp.ID}, 10, 0)
+	require.NoError(err)
+	require.Empty(events)
+
+	gold, err := suite.gold.GetByExperiment(exp.ID)
+	require.NoError(err)
+	require.Empty(gold)
+}
+
+func (suite *RepositorySuite) TestAssignments() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	user := suite.createUser("worker", model.Worker)
+	exp := suite.createExperiment(trickyText)
+	pairs := suite.createPairs(exp, 2)
+
+	all, err := repo.Initialize(user.ID, exp.ID)
+	require.NoError(err)
+	require.Len(all, 2)
+
+	next, err := repo.GetNextUnanswered(user.ID, exp.ID, ScoreDescOrder)
+	require.NoError(err)
+	require.Equal(pairs[1], next.PairID)
+
+	next.Answer = sql.NullString{String: trickyText, Valid: true}
+	next.Duration = 42
+	next.Comment = trickyText
+	next.Flags = []model.Flag{model.BinaryFlag, model.Flag(trickyText)}
+	require.NoError(repo.Update(next, model.ClientInfo{IP: trickyText, UserAgent: trickyText}))
+	require.NotNil(next.AnsweredAt)
+
+	got, err := repo.GetByID(next.ID)
+	require.NoError(err)
+	require.Equal(trickyText, got.Answer.String)
+	require.Equal(42, got.Duration)
+	require.Equal(trickyText, got.Comment)
+	require.Equal([]model.Flag{model.BinaryFlag, model.Flag(trickyText)}, got.Flags)
+	require.NotNil(got.AnsweredAt)
+
+	answered, err := repo.GetAnswered(exp.ID)
+	require.NoError(err)
+	require.Len(answered, 1)
+
+	flagged, err := repo.GetFlagged(exp.ID)
+	require.NoError(err)
+	require.Len(flagged, 1)
+
+	done, total, err := repo.Progress(user.ID, exp.ID)
+	require.NoError(err)
+	require.Equal(1, done)
+	require.Equal(2, total)
+
+	events, err := suite.events.List(
+		EventsFilter{ExperimentID: exp.ID, UserID: user.ID}, 10, 0)
+	require.NoError(err)
+	require.Len(events, 1)
+	require.False(events[0].OldAnswer.Valid)
+	require.Equal(trickyText, events[0].NewAnswer.String)
+	require.Equal(trickyText, events[0].NewComment)
+	require.Equal(trickyText, events[0].Client.IP)
+	require.Equal(trickyText, events[0].Client.UserAgent)
+}
+
+func (suite *RepositorySuite) TestAssignmentEvents() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	exp := suite.createExperiment(trickyText)
+	pairs := suite.createPairs(exp, 2)
+	other := suite.createExperiment("other")
+	suite.createPairs(other, 1)
+
+	users := []*model.User{
+		suite.createUser("worker", model.Worker),
+		suite.createUser("other", model.Worker),
+	}
+
+	saves := 0
+	for _, user := range users {
+		all, err := repo.Initialize(user.ID, exp.ID)
+		require.NoError(err)
+
+		for _, answer := range []string{"yes", "no"} {
+			for _, as := range all {
+				as.Answer = sql.NullString{String: answer, Valid: true}
+				require.NoError(repo.Update(as, model.ClientInfo{IP: answer}))
+				saves++
+			}
+		}
+
+		all, err = repo.Initialize(user.ID, other.ID)
+		require.NoError(err)
+		all[0].Answer = sql.NullString{String: "yes", Valid: true}
+		require.NoError(repo.Update(all[0], model.ClientInfo{}))
+	}
+
+	// every save writes exactly one event
+	events, err := suite.events.List(EventsFilter{ExperimentID: exp.ID}, 100, 0)
+	require.NoError(err)
+	require.Len(events, saves)
+
+	events, err = suite.events.List(EventsFilter{ExperimentID: exp.ID, PairID: pairs[1]}, 100, 0)
+	require.NoError(err)
+	require.Len(events, 4)
+	for _, e := range events {
+		require.Equal(pairs[1], e.PairID)
+	}
+
+	events, err = suite.events.List(EventsFilter{ExperimentID: exp.ID, UserID: users[1].ID}, 100, 0)
+	require.NoError(err)
+	require.Len(events, 4)
+	for _, e := range events {
+		require.Equal(users[1].ID, e.UserID)
+	}
+
+	events, err = suite.events.List(
+		EventsFilter{ExperimentID: exp.ID, PairID: pairs[0], UserID: users[0].ID}, 100, 0)
+	require.NoError(err)
+	require.Len(events, 2)
+	require.False(events[0].OldAnswer.Valid)
+	require.Equal("yes", events[0].NewAnswer.String)
+	require.Equal("yes", events[1].OldAnswer.String)
+	require.Equal("no", events[1].NewAnswer.String)
+	require.Equal("no", events[1].Client.IP)
+
+	events, err = suite.events.List(EventsFilter{ExperimentID: exp.ID}, 3, saves-2)
+	require.NoError(err)
+	require.Len(events, 2)
+}
+
+func (suite *RepositorySuite) TestTimestamps() {
+	require := suite.Require()
+
+	before := time.Now().UTC().Add(-time.Second)
+	user := suite.createUser("worker", model.Worker)
+
+	got, err := suite.users.GetByID(user.ID)
+	require.NoError(err)
+	require.NotNil(got.CreatedAt)
+	require.True(got.CreatedAt.After(before))
+	require.Nil(got.LastLoginAt)
+
+	require.NoError(suite.users.RecordLogin(user))
+	got, err = suite.users.GetByID(user.ID)
+	require.NoError(err)
+	require.NotNil(got.LastLoginAt)
+	require.True(got.LastLoginAt.After(before))
+	firstLogin := *got.LastLoginAt
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(suite.users.Update(user))
+	got, err = suite.users.GetByID(user.ID)
+	require.NoError(err)
+	require.True(firstLogin.Equal(*got.LastLoginAt), "updates keep the last login")
+
+	require.NoError(suite.users.RecordLogin(user))
+	got, err = suite.users.GetByID(user.ID)
+	require.NoError(err)
+	require.True(got.LastLoginAt.After(firstLogin))
+
+	exp := suite.createExperiment(trickyText)
+	suite.createPairs(exp, 1)
+	all, err := suite.assignments.Initialize(user.ID, exp.ID)
+	require.NoError(err)
+	as := all[0]
+
+	gotAs, err := suite.assignments.GetByID(as.ID)
+	require.NoError(err)
+	require.NotNil(gotAs.CreatedAt)
+	require.Nil(gotAs.AnsweredAt)
+
+	as.Answer = sql.NullString{String: "yes", Valid: true}
+	require.NoError(suite.assignments.Update(as, model.ClientInfo{}))
+	gotAs, err = suite.assignments.GetByID(as.ID)
+	require.NoError(err)
+	require.NotNil(gotAs.AnsweredAt)
+	require.True(gotAs.AnsweredAt.After(before))
+	answeredAt, updatedAt := *gotAs.AnsweredAt, *gotAs.UpdatedAt
+
+	// changing the answer keeps the time of the first one
+	time.Sleep(10 * time.Millisecond)
+	as.Answer = sql.NullString{String: "no", Valid: true}
+	require.NoError(suite.assignments.Update(as, model.ClientInfo{}))
+	gotAs, err = suite.assignments.GetByID(as.ID)
+	require.NoError(err)
+	require.True(answeredAt.Equal(*gotAs.AnsweredAt), "%v != %v", answeredAt, *gotAs.AnsweredAt)
+	require.True(gotAs.UpdatedAt.After(updatedAt))
+	require.True(answeredAt.Equal(*as.AnsweredAt))
+}
+
+func (suite *RepositorySuite) TestQueueOrders() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	user := suite.createUser("worker", model.Worker)
+	exp := suite.createExperiment(trickyText)
+	// the pairs get increasing scores
+	pairs := suite.createPairs(exp, 5)
+
+	_, err := repo.Initialize(user.ID, exp.ID)
+	require.NoError(err)
+
+	answered, total, err := repo.Progress(user.ID, exp.ID)
+	require.NoError(err)
+	require.Equal(0, answered)
+	require.Equal(5, total)
+
+	// the random order is the same in SQL and in Go
+	byKey := append([]int{}, pairs...)
+	sort.Slice(byKey, func(i, j int) bool {
+		return randomOrderKey(user.ID, byKey[i]) < randomOrderKey(user.ID, byKey[j])
+	})
+
+	expected := map[QueueOrder]int{
+		ImportOrder:    pairs[0],
+		ScoreAscOrder:  pairs[0],
+		ScoreDescOrder: pairs[4],
+		RandomOrder:    byKey[0],
+	}
+
+	for order, pairID := range expected {
+		as, err := repo.GetNextUnanswered(user.ID, exp.ID, order)
+		require.NoError(err, string(order))
+		require.Equal(pairID, as.PairID, string(order))
+	}
+
+	_, err = repo.GetNextUnanswered(user.ID, exp.ID, QueueOrder("best"))
+	require.Equal(ErrWrongQueueOrder, err)
+
+	// the order is kept while the pairs are answered
+	for i, pairID := range byKey {
+		as, err := repo.GetNextUnanswered(user.ID, exp.ID, RandomOrder)
+		require.NoError(err)
+		require.Equal(pairID, as.PairID)
+
+		as.Answer = sql.NullString{String: "yes", Valid: true}
+		require.NoError(repo.Update(as, model.ClientInfo{}))
+
+		answered, total, err := repo.Progress(user.ID, exp.ID)
+		require.NoError(err)
+		require.Equal(i+1, answered)
+		require.Equal(5, total)
+	}
+
+	as, err := repo.GetNextUnanswered(user.ID, exp.ID, RandomOrder)
+	require.NoError(err)
+	require.Nil(as)
+}
+
+func (suite *RepositorySuite) TestAssignNext() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	user := suite.createUser("worker", model.Worker)
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 1
+	pairs := suite.createPairs(exp, 2)
+
+	available, err := repo.Available(user.ID, exp)
+	require.NoError(err)
+	require.Equal(2, available)
+
+	for i, pairID := range pairs {
+		as, available, err := repo.AssignNext(user.ID, exp)
+		require.NoError(err)
+		require.Equal(pairID, as.PairID)
+		require.Equal(len(pairs)-i-1, available)
+	}
+
+	as, available, err := repo.AssignNext(user.ID, exp)
+	require.NoError(err)
+	require.Nil(as)
+	require.Equal(0, available)
+}
+
+func (suite *RepositorySuite) TestAssignNextWorkersPerPair() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 2
+	pairs := suite.createPairs(exp, 3)
+
+	workers := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		user := suite.createUser(fmt.Sprintf("worker %d", i), model.Worker)
+		for {
+			as, _, err := repo.AssignNext(user.ID, exp)
+			require.NoError(err)
+			if as == nil {
+				break
+			}
+
+			workers[as.PairID]++
+		}
+	}
+
+	require.Len(workers, len(pairs))
+	for _, pairID := range pairs {
+		require.Equal(exp.WorkersPerPair, workers[pairID], "pair %d", pairID)
+	}
+}
+
+func (suite *RepositorySuite) TestAssignNextGold() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 1
+	pairs := suite.createPairs(exp, 3)
+	require.NoError(suite.gold.Set(
+		&model.GoldAnswer{PairID: pairs[2], ExperimentID: exp.ID, Answer: "yes"}))
+
+	// every worker answers the gold pairs, whatever workersPerPair is
+	for i := 0; i < 3; i++ {
+		user := suite.createUser(fmt.Sprintf("worker %d", i), model.Worker)
+		assigned := make(map[int]bool)
+		for {
+			as, _, err := repo.AssignNext(user.ID, exp)
+			require.NoError(err)
+			if as == nil {
+				break
+			}
+
+			assigned[as.PairID] = true
+		}
+
+		require.True(assigned[pairs[2]], "worker %d", i)
+	}
+}
+
+func (suite *RepositorySuite) TestAssignNextShared() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	first := suite.createUser("first", model.Worker)
+	second := suite.createUser("second", model.Worker)
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 1
+	exp.SharedPairsRatio = 0.5
+	pairs := suite.createPairs(exp, 20)
+
+	var shared []int
+	for _, pairID := range pairs {
+		if isSharedPair(exp, pairID) {
+			shared = append(shared, pairID)
+		}
+	}
+	require.NotEmpty(shared)
+	require.True(len(shared) < len(pairs))
+
+	for range pairs {
+		as, _, err := repo.AssignNext(first.ID, exp)
+		require.NoError(err)
+		require.NotNil(as)
+	}
+
+	// only the shared pairs are left for the second worker, the first one
+	// is assigned first
+	available, err := repo.Available(second.ID, exp)
+	require.NoError(err)
+	suite.Equal(len(shared), available)
+
+	as, available, err := repo.AssignNext(second.ID, exp)
+	require.NoError(err)
+	require.NotNil(as)
+	suite.Equal(shared[0], as.PairID)
+	suite.Equal(len(shared)-1, available)
+}
+
+// concurrentAssignErrors matches the errors expected from concurrent calls to
+// AssignNext: the DB lock not granted, and the chosen pairs filled by others
+var concurrentAssignErrors = regexp.MustCompile(
+	`database is locked|database table is locked|could not serialize access|deadlock detected|Failed to assign a file pair after`)
+
+func (suite *RepositorySuite) TestAssignNextConcurrent() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 1
+	pairs := suite.createPairs(exp, 2)
+
+	var users []*model.User
+	for i := 0; i < 6; i++ {
+		users = append(users, suite.createUser(fmt.Sprintf("worker %d", i), model.Worker))
+	}
+
+	// the concurrent transactions may fail to get the DB lock, then the
+	// workers try again
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		assigned int
+		failures []error
+	)
+	for _, user := range users {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			for attempt := 0; attempt < 10; attempt++ {
+				as, _, err := repo.AssignNext(userID, exp)
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, err)
+				} else if as != nil {
+					assigned++
+				}
+				mu.Unlock()
+
+				if err == nil {
+					return
+				}
+			}
+		}(user.ID)
+	}
+	wg.Wait()
+
+	for _, err := range failures {
+		suite.Regexp(concurrentAssignErrors, err.Error())
+	}
+
+	// every pair gets its worker
+	suite.Equal(exp.WorkersPerPair*len(pairs), assigned)
+
+	for _, pairID := range pairs {
+		var workers int
+		for _, user := range users {
+			as, err := repo.GetAll(user.ID, exp.ID)
+			if err == ErrNoAssignmentsInitialized {
+				continue
+			}
+
+			require.NoError(err)
+			for _, a := range as {
+				if a.PairID == pairID {
+					workers++
+				}
+			}
+		}
+
+		require.True(workers <= exp.WorkersPerPair, "pair %d has %d workers", pairID, workers)
+	}
+}
+
+func (suite *RepositorySuite) TestAssignNextDeactivated() {
+	require := suite.Require()
+	repo := suite.assignments
+
+	user := suite.createUser("worker", model.Worker)
+	gone := suite.createUser("gone", model.Worker)
+	exp := suite.createExperiment(trickyText)
+	exp.WorkersPerPair = 1
+	pairs := suite.createPairs(exp, 2)
+
+	answered, _, err := repo.AssignNext(gone.ID, exp)
+	require.NoError(err)
+	_, _, err = repo.AssignNext(gone.ID, exp)
+	require.NoError(err)
+
+	answered.Answer = sql.NullString{String: "yes", Valid: true}
+	require.NoError(repo.Update(answered, model.ClientInfo{}))
+
+	available, err := repo.Available(user.ID, exp)
+	require.NoError(err)
+	require.Equal(0, available)
+
+	// the unanswered assignments of deactivated users are given to others
+	now := time.Now().UTC()
+	gone.DeactivatedAt = &now
+	require.NoError(suite.users.Update(gone))
+
+	available, err = repo.Available(user.ID, exp)
+	require.NoError(err)
+	require.Equal(1, available)
+
+	as, _, err := repo.AssignNext(user.ID, exp)
+	require.NoError(err)
+	require.Equal(pairs[1], as.PairID)
+}
+
+func (suite *RepositorySuite) TestGoldAnswers() {
+	require := suite.Require()
+	repo := suite.gold
+	assignments := suite.assignments
+
+	user := suite.createUser(trickyText, model.Worker)
+	exp := suite.createExperiment(trickyText)
+	pairs := suite.createPairs(exp, 2)
+
+	require.NoError(repo.Set(
+		&model.GoldAnswer{PairID: pairs[0], ExperimentID: exp.ID, Answer: trickyText},
+		&model.GoldAnswer{PairID: pairs[1], ExperimentID: exp.ID, Answer: "yes"}))
+
+	gold, err := repo.GetByExperiment(exp.ID)
+	require.NoError(err)
+	require.Len(gold, 2)
+	require.Equal(trickyText, gold[0].Answer)
+
+	all, err := assignments.Initialize(user.ID, exp.ID)
+	require.NoError(err)
+	for _, as := range all {
+		as.Answer = sql.NullString{String: trickyText, Valid: true}
+		require.NoError(assignments.Update(as, model.ClientInfo{}))
+	}
+
+	accuracy, err := repo.Accuracy(exp.ID)
+	require.NoError(err)
+	require.Len(accuracy, 1)
+	require.Equal(trickyText, accuracy[0].Login)
+	require.Equal(2, accuracy[0].Answered)
+	require.Equal(1, accuracy[0].Correct)
+
+	require.NoError(repo.Set(&model.GoldAnswer{PairID: pairs[1], ExperimentID: exp.ID}))
+
+	w, err := repo.UserAccuracy(user.ID, exp.ID)
+	require.NoError(err)
+	require.Equal(1, w.Answered)
+	require.Equal(1, w.Correct)
+}
+
+func (suite *RepositorySuite) TestGoldAccuracy() {
+	require := suite.Require()
+	repo := suite.gold
+
+	exp := suite.createExperiment(trickyText)
+	pairs := suite.createPairs(exp, 4)
+	other := suite.createExperiment("other")
+	otherPairs := suite.createPairs(other, 1)
+
+	require.NoError(repo.Set(
+		&model.GoldAnswer{PairID: pairs[0], ExperimentID: exp.ID, Answer: "yes"},
+		&model.GoldAnswer{PairID: pairs[1], ExperimentID: exp.ID, Answer: "no"},
+		&model.GoldAnswer{PairID: pairs[2], ExperimentID: exp.ID, Answer: "maybe"},
+		&model.GoldAnswer{PairID: otherPairs[0], ExperimentID: other.ID, Answer: "yes"}))
+
+	// answers of each user by pair; the last pair has no gold answer
+	answers := []struct {
+		login   string
+		answers []string
+	}{
+		{"good", []string{"yes", "no", "maybe", "no"}},
+		{"bad", []string{"no", "no", "no", "no"}},
+		{"skipper", []string{model.SkipAnswer, "no", "", "yes"}},
+		{"idle", []string{"", "", "", "yes"}},
+	}
+
+	users := make(map[string]*model.User)
+	for _, u := range answers {
+		user := suite.createUser(u.login, model.Worker)
+		users[u.login] = user
+
+		all, err := suite.assignments.Initialize(user.ID, exp.ID)
+		require.NoError(err)
+		for i, as := range all {
+			if u.answers[i] == "" {
+				continue
+			}
+
+			as.Answer = sql.NullString{String: u.answers[i], Valid: true}
+			require.NoError(suite.assignments.Update(as, model.ClientInfo{}))
+		}
+
+		all, err = suite.assignments.Initialize(user.ID, other.ID)
+		require.NoError(err)
+		all[0].Answer = sql.NullString{String: "yes", Valid: true}
+		require.NoError(suite.assignments.Update(all[0], model.ClientInfo{}))
+	}
+
+	accuracy, err := repo.Accuracy(exp.ID)
+	require.NoError(err)
+
+	type score struct{ answered, correct int }
+	got := make(map[string]score)
+	var ids []int
+	for _, w := range accuracy {
+		got[w.Login] = score{w.Answered, w.Correct}
+		ids = append(ids, w.UserID)
+	}
+
+	// the skipped answers, unanswered and non-gold pairs are not counted
+	require.Equal(map[string]score{
+		"good":    {3, 3},
+		"bad":     {3, 1},
+		"skipper": {1, 1},
+	}, got)
+	require.True(sort.IntsAreSorted(ids))
+
+	for login, expected := range got {
+		w, err := repo.UserAccuracy(users[login].ID, exp.ID)
+		require.NoError(err)
+		require.Equal(users[login].ID, w.UserID)
+		require.Equal(expected, score{w.Answered, w.Correct}, login)
+	}
+
+	w, err := repo.UserAccuracy(users["idle"].ID, exp.ID)
+	require.NoError(err)
+	require.Equal(0, w.Answered)
+	require.Equal(0, w.Correct)
+	require.Equal(float64(0), w.Accuracy())
+}
+
+func (suite *RepositorySuite) TestFilePairsAndFeatures() {
+	require := suite.Require()
+
+	exp := suite.createExperiment(trickyText)
+	pairs := suite.createPairs(exp, 1)
+
+	pair, err := suite.pairs.GetByID(pairs[0])
+	require.NoError(err)
+	require.Equal(trickyText, pair.Left.Content)
+	require.Equal(trickyText, pair.Diff)
+
+	require.NoError(suite.addFeature(&model.Feature{BlobID: pair.Left.BlobID, Name: trickyText, Weight: 0.5}))
+
+	features, err := suite.features.GetByBlobID(pair.Left.BlobID)
+	require.NoError(err)
+	require.Len(features, 1)
+	require.Equal(trickyText, features[0].Name)
+}
+
+func (suite *RepositorySuite) TestSessions() {
+	require := suite.Require()
+
+	user := suite.createUser(trickyText, model.Worker)
+	now := time.Now().UTC()
+	session := &model.Session{ID: trickyText, UserID: user.ID,
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(suite.sessions.Create(session))
+
+	got, err := suite.sessions.Get(trickyText)
+	require.NoError(err)
+	require.Equal(user.ID, got.UserID)
+	require.True(got.Active(now))
+	require.False(got.Active(now.Add(2 * time.Hour)))
+
+	require.NoError(suite.sessions.Revoke(trickyText))
+	got, err = suite.sessions.Get(trickyText)
+	require.NoError(err)
+	require.NotNil(got.RevokedAt)
+	require.False(got.Active(now))
+
+	require.Equal(ErrSessionRevoked, suite.sessions.Revoke(trickyText))
+	require.Equal(ErrSessionRevoked, suite.sessions.Revoke(trickyText+"'"))
+
+	got, err = suite.sessions.Get(trickyText + "'")
+	require.NoError(err)
+	require.Nil(got)
+
+	for _, id := range []string{"a", "b"} {
+		require.NoError(suite.sessions.Create(&model.Session{ID: id, UserID: user.ID,
+			CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	}
+
+	require.NoError(suite.sessions.RevokeUser(user.ID))
+	for _, id := range []string{"a", "b"} {
+		got, err = suite.sessions.Get(id)
+		require.NoError(err)
+		require.False(got.Active(now))
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	suite.Run(t, &RepositorySuite{open: func() (stores, func()) {
+		dir, err := ioutil.TempDir("", "repository")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := dbutil.OpenSQLite(filepath.Join(dir, "test.db"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := sqlStores(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, func() {
+			db.Close()
+			os.RemoveAll(dir)
+		}
+	}})
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	suite.Run(t, &RepositorySuite{open: func() (stores, func()) {
+		db, err := dbutil.Open(dsn, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := sqlStores(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, func() {
+			if _, err := dbutil.MigrateDown(db, dbutil.LatestSchemaVersion()); err != nil {
+				t.Error(err)
+			}
+
+			db.Close()
+		}
+	}})
+}
+
+func TestMemory(t *testing.T) {
+	suite.Run(t, &RepositorySuite{open: func() (stores, func()) {
+		s, err := memoryStores()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, func() {}
+	}})
+}
+
+// TestStandin runs the repositories against the PostgreSQL stand-in, that
+// checks how the statements pass their values
+func TestStandin(t *testing.T) {
+	standin.reset()
+
+	db, err := sql.Open("postgres-standin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	assignment := []driver.Value{int64(1), int64(1), int64(1), int64(1),
+		trickyText, int64(0), trickyText, "binary", now, now, nil}
+	standin.returns(selectIDFilePairsSQL, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	standin.returns(selectNextCandidateSQL, []driver.Value{int64(1), false})
+	standin.returns(countCandidatePairsSQL, []driver.Value{int64(1)})
+	standin.returns(selectAssignmentsWhereIDSQL, assignment)
+
+	exp := &model.Experiment{ID: 1, Name: trickyText, Description: trickyText,
+		WorkersPerPair: 1, AnswerSchema: model.DefaultAnswerSchema}
+	user := &model.User{ID: 1, Login: trickyText, Username: trickyText, AvatarURL: trickyText}
+
+	users := NewUsers(db)
+	users.Create(user)
+	users.Get(trickyText)
+	users.GetByID(1)
+	users.Update(user)
+	users.RecordLogin(user)
+	users.UpdatePassword(user)
+	users.List(UsersFilter{Query: trickyText, Role: model.Worker}, 10, 0)
+
+	experiments := NewExperiments(db)
+	experiments.Create(exp)
+	experiments.GetByID(1)
+	experiments.GetByName(trickyText)
+	experiments.List(10, 0)
+	experiments.Update(exp)
+	experiments.Delete(1)
+
+	assignments := NewAssignments(db)
+	assignments.Initialize(1, 1)
+	assignments.GetByID(1)
+	assignments.GetAll(1, 1)
+	assignments.GetAnswered(1)
+	assignments.GetFlagged(1)
+	assignments.Progress(1, 1)
+	for order := range queueOrderBy {
+		assignments.GetNextUnanswered(1, 1, order)
+	}
+	assignments.GetNextUnanswered(1, 1, RandomOrder)
+	assignments.Available(1, exp)
+	standin.returns(selectPairWorkersSQL, []driver.Value{int64(0)})
+	assignments.AssignNext(1, exp)
+	assignments.Update(&model.Assignment{ID: 1,
+		Answer:  sql.NullString{String: trickyText, Valid: true},
+		Comment: trickyText, Flags: []model.Flag{model.Flag(trickyText)}},
+		model.ClientInfo{IP: trickyText, UserAgent: trickyText})
+
+	NewAssignmentEvents(db).List(EventsFilter{ExperimentID: 1, PairID: 1}, 10, 0)
+
+	gold := NewGoldAnswers(db)
+	gold.Set(&model.GoldAnswer{PairID: 1, ExperimentID: 1, Answer: trickyText},
+		&model.GoldAnswer{PairID: 2, ExperimentID: 1})
+	gold.GetByExperiment(1)
+	gold.Accuracy(1)
+	gold.UserAccuracy(1, 1)
+
+	sessions := NewSessions(db)
+	sessions.Create(&model.Session{ID: trickyText, UserID: 1, CreatedAt: now, ExpiresAt: now})
+	sessions.Get(trickyText)
+	sessions.Revoke(trickyText)
+	sessions.RevokeUser(1)
+
+	NewFilePairs(db).GetByID(1)
+	NewFeatures(db).GetByBlobID(trickyText)
+
+	for _, problem := range standin.Problems() {
+		t.Error(problem)
+	}
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// Sessions repository
+type Sessions struct {
+	db *sql.DB
+}
+
+// NewSessions returns a new Sessions repository
+func NewSessions(db *sql.DB) *Sessions {
+	return &Sessions{db: db}
+}
+
+const (
+	insertSessionsSQL     = `INSERT INTO sessions (id, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+	selectSessionsSQL     = `SELECT * FROM sessions WHERE id=$1`
+	revokeSessionsSQL     = `UPDATE sessions SET revoked_at=$1 WHERE id=$2 AND revoked_at IS NULL`
+	revokeUserSessionsSQL = `UPDATE sessions SET revoked_at=$1 WHERE user_id=$2 AND revoked_at IS NULL`
+)
+
+// Create stores a Session into the DB
+func (repo *Sessions) Create(s *model.Session) error {
+	if _, err := repo.db.Exec(insertSessionsSQL, s.ID, s.UserID, s.CreatedAt, s.ExpiresAt); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
+
+// Get returns the Session with the given ID. If the Session does not exist,
+// it returns nil, nil
+func (repo *Sessions) Get(id string) (*model.Session, error) {
+	var s model.Session
+	err := repo.db.QueryRow(selectSessionsSQL, id).
+		Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &s.RevokedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Error getting session from the DB: %v", err)
+	default:
+		return &s, nil
+	}
+}
+
+// ErrSessionRevoked is returned when revoking a Session that does not exist
+// or is already closed
+var ErrSessionRevoked = fmt.Errorf("session already revoked")
+
+// Revoke closes the Session with the given ID. If it was already closed, it
+// returns ErrSessionRevoked, so only one of several concurrent calls succeeds
+func (repo *Sessions) Revoke(id string) error {
+	res, err := repo.db.Exec(revokeSessionsSQL, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	if n == 0 {
+		return ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// RevokeUser closes all the open Sessions of the given user
+func (repo *Sessions) RevokeUser(userID int) error {
+	if _, err := repo.db.Exec(revokeUserSessionsSQL, time.Now().UTC(), userID); err != nil {
+		return fmt.Errorf("DB error: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// standinDriver is a database/sql driver that stands in for PostgreSQL when
+// there is no server to test against. It does not store anything: every query
+// returns no rows, unless they are set with returns. It checks that the statements bind their values as both
+// lib/pq and go-sqlite3 expect, and records the problems found:
+//   - the placeholders are $1..$n, with no gaps, as PostgreSQL needs to infer
+//     the type of every parameter
+//   - they first appear in ascending order, because SQLite numbers the $NNN
+//     parameters in order of appearance
+//   - there are no ? placeholders, that PostgreSQL does not understand
+//   - the statement gets exactly n arguments
+//   - no string argument is part of the statement text, so the values are
+//     not quoted into the SQL
+type standinDriver struct {
+	mu       sync.Mutex
+	problems []string
+	results  map[string][][]driver.Value
+}
+
+var standin = &standinDriver{}
+
+func init() {
+	sql.Register("postgres-standin", standin)
+}
+
+// reset clears the problems found so far and the rows set with returns
+func (d *standinDriver) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.problems = nil
+	d.results = make(map[string][][]driver.Value)
+}
+
+// returns sets the rows returned by the given query
+func (d *standinDriver) returns(query string, rows ...[]driver.Value) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.results[query] = rows
+}
+
+// Problems returns the problems found since the last reset
+func (d *standinDriver) Problems() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string{}, d.problems...)
+}
+
+func (d *standinDriver) report(query string, format string, args ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.problems = append(d.problems,
+		fmt.Sprintf("%s in %q", fmt.Sprintf(format, args...), query))
+}
+
+func (d *standinDriver) Open(name string) (driver.Conn, error) {
+	return &standinConn{d}, nil
+}
+
+type standinConn struct {
+	d *standinDriver
+}
+
+var (
+	quotedText  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	placeholder = regexp.MustCompile(`\$(\d+)`)
+)
+
+func (c *standinConn) Prepare(query string) (driver.Stmt, error) {
+	// placeholders inside string literals are not placeholders
+	text := quotedText.ReplaceAllString(query, "''")
+
+	if strings.Contains(text, "?") {
+		c.d.report(query, "? placeholder")
+	}
+
+	n := 0
+	for _, m := range placeholder.FindAllStringSubmatch(text, -1) {
+		i, _ := strconv.Atoi(m[1])
+		switch {
+		case i == n+1:
+			n = i
+		case i > n+1:
+			c.d.report(query, "$%d after $%d", i, n)
+			n = i
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(text, -1) {
+		seen[m[1]] = true
+	}
+
+	if len(seen) != n {
+		c.d.report(query, "%d placeholders up to $%d", len(seen), n)
+	}
+
+	return &standinStmt{c.d, query, n}, nil
+}
+
+func (c *standinConn) Close() error {
+	return nil
+}
+
+func (c *standinConn) Begin() (driver.Tx, error) {
+	return standinTx{}, nil
+}
+
+type standinTx struct{}
+
+func (standinTx) Commit() error   { return nil }
+func (standinTx) Rollback() error { return nil }
+
+type standinStmt struct {
+	d     *standinDriver
+	query string
+	n     int
+}
+
+func (s *standinStmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1 so the arguments are checked by the stand-in
+func (s *standinStmt) NumInput() int {
+	return -1
+}
+
+func (s *standinStmt) check(args []driver.Value) {
+	if len(args) != s.n {
+		s.d.report(s.query, "%d arguments for %d placeholders", len(args), s.n)
+	}
+
+	for _, arg := range args {
+		if str, ok := arg.(string); ok && len(str) > 3 && strings.Contains(s.query, str) {
+			s.d.report(s.query, "argument %q quoted into the statement", str)
+		}
+	}
+}
+
+func (s *standinStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.check(args)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *standinStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.check(args)
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	return &standinRows{values: s.d.results[s.query]}, nil
+}
+
+type standinRows struct {
+	values [][]driver.Value
+}
+
+func (r *standinRows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+
+	columns := make([]string, len(r.values[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i)
+	}
+
+	return columns
+}
+
+func (r *standinRows) Close() error {
+	return nil
+}
+
+func (r *standinRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
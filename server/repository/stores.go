@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// The handlers depend on these interfaces instead of the SQL repositories, so
+// they can also run with the in-memory ones returned by Memory
+
+// UserStore stores the Users
+type UserStore interface {
+	Create(user *model.User) error
+	Get(login string) (*model.User, error)
+	GetByID(id int) (*model.User, error)
+	Update(user *model.User) error
+	RecordLogin(user *model.User) error
+	List(filter UsersFilter, limit, offset int) ([]*model.User, error)
+}
+
+// ExperimentStore stores the Experiments
+type ExperimentStore interface {
+	GetByID(id int) (*model.Experiment, error)
+	GetByName(name string) (*model.Experiment, error)
+	List(limit, offset int) ([]*model.Experiment, error)
+	Create(exp *model.Experiment) error
+	Update(exp *model.Experiment) error
+	Delete(id int) error
+}
+
+// AssignmentStore stores the Assignments
+type AssignmentStore interface {
+	Initialize(userID int, experimentID int) ([]*model.Assignment, error)
+	GetByID(id int) (*model.Assignment, error)
+	GetAll(userID, experimentID int) ([]*model.Assignment, error)
+	GetAnswered(experimentID int) ([]*model.Assignment, error)
+	Progress(userID, experimentID int) (answered, total int, err error)
+	GetNextUnanswered(userID, experimentID int, order QueueOrder) (*model.Assignment, error)
+	Available(userID int, exp *model.Experiment) (int, error)
+	AssignNext(userID int, exp *model.Experiment) (*model.Assignment, int, error)
+	Update(as *model.Assignment, client model.ClientInfo) error
+	GetFlagged(experimentID int) ([]*model.Assignment, error)
+}
+
+// AssignmentEventStore reads the AssignmentEvents, written by the
+// AssignmentStore
+type AssignmentEventStore interface {
+	List(filter EventsFilter, limit, offset int) ([]*model.AssignmentEvent, error)
+}
+
+// FilePairStore reads the FilePairs
+type FilePairStore interface {
+	GetByID(id int) (*model.FilePair, error)
+}
+
+// FeatureStore reads the Features
+type FeatureStore interface {
+	GetByBlobID(blobID string) ([]*model.Feature, error)
+}
+
+// GoldAnswerStore stores the GoldAnswers
+type GoldAnswerStore interface {
+	GetByExperiment(experimentID int) ([]*model.GoldAnswer, error)
+	Set(answers ...*model.GoldAnswer) error
+	Accuracy(experimentID int) ([]*model.WorkerAccuracy, error)
+	UserAccuracy(userID, experimentID int) (*model.WorkerAccuracy, error)
+}
+
+// SessionStore stores the Sessions of the issued tokens
+type SessionStore interface {
+	Create(s *model.Session) error
+	Get(id string) (*model.Session, error)
+	Revoke(id string) error
+	RevokeUser(userID int) error
+}
+
+// Stores are the stores used by the server
+type Stores struct {
+	Users            UserStore
+	Experiments      ExperimentStore
+	Assignments      AssignmentStore
+	AssignmentEvents AssignmentEventStore
+	FilePairs        FilePairStore
+	Features         FeatureStore
+	GoldAnswers      GoldAnswerStore
+	Sessions         SessionStore
+}
+
+// NewStores returns the Stores of the SQL repositories of the given DB
+func NewStores(db *sql.DB) *Stores {
+	return &Stores{
+		Users:            NewUsers(db),
+		Experiments:      NewExperiments(db),
+		Assignments:      NewAssignments(db),
+		AssignmentEvents: NewAssignmentEvents(db),
+		FilePairs:        NewFilePairs(db),
+		Features:         NewFeatures(db),
+		GoldAnswers:      NewGoldAnswers(db),
+		Sessions:         NewSessions(db),
+	}
+}
+
+var (
+	_ UserStore            = (*Users)(nil)
+	_ ExperimentStore      = (*Experiments)(nil)
+	_ AssignmentStore      = (*Assignments)(nil)
+	_ AssignmentEventStore = (*AssignmentEvents)(nil)
+	_ FilePairStore        = (*FilePairs)(nil)
+	_ FeatureStore         = (*Features)(nil)
+	_ GoldAnswerStore      = (*GoldAnswers)(nil)
+	_ SessionStore         = (*Sessions)(nil)
+)
@@ -3,6 +3,8 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -18,17 +20,38 @@ func NewUsers(db *sql.DB) *Users {
 }
 
 const (
-	insertUsersSQL           = `INSERT INTO users (login, username, avatar_url, role) VALUES ($1, $2, $3, $4)`
+	insertUsersSQL           = `INSERT INTO users (login, username, avatar_url, role, created_at, updated_at, provider, password_hash) VALUES ($1, $2, $3, $4, $5, $5, $6, $7)`
 	selectUsersWhereLoginSQL = `SELECT * FROM users WHERE login=$1`
 	selectUsersWhereIDSQL    = `SELECT * FROM users WHERE id=$1`
+	updateUsersSQL           = `UPDATE users SET username=$1, avatar_url=$2, role=$3, deactivated_at=$4, updated_at=$5 WHERE id=$6`
+	updateUsersLastLoginSQL  = `UPDATE users SET last_login_at=$1 WHERE id=$2`
+	updateUsersPasswordSQL   = `UPDATE users SET password_hash=$1, updated_at=$2 WHERE id=$3`
+	// an empty query or role match all the users, and a 0 state matches both
+	// active (1) and deactivated (2) users
+	selectUsersPageSQL = `SELECT * FROM users
+		WHERE ($1='' OR LOWER(login) LIKE $2 ESCAPE '\' OR LOWER(username) LIKE $2 ESCAPE '\')
+		AND ($3='' OR role=$3)
+		AND ($4=0 OR ($4=1 AND deactivated_at IS NULL) OR ($4=2 AND deactivated_at IS NOT NULL))
+		ORDER BY id LIMIT $5 OFFSET $6`
 )
 
+// UsersFilter selects Users; empty fields match all of them
+type UsersFilter struct {
+	// Query matches part of the login or username, ignoring the case
+	Query string
+	Role  model.Role
+	// Deactivated selects the deactivated Users if true, or the active ones
+	// if false
+	Deactivated *bool
+}
+
 // Create stores a User into the DB. If the User is created, the argument
 // is updated to point to that new User
 func (repo *Users) Create(user *model.User) error {
 
 	_, err := repo.db.Exec(insertUsersSQL,
-		user.Login, user.Username, user.AvatarURL, user.Role)
+		user.Login, user.Username, user.AvatarURL, user.Role, time.Now().UTC(),
+		user.Provider, user.PasswordHash)
 
 	if err != nil {
 		return err
@@ -42,12 +65,24 @@ func (repo *Users) Create(user *model.User) error {
 	return err
 }
 
+// scanUser builds a User from the given scanner
+func scanUser(row scanner) (*model.User, error) {
+	var user model.User
+
+	err := row.Scan(&user.ID, &user.Login, &user.Username, &user.AvatarURL, &user.Role,
+		&user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt, &user.Provider, &user.PasswordHash,
+		&user.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 // getWithQuery builds a User from the given sql QueryRow. If the User does not
 // exist, it returns nil, nil
 func (repo *Users) getWithQuery(queryRow *sql.Row) (*model.User, error) {
-	var user model.User
-
-	err := queryRow.Scan(&user.ID, &user.Login, &user.Username, &user.AvatarURL, &user.Role)
+	user, err := scanUser(queryRow)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -55,11 +90,11 @@ func (repo *Users) getWithQuery(queryRow *sql.Row) (*model.User, error) {
 	case err != nil:
 		return nil, fmt.Errorf("Error getting user from the DB: %v", err)
 	default:
-		return &user, nil
+		return user, nil
 	}
 }
 
-// Get returns the User with the given GitHub login name. If the User does not
+// Get returns the User with the given login name. If the User does not
 // exist, it returns nil, nil
 func (repo *Users) Get(login string) (*model.User, error) {
 	return repo.getWithQuery(repo.db.QueryRow(selectUsersWhereLoginSQL, login))
@@ -70,3 +105,83 @@ func (repo *Users) Get(login string) (*model.User, error) {
 func (repo *Users) GetByID(id int) (*model.User, error) {
 	return repo.getWithQuery(repo.db.QueryRow(selectUsersWhereIDSQL, id))
 }
+
+// List returns at most limit Users matching the filter, sorted by ID and
+// skipping the first offset ones
+func (repo *Users) List(filter UsersFilter, limit, offset int) ([]*model.User, error) {
+	query := ""
+	if filter.Query != "" {
+		query = "%" + likeEscaper.Replace(strings.ToLower(filter.Query)) + "%"
+	}
+
+	state := 0
+	if filter.Deactivated != nil {
+		state = 1
+		if *filter.Deactivated {
+			state = 2
+		}
+	}
+
+	rows, err := repo.db.Query(selectUsersPageSQL,
+		filter.Query, query, filter.Role, state, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting users from the DB: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.User, 0)
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting users from the DB: %v", err)
+		}
+
+		results = append(results, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DB error: %v", err)
+	}
+
+	return results, nil
+}
+
+// likeEscaper escapes the wildcards of the LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Update stores the username, avatar URL, role and deactivation time of the
+// given User, identified by its ID
+func (repo *Users) Update(user *model.User) error {
+	now := time.Now().UTC()
+	_, err := repo.db.Exec(updateUsersSQL,
+		user.Username, user.AvatarURL, user.Role, user.DeactivatedAt, now, user.ID)
+	if err == nil {
+		user.UpdatedAt = &now
+	}
+
+	return err
+}
+
+// RecordLogin stores the current time as the last login of the given User
+func (repo *Users) RecordLogin(user *model.User) error {
+	now := time.Now().UTC()
+	_, err := repo.db.Exec(updateUsersLastLoginSQL, now, user.ID)
+	if err == nil {
+		user.LastLoginAt = &now
+	}
+
+	return err
+}
+
+// UpdatePassword stores the password hash of the given User, identified by
+// its ID
+func (repo *Users) UpdatePassword(user *model.User) error {
+	now := time.Now().UTC()
+	_, err := repo.db.Exec(updateUsersPasswordSQL, user.PasswordHash, now, user.ID)
+	if err == nil {
+		user.UpdatedAt = &now
+	}
+
+	return err
+}
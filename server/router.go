@@ -1,10 +1,10 @@
 package server
 
 import (
-	"database/sql"
 	"net/http"
 
 	"github.com/src-d/code-annotation/server/handler"
+	"github.com/src-d/code-annotation/server/model"
 	"github.com/src-d/code-annotation/server/repository"
 	"github.com/src-d/code-annotation/server/service"
 
@@ -14,54 +14,93 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Router returns a Handler to serve the code-anotation backend
+// Router returns a Handler to serve the code-anotation backend with the given
+// stores
 func Router(
 	logger logrus.FieldLogger,
 	jwt *service.JWT,
-	oauth *service.OAuth,
+	provider service.Provider,
+	access *service.Access,
+	proxies *service.TrustedProxies,
 	uiDomain string,
-	db *sql.DB,
+	stores *repository.Stores,
 	staticsPath string,
 ) http.Handler {
 
-	// create repos
-	userRepo := repository.NewUsers(db)
-	experimentRepo := repository.NewExperiments(db)
-	assignmentRepo := repository.NewAssignments(db)
-	filePairRepo := repository.NewFilePairs(db)
+	// repos
+	userRepo := stores.Users
+	experimentRepo := stores.Experiments
+	assignmentRepo := stores.Assignments
+	filePairRepo := stores.FilePairs
+	featureRepo := stores.Features
+	goldRepo := stores.GoldAnswers
+	eventRepo := stores.AssignmentEvents
 
 	// cors options
 	corsOptions := cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"Location", "Authorization", "Content-Type"},
 	}
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.Recoverer)
+	r.Use(proxies.Middleware)
 	r.Use(cors.New(corsOptions).Handler)
 	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: logger}))
 
-	r.Get("/login", handler.Login(oauth))
-	r.Get("/oauth-callback", handler.OAuthCallback(oauth, jwt, userRepo, uiDomain, logger))
+	r.Get("/login", handler.Login(provider))
+	// the local provider posts its form to /login
+	r.Post("/login", handler.OAuthCallback(provider, jwt, access, userRepo, uiDomain, logger))
+	r.Get("/oauth-callback", handler.OAuthCallback(provider, jwt, access, userRepo, uiDomain, logger))
+	// expired tokens can be refreshed, so it is out of the JWT middleware
+	r.Post("/api/refresh", handler.Get(handler.RefreshToken(jwt, userRepo)))
 
 	r.Route("/api", func(r chi.Router) {
 		r.Use(jwt.Middleware)
+		r.Use(handler.RequireActiveUser(userRepo))
+		r.Use(handler.RequireRole(model.Requester, model.Worker))
+
+		requesterOnly := handler.RequireRole(model.Requester)
 
 		r.Get("/me", handler.Get(handler.Me(userRepo)))
+		r.With(requesterOnly).Get("/users", handler.Get(handler.GetUsers(userRepo)))
+		r.With(requesterOnly).Put("/users/{userId}", handler.Get(handler.UpdateUser(userRepo, jwt)))
+		r.Post("/logout", handler.Get(handler.Logout(jwt)))
+
+		r.Get("/experiments", handler.Get(handler.GetExperiments(experimentRepo)))
+		r.With(requesterOnly).Post("/experiments", handler.Get(handler.CreateExperiment(experimentRepo)))
 
 		r.Route("/experiments/{experimentId}", func(r chi.Router) {
 
 			r.Get("/", handler.Get(handler.GetExperimentDetails(experimentRepo)))
 
+			r.Group(func(r chi.Router) {
+				r.Use(requesterOnly)
+
+				r.Put("/", handler.Get(handler.UpdateExperiment(experimentRepo, assignmentRepo, goldRepo)))
+				r.Delete("/", handler.Get(handler.DeleteExperiment(experimentRepo)))
+
+				r.Get("/stats", handler.Get(handler.GetExperimentStats(experimentRepo, assignmentRepo)))
+				r.Get("/accuracy", handler.Get(handler.GetWorkersAccuracy(experimentRepo, goldRepo)))
+				r.Get("/flagged", handler.Get(handler.GetFlaggedPairs(experimentRepo, assignmentRepo)))
+				r.Get("/history", handler.Get(handler.GetAssignmentEvents(experimentRepo, eventRepo)))
+
+				r.Get("/gold", handler.Get(handler.GetGoldAnswers(experimentRepo, goldRepo)))
+				r.Put("/gold", handler.Get(handler.SetGoldAnswers(experimentRepo, filePairRepo, goldRepo)))
+				r.Put("/gold/{pairId}", handler.Get(handler.SetGoldAnswer(experimentRepo, filePairRepo, goldRepo)))
+			})
+
 			r.Route("/assignments", func(r chi.Router) {
 
-				r.Get("/", handler.Get(handler.GetAssignmentsForUserExperiment(assignmentRepo)))
-				r.Put("/{assignmentId}", handler.Get(handler.SaveAssignment(assignmentRepo)))
+				r.Get("/", handler.Get(handler.GetAssignmentsForUserExperiment(assignmentRepo, experimentRepo, goldRepo)))
+				r.Get("/next", handler.Get(handler.GetNextAssignment(assignmentRepo, experimentRepo, goldRepo)))
+				r.Put("/{assignmentId}", handler.Get(handler.SaveAssignment(assignmentRepo, experimentRepo, goldRepo)))
 			})
 
 			r.Get("/file-pairs/{pairId}", handler.Get(handler.GetFilePairDetails(filePairRepo)))
+			r.Get("/file-pairs/{pairId}/features", handler.Get(handler.GetFilePairFeatures(filePairRepo, featureRepo)))
 		})
 	})
 
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/code-annotation/server/dbutil"
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+	"github.com/src-d/code-annotation/server/service"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+const testUIDomain = "http://ui"
+
+const testDump = `[
+[{"blob_id": "a1", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "a\n", "bag": {}},
+ {"blob_id": "b1", "repository_id": "r", "commit_hash": "c", "path": "b.go", "content": "b\n", "bag": {}}, 0.9],
+[{"blob_id": "a1", "repository_id": "r", "commit_hash": "c", "path": "a.go", "content": "a\n", "bag": {}},
+ {"blob_id": "c1", "repository_id": "r", "commit_hash": "c", "path": "c.go", "content": "c\n", "bag": {}}, 0.5]
+]`
+
+// RouterSuite tests the whole server, with a SQLite DB and the dev provider
+type RouterSuite struct {
+	suite.Suite
+
+	dir    string
+	db     dbutil.DB
+	router http.Handler
+}
+
+func (suite *RouterSuite) SetupTest() {
+	require := suite.Require()
+
+	var err error
+	suite.dir, err = ioutil.TempDir("", "code-annotation-router")
+	require.NoError(err)
+
+	suite.db, err = dbutil.OpenSQLite(filepath.Join(suite.dir, "internal.db"), false)
+	require.NoError(err)
+	require.NoError(dbutil.Bootstrap(suite.db))
+	require.NoError(dbutil.Initialize(suite.db))
+
+	stats, err := dbutil.LoadDump(strings.NewReader(testDump), suite.db, dbutil.TargetExperiment{},
+		dbutil.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	require.NoError(err)
+	require.EqualValues(2, stats.FilePairs.Success)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	sqlDB := suite.db.SQLDB()
+	jwt := service.NewJWT(service.JWTConfig{
+		SigningKey:    "testing",
+		Issuer:        "code-annotation",
+		Audience:      "code-annotation",
+		Expiry:        time.Hour,
+		RefreshExpiry: time.Hour,
+	}, repository.NewSessions(sqlDB))
+
+	provider, err := service.NewProvider(service.ProviderConfig{
+		Provider:   service.DevProvider,
+		DevEnabled: true,
+		DevUsers:   []string{"alice:requester", "bob:worker"},
+	}, service.OAuthConfig{}, repository.NewUsers(sqlDB))
+	require.NoError(err)
+
+	access, err := service.NewAccess("worker", nil, nil)
+	require.NoError(err)
+
+	// httptest requests come from 192.0.2.1
+	proxies, err := service.NewTrustedProxies([]string{"192.0.2.0/24"})
+	require.NoError(err)
+
+	suite.router = Router(logger, jwt, provider, access, proxies, testUIDomain, repository.NewStores(sqlDB), suite.dir)
+}
+
+func (suite *RouterSuite) TearDownTest() {
+	suite.db.Close()
+	os.RemoveAll(suite.dir)
+}
+
+// request serves the request with the given token, if any, and returns the
+// response
+func (suite *RouterSuite) request(token, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	return w
+}
+
+// logIn posts the login to the dev provider form, and returns the response
+func (suite *RouterSuite) logIn(login string) *httptest.ResponseRecorder {
+	form := url.Values{"login": {login}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, r)
+	return w
+}
+
+// token logs in with the given login, and returns the token sent to the UI
+func (suite *RouterSuite) token(login string) string {
+	w := suite.logIn(login)
+	suite.Require().Equal(http.StatusTemporaryRedirect, w.Code, w.Body.String())
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	suite.Require().NoError(err)
+	suite.Require().True(strings.HasPrefix(location.String(), testUIDomain+"/?"))
+
+	token := location.Query().Get("token")
+	suite.Require().NotEmpty(token)
+	return token
+}
+
+// decode checks the status of the response, and decodes its data into v
+func (suite *RouterSuite) decode(w *httptest.ResponseRecorder, v interface{}) {
+	suite.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &struct{ Data interface{} }{v}))
+}
+
+func (suite *RouterSuite) TestLogin() {
+	w := suite.request("", "GET", "/login", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `value="alice"`)
+
+	w = suite.logIn("eve")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	token := suite.token("bob")
+
+	var me struct{ Login, Provider string }
+	suite.decode(suite.request(token, "GET", "/api/me", ""), &me)
+	suite.Equal("bob", me.Login)
+	suite.Equal(service.DevProvider, me.Provider)
+
+	w = suite.request(token, "POST", "/api/logout", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = suite.request(token, "GET", "/api/me", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *RouterSuite) TestRoleRefresh() {
+	// the role given by the provider is only set on new users, so the
+	// stored one is kept on log in
+	alice := &model.User{Login: "alice", Role: model.Worker, Provider: service.DevProvider}
+	suite.Require().NoError(repository.NewUsers(suite.db.SQLDB()).Create(alice))
+
+	w := suite.request(suite.token("alice"), "GET", "/api/experiments/1/stats", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// the users of other providers can not log in
+	bob := &model.User{Login: "bob", Role: model.Worker, Provider: service.GitHubProvider}
+	suite.Require().NoError(repository.NewUsers(suite.db.SQLDB()).Create(bob))
+
+	w = suite.logIn("bob")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *RouterSuite) TestAnswerExperiment() {
+	worker := suite.token("bob")
+	requester := suite.token("alice")
+
+	var assignments []struct{ ID int }
+	suite.decode(suite.request(worker, "GET", "/api/experiments/1/assignments", ""), &assignments)
+	suite.Require().Len(assignments, 2)
+
+	for _, as := range assignments {
+		w := suite.request(worker, "PUT", "/api/experiments/1/assignments/"+strconv.Itoa(as.ID),
+			`{"answer": "yes", "duration": 5}`)
+		suite.Equal(http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w := suite.request(worker, "GET", "/api/experiments/1/stats", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	var stats struct{ Workers, Answers int }
+	suite.decode(suite.request(requester, "GET", "/api/experiments/1/stats", ""), &stats)
+	suite.Equal(1, stats.Workers)
+	suite.Equal(2, stats.Answers)
+}
+
+func (suite *RouterSuite) TestHistory() {
+	require := suite.Require()
+	worker := suite.token("bob")
+	requester := suite.token("alice")
+
+	var assignments []struct{ ID, PairID int }
+	suite.decode(suite.request(worker, "GET", "/api/experiments/1/assignments", ""), &assignments)
+	require.Len(assignments, 2)
+
+	save := func(token string, id int, answer string) {
+		r := httptest.NewRequest("PUT", "/api/experiments/1/assignments/"+strconv.Itoa(id),
+			strings.NewReader(`{"answer": "`+answer+`"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Forwarded-For", "203.0.113.7, 192.0.2.10")
+
+		w := httptest.NewRecorder()
+		suite.router.ServeHTTP(w, r)
+		require.Equal(http.StatusOK, w.Code, w.Body.String())
+	}
+
+	save(worker, assignments[0].ID, "yes")
+	save(worker, assignments[0].ID, "no")
+	save(worker, assignments[1].ID, "maybe")
+
+	var own []struct{ ID int }
+	suite.decode(suite.request(requester, "GET", "/api/experiments/1/assignments", ""), &own)
+	require.Len(own, 2)
+	save(requester, own[0].ID, "yes")
+
+	type event struct {
+		AssignmentID, UserID, PairID int
+		ClientIP                     string
+	}
+
+	var events []event
+	suite.decode(suite.request(requester, "GET", "/api/experiments/1/history", ""), &events)
+	require.Len(events, 4)
+	suite.Equal("203.0.113.7", events[0].ClientIP)
+
+	var count int
+	require.NoError(suite.db.SQLDB().QueryRow(
+		"SELECT COUNT(*) FROM assignment_events WHERE assignment_id=$1", assignments[0].ID).Scan(&count))
+	suite.Equal(2, count)
+
+	suite.decode(suite.request(requester, "GET",
+		"/api/experiments/1/history?pairId="+strconv.Itoa(assignments[1].PairID), ""), &events)
+	require.Len(events, 1)
+	suite.Equal(assignments[1].ID, events[0].AssignmentID)
+
+	var bob struct{ ID int }
+	suite.decode(suite.request(worker, "GET", "/api/me", ""), &bob)
+
+	suite.decode(suite.request(requester, "GET",
+		"/api/experiments/1/history?userId="+strconv.Itoa(bob.ID), ""), &events)
+	require.Len(events, 3)
+	for _, e := range events {
+		suite.Equal(bob.ID, e.UserID)
+	}
+
+	suite.decode(suite.request(requester, "GET", fmt.Sprintf(
+		"/api/experiments/1/history?userId=%d&pairId=%d", bob.ID, assignments[0].PairID), ""), &events)
+	require.Len(events, 2)
+
+	w := suite.request(requester, "GET", "/api/experiments/1/history?pairId=x", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestRouter(t *testing.T) {
+	suite.Run(t, new(RouterSuite))
+}
@@ -1,8 +1,12 @@
 package serializer
 
 import (
+	"database/sql"
+	"math"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
 )
@@ -68,66 +72,514 @@ func NewEmptyResponse() *Response {
 }
 
 type experimentResponse struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID               int                `json:"id"`
+	Name             string             `json:"name"`
+	Description      string             `json:"description"`
+	WorkersPerPair   int                `json:"workersPerPair"`
+	SharedPairsRatio float64            `json:"sharedPairsRatio"`
+	MinGoldAccuracy  float64            `json:"minGoldAccuracy"`
+	MinGoldAnswers   int                `json:"minGoldAnswers"`
+	AnswerSchema     model.AnswerSchema `json:"answerSchema"`
+	CreatedAt        *time.Time         `json:"createdAt"`
+	UpdatedAt        *time.Time         `json:"updatedAt"`
+}
+
+func newExperimentResponse(e *model.Experiment) experimentResponse {
+	return experimentResponse{
+		ID:               e.ID,
+		Name:             e.Name,
+		Description:      e.Description,
+		WorkersPerPair:   e.WorkersPerPair,
+		SharedPairsRatio: e.SharedPairsRatio,
+		MinGoldAccuracy:  e.MinGoldAccuracy,
+		MinGoldAnswers:   e.MinGoldAnswers,
+		AnswerSchema:     e.AnswerSchema,
+		CreatedAt:        e.CreatedAt,
+		UpdatedAt:        e.UpdatedAt,
+	}
 }
 
 // NewExperimentResponse returns a Response for the passed Experiment
 func NewExperimentResponse(e *model.Experiment) *Response {
-	return newResponse(experimentResponse{
-		ID:          e.ID,
-		Name:        e.Name,
-		Description: e.Description,
+	return newResponse(newExperimentResponse(e))
+}
+
+// NewExperimentsResponse returns a Response for the passed Experiments
+func NewExperimentsResponse(es []*model.Experiment) *Response {
+	experiments := make([]experimentResponse, len(es))
+	for i, e := range es {
+		experiments[i] = newExperimentResponse(e)
+	}
+
+	return newResponse(experiments)
+}
+
+type pairwiseKappaResponse struct {
+	UserA int      `json:"userA"`
+	UserB int      `json:"userB"`
+	Pairs int      `json:"pairs"`
+	Kappa *float64 `json:"kappa"`
+}
+
+type pairDistributionResponse struct {
+	PairID  int            `json:"pairId"`
+	Answers map[string]int `json:"answers"`
+}
+
+type experimentStatsResponse struct {
+	ExperimentID       int                        `json:"experimentId"`
+	Categories         []string                   `json:"categories"`
+	Workers            int                        `json:"workers"`
+	Answers            int                        `json:"answers"`
+	CohenKappa         *float64                   `json:"cohenKappa"`
+	PairwiseCohenKappa []pairwiseKappaResponse    `json:"pairwiseCohenKappa"`
+	FleissKappa        *float64                   `json:"fleissKappa"`
+	KrippendorffAlpha  *float64                   `json:"krippendorffAlpha"`
+	Pairs              []pairDistributionResponse `json:"pairs"`
+}
+
+// nullableFloat returns nil for NaN values, that can not be encoded to JSON
+func nullableFloat(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+
+	return &f
+}
+
+// NewExperimentStatsResponse returns a Response with the agreement statistics
+// of the passed Experiment
+func NewExperimentStatsResponse(e *model.Experiment, a *model.Agreement) *Response {
+	pairwise := make([]pairwiseKappaResponse, len(a.PairwiseCohenKappa))
+	for i, p := range a.PairwiseCohenKappa {
+		pairwise[i] = pairwiseKappaResponse{p.UserA, p.UserB, p.Pairs, nullableFloat(p.Kappa)}
+	}
+
+	pairIDs := make([]int, 0, len(a.Distributions))
+	for id := range a.Distributions {
+		pairIDs = append(pairIDs, id)
+	}
+	sort.Ints(pairIDs)
+
+	pairs := make([]pairDistributionResponse, len(pairIDs))
+	for i, id := range pairIDs {
+		pairs[i] = pairDistributionResponse{id, a.Distributions[id]}
+	}
+
+	return newResponse(experimentStatsResponse{
+		ExperimentID:       e.ID,
+		Categories:         a.Categories,
+		Workers:            a.Workers,
+		Answers:            a.Answers,
+		CohenKappa:         nullableFloat(a.CohenKappa),
+		PairwiseCohenKappa: pairwise,
+		FleissKappa:        nullableFloat(a.FleissKappa),
+		KrippendorffAlpha:  nullableFloat(a.KrippendorffAlpha),
+		Pairs:              pairs,
 	})
 }
 
 type assignmentResponse struct {
-	ID           int     `json:"id"`
-	UserID       int     `json:"userId"`
-	PairID       int     `json:"pairId"`
-	ExperimentID int     `json:"experimentId"`
-	Answer       *string `json:"answer"`
-	Duration     int     `json:"duration"`
+	ID           int          `json:"id"`
+	UserID       int          `json:"userId"`
+	PairID       int          `json:"pairId"`
+	ExperimentID int          `json:"experimentId"`
+	Answer       *string      `json:"answer"`
+	Duration     int          `json:"duration"`
+	Comment      string       `json:"comment"`
+	Flags        []model.Flag `json:"flags"`
+	CreatedAt    *time.Time   `json:"createdAt"`
+	UpdatedAt    *time.Time   `json:"updatedAt"`
+	AnsweredAt   *time.Time   `json:"answeredAt"`
+}
+
+func newAssignmentResponse(a *model.Assignment) assignmentResponse {
+	var answer *string
+
+	if a.Answer.Valid {
+		answer = &a.Answer.String
+	}
+
+	flags := a.Flags
+	if flags == nil {
+		flags = []model.Flag{}
+	}
+
+	return assignmentResponse{a.ID, a.UserID, a.PairID,
+		a.ExperimentID, answer, a.Duration, a.Comment, flags,
+		a.CreatedAt, a.UpdatedAt, a.AnsweredAt}
 }
 
 // NewAssignmentsResponse returns a Response for the passed Assignment
 func NewAssignmentsResponse(as []*model.Assignment) *Response {
 	assignments := make([]assignmentResponse, len(as))
 	for i, a := range as {
-		var answer *string
+		assignments[i] = newAssignmentResponse(a)
+	}
 
-		if a.Answer.Valid {
-			answer = &a.Answer.String
+	return newResponse(assignments)
+}
+
+type flaggedPairResponse struct {
+	PairID      int                  `json:"pairId"`
+	Flags       map[model.Flag]int   `json:"flags"`
+	Assignments []assignmentResponse `json:"assignments"`
+}
+
+// NewFlaggedPairsResponse returns a Response with the passed flagged
+// Assignments grouped by pair, with the number of times each flag was set
+func NewFlaggedPairsResponse(as []*model.Assignment) *Response {
+	pairs := make([]flaggedPairResponse, 0)
+	byPair := make(map[int]int)
+
+	for _, a := range as {
+		i, ok := byPair[a.PairID]
+		if !ok {
+			i = len(pairs)
+			byPair[a.PairID] = i
+			pairs = append(pairs, flaggedPairResponse{
+				PairID: a.PairID,
+				Flags:  make(map[model.Flag]int),
+			})
+		}
+
+		for _, f := range a.Flags {
+			pairs[i].Flags[f]++
 		}
 
-		assignments[i] = assignmentResponse{a.ID, a.UserID, a.PairID,
-			a.ExperimentID, answer, a.Duration}
+		pairs[i].Assignments = append(pairs[i].Assignments, newAssignmentResponse(a))
 	}
 
-	return newResponse(assignments)
+	return newResponse(pairs)
+}
+
+type assignmentEventResponse struct {
+	ID           int          `json:"id"`
+	AssignmentID int          `json:"assignmentId"`
+	UserID       int          `json:"userId"`
+	PairID       int          `json:"pairId"`
+	ExperimentID int          `json:"experimentId"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	OldAnswer    *string      `json:"oldAnswer"`
+	NewAnswer    *string      `json:"newAnswer"`
+	OldDuration  int          `json:"oldDuration"`
+	NewDuration  int          `json:"newDuration"`
+	OldComment   string       `json:"oldComment"`
+	NewComment   string       `json:"newComment"`
+	OldFlags     []model.Flag `json:"oldFlags"`
+	NewFlags     []model.Flag `json:"newFlags"`
+	ClientIP     string       `json:"clientIp"`
+	UserAgent    string       `json:"userAgent"`
+}
+
+func nullableString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+
+	return &s.String
+}
+
+// NewAssignmentEventsResponse returns a Response for the passed
+// AssignmentEvents
+func NewAssignmentEventsResponse(es []*model.AssignmentEvent) *Response {
+	events := make([]assignmentEventResponse, len(es))
+	for i, e := range es {
+		events[i] = assignmentEventResponse{
+			ID:           e.ID,
+			AssignmentID: e.AssignmentID,
+			UserID:       e.UserID,
+			PairID:       e.PairID,
+			ExperimentID: e.ExperimentID,
+			CreatedAt:    e.CreatedAt,
+			OldAnswer:    nullableString(e.OldAnswer),
+			NewAnswer:    nullableString(e.NewAnswer),
+			OldDuration:  e.OldDuration,
+			NewDuration:  e.NewDuration,
+			OldComment:   e.OldComment,
+			NewComment:   e.NewComment,
+			OldFlags:     e.OldFlags,
+			NewFlags:     e.NewFlags,
+			ClientIP:     e.Client.IP,
+			UserAgent:    e.Client.UserAgent,
+		}
+	}
+
+	return newResponse(events)
+}
+
+type progressResponse struct {
+	Answered int `json:"answered"`
+	Total    int `json:"total"`
+}
+
+type nextAssignmentResponse struct {
+	Assignment *assignmentResponse `json:"assignment"`
+	Progress   progressResponse    `json:"progress"`
+}
+
+// NewNextAssignmentResponse returns a Response for the next Assignment to
+// answer, nil if there are no more, and the progress of the user
+func NewNextAssignmentResponse(a *model.Assignment, answered, total int) *Response {
+	resp := nextAssignmentResponse{Progress: progressResponse{answered, total}}
+	if a != nil {
+		assignment := newAssignmentResponse(a)
+		resp.Assignment = &assignment
+	}
+
+	return newResponse(resp)
+}
+
+type fileResponse struct {
+	BlobID       string `json:"blobId"`
+	RepositoryID string `json:"repositoryId"`
+	CommitHash   string `json:"commitHash"`
+	Path         string `json:"path"`
+	Content      string `json:"content"`
+	Hash         string `json:"hash"`
+}
+
+func newFileResponse(f model.File) *fileResponse {
+	return &fileResponse{f.BlobID, f.RepositoryID, f.CommitHash, f.Path, f.Content, f.Hash}
 }
 
 type filePairResponse struct {
-	ID   int    `json:"id"`
-	Diff string `json:"diff"`
+	ID           int           `json:"id"`
+	ExperimentID *int          `json:"experimentId,omitempty"`
+	Score        *float64      `json:"score,omitempty"`
+	Diff         *string       `json:"diff,omitempty"`
+	Left         *fileResponse `json:"left,omitempty"`
+	Right        *fileResponse `json:"right,omitempty"`
+	CreatedAt    *time.Time    `json:"createdAt,omitempty"`
+	UpdatedAt    *time.Time    `json:"updatedAt,omitempty"`
 }
 
-// NewFilePairResponse returns a Response for the given FilePair
-func NewFilePairResponse(fp *model.FilePair) *Response {
-	return newResponse(filePairResponse{fp.ID, fp.Diff})
+// FilePairFields lists the fields that can be requested for a FilePair
+var FilePairFields = map[string]bool{
+	"id":           true,
+	"experimentId": true,
+	"score":        true,
+	"diff":         true,
+	"left":         true,
+	"right":        true,
+	"createdAt":    true,
+	"updatedAt":    true,
+}
+
+// defaultFilePairFields are the fields returned when none is requested
+var defaultFilePairFields = []string{"id", "diff"}
+
+// NewFilePairResponse returns a Response for the given FilePair, with the
+// given fields from FilePairFields. The id is always returned; if no fields
+// are given, the id and diff are returned
+func NewFilePairResponse(fp *model.FilePair, fields ...string) *Response {
+	if len(fields) == 0 {
+		fields = defaultFilePairFields
+	}
+
+	resp := filePairResponse{ID: fp.ID}
+	for _, field := range fields {
+		switch field {
+		case "experimentId":
+			resp.ExperimentID = &fp.ExperimentID
+		case "score":
+			resp.Score = &fp.Score
+		case "diff":
+			resp.Diff = &fp.Diff
+		case "left":
+			resp.Left = newFileResponse(fp.Left)
+		case "right":
+			resp.Right = newFileResponse(fp.Right)
+		case "createdAt":
+			resp.CreatedAt = fp.CreatedAt
+		case "updatedAt":
+			resp.UpdatedAt = fp.UpdatedAt
+		}
+	}
+
+	return newResponse(resp)
+}
+
+type featureResponse struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+type sharedFeatureResponse struct {
+	Name        string  `json:"name"`
+	LeftWeight  float64 `json:"leftWeight"`
+	RightWeight float64 `json:"rightWeight"`
+	Difference  float64 `json:"difference"`
+}
+
+type featuresResponse struct {
+	Left      []featureResponse       `json:"left"`
+	Right     []featureResponse       `json:"right"`
+	Shared    []sharedFeatureResponse `json:"shared"`
+	LeftOnly  []featureResponse       `json:"leftOnly"`
+	RightOnly []featureResponse       `json:"rightOnly"`
+}
+
+// byWeight sorts features by descending weight
+type byWeight []featureResponse
+
+func (f byWeight) Len() int      { return len(f) }
+func (f byWeight) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byWeight) Less(i, j int) bool {
+	if f[i].Weight != f[j].Weight {
+		return f[i].Weight > f[j].Weight
+	}
+
+	return f[i].Name < f[j].Name
+}
+
+// byDifference sorts shared features by descending absolute difference
+type byDifference []sharedFeatureResponse
+
+func (f byDifference) Len() int      { return len(f) }
+func (f byDifference) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byDifference) Less(i, j int) bool {
+	di, dj := math.Abs(f[i].Difference), math.Abs(f[j].Difference)
+	if di != dj {
+		return di > dj
+	}
+
+	return f[i].Name < f[j].Name
+}
+
+// NewFeaturesResponse returns a Response with the features of the left and
+// right files of a FilePair, and their comparison. The difference of a shared
+// feature is its right weight minus its left weight
+func NewFeaturesResponse(left, right []*model.Feature) *Response {
+	leftWeights := make(map[string]float64, len(left))
+	for _, f := range left {
+		leftWeights[f.Name] = f.Weight
+	}
+
+	rightWeights := make(map[string]float64, len(right))
+	for _, f := range right {
+		rightWeights[f.Name] = f.Weight
+	}
+
+	resp := featuresResponse{
+		Left:      make([]featureResponse, 0, len(left)),
+		Right:     make([]featureResponse, 0, len(right)),
+		Shared:    make([]sharedFeatureResponse, 0),
+		LeftOnly:  make([]featureResponse, 0),
+		RightOnly: make([]featureResponse, 0),
+	}
+
+	for _, f := range left {
+		resp.Left = append(resp.Left, featureResponse{f.Name, f.Weight})
+
+		if rw, ok := rightWeights[f.Name]; ok {
+			resp.Shared = append(resp.Shared,
+				sharedFeatureResponse{f.Name, f.Weight, rw, rw - f.Weight})
+		} else {
+			resp.LeftOnly = append(resp.LeftOnly, featureResponse{f.Name, f.Weight})
+		}
+	}
+
+	for _, f := range right {
+		resp.Right = append(resp.Right, featureResponse{f.Name, f.Weight})
+
+		if _, ok := leftWeights[f.Name]; !ok {
+			resp.RightOnly = append(resp.RightOnly, featureResponse{f.Name, f.Weight})
+		}
+	}
+
+	sort.Sort(byWeight(resp.Left))
+	sort.Sort(byWeight(resp.Right))
+	sort.Sort(byWeight(resp.LeftOnly))
+	sort.Sort(byWeight(resp.RightOnly))
+	sort.Sort(byDifference(resp.Shared))
+
+	return newResponse(resp)
+}
+
+type goldAnswerResponse struct {
+	PairID int    `json:"pairId"`
+	Answer string `json:"answer"`
+}
+
+// NewGoldAnswersResponse returns a Response for the passed GoldAnswers
+func NewGoldAnswersResponse(gs []*model.GoldAnswer) *Response {
+	answers := make([]goldAnswerResponse, len(gs))
+	for i, g := range gs {
+		answers[i] = goldAnswerResponse{g.PairID, g.Answer}
+	}
+
+	return newResponse(answers)
+}
+
+type workerAccuracyResponse struct {
+	UserID   int     `json:"userId"`
+	Login    string  `json:"login"`
+	Answered int     `json:"answered"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+	Blocked  bool    `json:"blocked"`
+}
+
+// NewWorkersAccuracyResponse returns a Response with the accuracy of the
+// passed workers on the gold pairs of the Experiment, and whether they are
+// blocked from getting more assignments
+func NewWorkersAccuracyResponse(e *model.Experiment, ws []*model.WorkerAccuracy) *Response {
+	workers := make([]workerAccuracyResponse, len(ws))
+	for i, w := range ws {
+		workers[i] = workerAccuracyResponse{
+			UserID:   w.UserID,
+			Login:    w.Login,
+			Answered: w.Answered,
+			Correct:  w.Correct,
+			Accuracy: w.Accuracy(),
+			Blocked:  e.BlocksWorker(w),
+		}
+	}
+
+	return newResponse(workers)
 }
 
 type userResponse struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Username  string `json:"username"`
-	AvatarURL string `json:"avatarURL"`
+	ID            int        `json:"id"`
+	Login         string     `json:"login"`
+	Username      string     `json:"username"`
+	AvatarURL     string     `json:"avatarURL"`
+	Role          model.Role `json:"role"`
+	Provider      string     `json:"provider"`
+	Active        bool       `json:"active"`
+	CreatedAt     *time.Time `json:"createdAt"`
+	LastLoginAt   *time.Time `json:"lastLoginAt"`
+	DeactivatedAt *time.Time `json:"deactivatedAt"`
+}
+
+func newUserResponse(u *model.User) userResponse {
+	return userResponse{u.ID, u.Login, u.Username, u.AvatarURL, u.Role,
+		u.Provider, u.Active(), u.CreatedAt, u.LastLoginAt, u.DeactivatedAt}
 }
 
 // NewUserResponse returns a Response for the passed User
 func NewUserResponse(u *model.User) *Response {
-	return newResponse(userResponse{u.ID, u.Login, u.Username, u.AvatarURL})
+	return newResponse(newUserResponse(u))
+}
+
+// NewUsersResponse returns a Response for the passed Users
+func NewUsersResponse(us []*model.User) *Response {
+	users := make([]userResponse, len(us))
+	for i, u := range us {
+		users[i] = newUserResponse(u)
+	}
+
+	return newResponse(users)
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+}
+
+// NewTokenResponse returns a Response for a new JWT token
+func NewTokenResponse(token string) *Response {
+	return newResponse(tokenResponse{token})
 }
 
 type countResponse struct {
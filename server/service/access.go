@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// AccessConfig defines enviroment variables for the users access
+type AccessConfig struct {
+	DefaultRole  string   `envconfig:"DEFAULT_ROLE" default:"worker"`
+	Requesters   []string `envconfig:"REQUESTERS"`
+	AllowedUsers []string `envconfig:"ALLOWED_USERS"`
+}
+
+// Access service decides which users can log in, and the Role they get
+type Access struct {
+	defaultRole  model.Role
+	requesters   map[string]bool
+	allowedUsers map[string]bool
+}
+
+// NewAccess returns a new Access service. New users get the defaultRole,
+// except the ones in the requesters list, that are always Requesters. If
+// allowedUsers is not empty, only those users and the requesters can log in
+func NewAccess(defaultRole string, requesters, allowedUsers []string) (*Access, error) {
+	role, ok := model.Roles[model.Role(defaultRole)]
+	if !ok {
+		return nil, fmt.Errorf("Wrong default role: %q", defaultRole)
+	}
+
+	return &Access{
+		defaultRole:  role,
+		requesters:   loginsSet(requesters),
+		allowedUsers: loginsSet(allowedUsers),
+	}, nil
+}
+
+// loginsSet returns a set with the given logins, ignoring the empty ones
+func loginsSet(logins []string) map[string]bool {
+	set := make(map[string]bool, len(logins))
+	for _, login := range logins {
+		if login = strings.TrimSpace(login); login != "" {
+			set[login] = true
+		}
+	}
+
+	return set
+}
+
+// Allowed returns true if the user with the given login can log in
+func (a *Access) Allowed(login string) bool {
+	if len(a.allowedUsers) == 0 || a.requesters[login] {
+		return true
+	}
+
+	return a.allowedUsers[login]
+}
+
+// Role returns the Role of the user with the given login. current is the Role
+// already stored for the user, or an empty one for new users
+func (a *Access) Role(login string, current model.Role) model.Role {
+	if a.requesters[login] {
+		return model.Requester
+	}
+
+	if current == "" {
+		return a.defaultRole
+	}
+
+	return current
+}
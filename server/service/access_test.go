@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/src-d/code-annotation/server/model"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AccessSuite struct {
+	suite.Suite
+}
+
+func (suite *AccessSuite) TestWrongDefaultRole() {
+	_, err := NewAccess("admin", nil, nil)
+	suite.Error(err)
+}
+
+func (suite *AccessSuite) TestRole() {
+	access, err := NewAccess("worker", []string{"boss", ""}, nil)
+	suite.Require().NoError(err)
+
+	suite.Equal(model.Worker, access.Role("newbie", ""))
+	suite.Equal(model.Requester, access.Role("boss", ""))
+	suite.Equal(model.Requester, access.Role("boss", model.Worker))
+	suite.Equal(model.Requester, access.Role("promoted", model.Requester))
+}
+
+func (suite *AccessSuite) TestAllowed() {
+	access, err := NewAccess("worker", []string{"boss"}, []string{""})
+	suite.Require().NoError(err)
+	suite.True(access.Allowed("anyone"))
+
+	access, err = NewAccess("worker", []string{"boss"}, []string{"alice", " bob"})
+	suite.Require().NoError(err)
+	suite.True(access.Allowed("alice"))
+	suite.True(access.Allowed("bob"))
+	suite.True(access.Allowed("boss"))
+	suite.False(access.Allowed("eve"))
+}
+
+func TestAccess(t *testing.T) {
+	suite.Run(t, new(AccessSuite))
+}
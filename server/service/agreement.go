@@ -0,0 +1,239 @@
+package service
+
+import (
+	"math"
+	"sort"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+// NewAgreement returns the Agreement of the given assignments, counting only
+// the answers in categories. Unanswered and skipped assignments are ignored
+func NewAgreement(assignments []*model.Assignment, categories []string) *model.Agreement {
+	valid := make(map[string]bool, len(categories))
+	for _, c := range categories {
+		if c != model.SkipAnswer {
+			valid[c] = true
+		}
+	}
+
+	cats := make([]string, 0, len(valid))
+	for c := range valid {
+		cats = append(cats, c)
+	}
+	sort.Strings(cats)
+
+	// answers by user ID and pair ID
+	byUser := make(map[int]map[int]string)
+	distributions := make(map[int]map[string]int)
+	total := 0
+
+	for _, as := range assignments {
+		if !as.Answer.Valid || !valid[as.Answer.String] {
+			continue
+		}
+
+		if byUser[as.UserID] == nil {
+			byUser[as.UserID] = make(map[int]string)
+		}
+		byUser[as.UserID][as.PairID] = as.Answer.String
+
+		if distributions[as.PairID] == nil {
+			distributions[as.PairID] = make(map[string]int, len(cats))
+			for _, c := range cats {
+				distributions[as.PairID][c] = 0
+			}
+		}
+		distributions[as.PairID][as.Answer.String]++
+		total++
+	}
+
+	pairwise := pairwiseCohenKappa(byUser, cats)
+
+	return &model.Agreement{
+		Categories:         cats,
+		Distributions:      distributions,
+		Workers:            len(byUser),
+		Answers:            total,
+		CohenKappa:         meanKappa(pairwise),
+		PairwiseCohenKappa: pairwise,
+		FleissKappa:        fleissKappa(distributions, cats),
+		KrippendorffAlpha:  krippendorffAlpha(distributions, cats),
+	}
+}
+
+// pairwiseCohenKappa returns the Cohen's kappa of each pair of workers that
+// answered some pair in common, ordered by user IDs
+func pairwiseCohenKappa(byUser map[int]map[int]string, cats []string) []model.PairwiseKappa {
+	users := make([]int, 0, len(byUser))
+	for id := range byUser {
+		users = append(users, id)
+	}
+	sort.Ints(users)
+
+	result := make([]model.PairwiseKappa, 0)
+	for i, a := range users {
+		for _, b := range users[i+1:] {
+			n, kappa := cohenKappa(byUser[a], byUser[b], cats)
+			if n == 0 {
+				continue
+			}
+
+			result = append(result, model.PairwiseKappa{UserA: a, UserB: b, Pairs: n, Kappa: kappa})
+		}
+	}
+
+	return result
+}
+
+// cohenKappa returns the number of pairs answered by both workers, and the
+// Cohen's kappa of their answers
+func cohenKappa(a, b map[int]string, cats []string) (int, float64) {
+	countA := make(map[string]float64, len(cats))
+	countB := make(map[string]float64, len(cats))
+	n, agree := 0, 0
+
+	for pairID, answerA := range a {
+		answerB, ok := b[pairID]
+		if !ok {
+			continue
+		}
+
+		n++
+		countA[answerA]++
+		countB[answerB]++
+		if answerA == answerB {
+			agree++
+		}
+	}
+
+	if n == 0 {
+		return 0, math.NaN()
+	}
+
+	var pe float64
+	for _, c := range cats {
+		pe += (countA[c] / float64(n)) * (countB[c] / float64(n))
+	}
+
+	po := float64(agree) / float64(n)
+	return n, chanceCorrected(po, pe)
+}
+
+// meanKappa returns the mean of the pairwise kappas that are not NaN
+func meanKappa(pairwise []model.PairwiseKappa) float64 {
+	var sum float64
+	n := 0
+
+	for _, p := range pairwise {
+		if !math.IsNaN(p.Kappa) {
+			sum += p.Kappa
+			n++
+		}
+	}
+
+	if n == 0 {
+		return math.NaN()
+	}
+
+	return sum / float64(n)
+}
+
+// fleissKappa returns the Fleiss' kappa of the given answers distributions,
+// allowing a different number of answers for each pair
+func fleissKappa(distributions map[int]map[string]int, cats []string) float64 {
+	totals := make(map[string]float64, len(cats))
+	var sumP, nAnswers float64
+	nPairs := 0
+
+	for _, dist := range distributions {
+		m := pairAnswers(dist)
+		if m < 2 {
+			continue
+		}
+
+		var agreeing float64
+		for _, c := range cats {
+			n := float64(dist[c])
+			agreeing += n * (n - 1)
+			totals[c] += n
+		}
+
+		sumP += agreeing / float64(m*(m-1))
+		nAnswers += float64(m)
+		nPairs++
+	}
+
+	if nPairs == 0 {
+		return math.NaN()
+	}
+
+	var pe float64
+	for _, c := range cats {
+		p := totals[c] / nAnswers
+		pe += p * p
+	}
+
+	return chanceCorrected(sumP/float64(nPairs), pe)
+}
+
+// krippendorffAlpha returns the Krippendorff's alpha of the given answers
+// distributions, using the nominal metric
+func krippendorffAlpha(distributions map[int]map[string]int, cats []string) float64 {
+	// nc is the number of pairable values of each category, and disagree the
+	// sum of the coincidences between different categories
+	nc := make(map[string]float64, len(cats))
+	var disagree float64
+
+	for _, dist := range distributions {
+		m := pairAnswers(dist)
+		if m < 2 {
+			continue
+		}
+
+		for i, c := range cats {
+			nc[c] += float64(dist[c])
+
+			for _, k := range cats[i+1:] {
+				disagree += 2 * float64(dist[c]*dist[k]) / float64(m-1)
+			}
+		}
+	}
+
+	var n, expected float64
+	for _, c := range cats {
+		n += nc[c]
+	}
+
+	for i, c := range cats {
+		for _, k := range cats[i+1:] {
+			expected += 2 * nc[c] * nc[k]
+		}
+	}
+
+	if expected == 0 {
+		return math.NaN()
+	}
+
+	return 1 - (n-1)*disagree/expected
+}
+
+// pairAnswers returns the total number of answers of a distribution
+func pairAnswers(dist map[string]int) int {
+	m := 0
+	for _, n := range dist {
+		m += n
+	}
+
+	return m
+}
+
+// chanceCorrected returns the agreement po corrected by the agreement
+// expected by chance pe, or NaN if pe is 1
+func chanceCorrected(po, pe float64) float64 {
+	if pe >= 1 {
+		return math.NaN()
+	}
+
+	return (po - pe) / (1 - pe)
+}
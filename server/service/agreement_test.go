@@ -0,0 +1,126 @@
+package service
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/model"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AgreementSuite struct {
+	suite.Suite
+}
+
+func answer(userID, pairID int, a string) *model.Assignment {
+	return &model.Assignment{
+		UserID: userID,
+		PairID: pairID,
+		Answer: sql.NullString{String: a, Valid: a != ""},
+	}
+}
+
+func (suite *AgreementSuite) TestCohenKappa() {
+	var as []*model.Assignment
+	pairID := 0
+	add := func(n int, a, b string) {
+		for i := 0; i < n; i++ {
+			pairID++
+			as = append(as, answer(1, pairID, a), answer(2, pairID, b))
+		}
+	}
+
+	add(20, "yes", "yes")
+	add(5, "yes", "no")
+	add(10, "no", "yes")
+	add(15, "no", "no")
+	// ignored answers
+	as = append(as, answer(1, 100, "skip"), answer(2, 100, "yes"), answer(3, 100, ""))
+
+	agreement := NewAgreement(as, []string{"yes", "maybe", "no", "skip"})
+
+	suite.Equal([]string{"maybe", "no", "yes"}, agreement.Categories)
+	suite.Equal(2, agreement.Workers)
+	suite.Equal(101, agreement.Answers)
+	suite.Require().Len(agreement.PairwiseCohenKappa, 1)
+	suite.Equal(50, agreement.PairwiseCohenKappa[0].Pairs)
+	suite.InDelta(0.4, agreement.PairwiseCohenKappa[0].Kappa, 1e-9)
+	suite.InDelta(0.4, agreement.CohenKappa, 1e-9)
+	suite.Equal(map[string]int{"yes": 1, "maybe": 0, "no": 0}, agreement.Distributions[100])
+}
+
+func (suite *AgreementSuite) TestFleissKappa() {
+	// example from https://en.wikipedia.org/wiki/Fleiss%27_kappa
+	table := [][]int{
+		{0, 0, 0, 0, 14},
+		{0, 2, 6, 4, 2},
+		{0, 0, 3, 5, 6},
+		{0, 3, 9, 2, 0},
+		{2, 2, 8, 1, 1},
+		{7, 7, 0, 0, 0},
+		{3, 2, 6, 3, 0},
+		{2, 5, 3, 2, 2},
+		{6, 5, 2, 1, 0},
+		{0, 2, 2, 3, 7},
+	}
+	cats := []string{"1", "2", "3", "4", "5"}
+
+	var as []*model.Assignment
+	for pairID, row := range table {
+		userID := 0
+		for c, n := range row {
+			for i := 0; i < n; i++ {
+				userID++
+				as = append(as, answer(userID, pairID, cats[c]))
+			}
+		}
+	}
+
+	agreement := NewAgreement(as, cats)
+	suite.InDelta(0.20993, agreement.FleissKappa, 1e-5)
+}
+
+func (suite *AgreementSuite) TestKrippendorffAlpha() {
+	// reliability data from Krippendorff, "Computing Krippendorff's
+	// Alpha-Reliability" (2011); 0 means no answer
+	data := [][]int{
+		{1, 2, 3, 3, 2, 1, 4, 1, 2, 0, 0, 0},
+		{1, 2, 3, 3, 2, 2, 4, 1, 2, 5, 0, 3},
+		{0, 3, 3, 3, 2, 3, 4, 2, 2, 5, 1, 0},
+		{1, 2, 3, 3, 2, 4, 4, 1, 2, 5, 1, 0},
+	}
+	cats := []string{"1", "2", "3", "4", "5"}
+
+	var as []*model.Assignment
+	for userID, row := range data {
+		for pairID, v := range row {
+			if v != 0 {
+				as = append(as, answer(userID, pairID, cats[v-1]))
+			}
+		}
+	}
+
+	agreement := NewAgreement(as, cats)
+	suite.InDelta(0.743, agreement.KrippendorffAlpha, 1e-3)
+}
+
+func (suite *AgreementSuite) TestUndefined() {
+	agreement := NewAgreement(nil, []string{"yes", "no"})
+	suite.True(math.IsNaN(agreement.CohenKappa))
+	suite.True(math.IsNaN(agreement.FleissKappa))
+	suite.True(math.IsNaN(agreement.KrippendorffAlpha))
+	suite.Empty(agreement.PairwiseCohenKappa)
+
+	agreement = NewAgreement([]*model.Assignment{
+		answer(1, 1, "yes"), answer(2, 1, "yes"),
+	}, []string{"yes", "no"})
+	suite.True(math.IsNaN(agreement.CohenKappa))
+	suite.True(math.IsNaN(agreement.FleissKappa))
+	suite.True(math.IsNaN(agreement.KrippendorffAlpha))
+}
+
+func TestAgreement(t *testing.T) {
+	suite.Run(t, new(AgreementSuite))
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/model"
+)
+
+var devLoginForm = template.Must(template.New("dev").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Source Code Annotation (development)</title></head>
+<body>
+<h1>Source Code Annotation (development)</h1>
+<form method="post">
+{{- range .}}
+<p><button type="submit" name="login" value="{{.Login}}">{{.Login}} ({{.Role}})</button></p>
+{{- else}}
+<p><label>Login <input name="login" autofocus></label></p>
+<p><button type="submit">Log in</button></p>
+{{- end}}
+</form>
+</body>
+</html>
+`))
+
+// Dev is the Provider for development, that lets the users log in without
+// any password nor external service. It is refused by NewProvider unless it
+// is explicitly enabled
+type Dev struct {
+	users []*AuthUser
+}
+
+// NewDev returns a new Dev provider for the given users, as "login:role" or
+// just "login". With an empty list any login is accepted, with the Role given
+// by Access
+func NewDev(users []string) (*Dev, error) {
+	d := &Dev{}
+	for _, u := range users {
+		parts := strings.SplitN(strings.TrimSpace(u), ":", 2)
+		if parts[0] == "" {
+			continue
+		}
+
+		user := &AuthUser{Login: parts[0], Username: parts[0]}
+		if len(parts) == 2 {
+			role, ok := model.Roles[model.Role(parts[1])]
+			if !ok {
+				return nil, fmt.Errorf("Wrong role of the user %q: %q", parts[0], parts[1])
+			}
+
+			user.Role = role
+		}
+
+		d.users = append(d.users, user)
+	}
+
+	return d, nil
+}
+
+// Name returns the name of the provider
+func (d *Dev) Name() string {
+	return DevProvider
+}
+
+// Login writes the log in form, that is posted to the same URL
+func (d *Dev) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	devLoginForm.Execute(w, d.users)
+}
+
+// Callback returns the user of the login posted with the form
+func (d *Dev) Callback(r *http.Request) (*AuthUser, error) {
+	login := strings.TrimSpace(r.PostFormValue("login"))
+	if login == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if len(d.users) == 0 {
+		return &AuthUser{Login: login, Username: login}, nil
+	}
+
+	for _, u := range d.users {
+		if u.Login == login {
+			user := *u
+			return &user, nil
+		}
+	}
+
+	return nil, ErrInvalidCredentials
+}
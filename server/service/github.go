@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/src-d/code-annotation/server/model"
+
+	"golang.org/x/oauth2/github"
+)
+
+// githubAPIURL is the base URL of the GitHub API; it is changed by the tests
+var githubAPIURL = "https://api.github.com"
+
+// githubPageSize is the number of items requested to the GitHub API lists
+const githubPageSize = 100
+
+// githubUser represents the user response returned by the GitHub API
+type githubUser struct {
+	Login     string `json:"login"`
+	Username  string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// githubListItem is an item of the organizations or teams of the user
+// returned by the GitHub API
+type githubListItem struct {
+	// Login is the name of an organization
+	Login string `json:"login"`
+	// Slug and Organization identify a team
+	Slug         string `json:"slug"`
+	Organization struct {
+		Login string `json:"login"`
+	} `json:"organization"`
+}
+
+// githubMembership decides which GitHub users can log in, and their Role, by
+// their membership of organizations and teams
+type githubMembership struct {
+	orgs  map[string]bool
+	teams map[string]model.Role
+}
+
+// newGitHubMembership returns a githubMembership for the given organizations
+// and teams, as "org/team:role". The names are not case sensitive
+func newGitHubMembership(orgs []string, teams []string) (*githubMembership, error) {
+	m := &githubMembership{
+		orgs:  make(map[string]bool),
+		teams: make(map[string]model.Role),
+	}
+
+	for _, org := range orgs {
+		if org = strings.ToLower(strings.TrimSpace(org)); org != "" {
+			m.orgs[org] = true
+		}
+	}
+
+	for _, team := range teams {
+		if team = strings.TrimSpace(team); team == "" {
+			continue
+		}
+
+		parts := strings.Split(team, ":")
+		names := strings.Split(parts[0], "/")
+		if len(parts) != 2 || len(names) != 2 || names[0] == "" || names[1] == "" {
+			return nil, fmt.Errorf("Wrong GitHub team %q, it must be org/team:role", team)
+		}
+
+		role, ok := model.Roles[model.Role(parts[1])]
+		if !ok {
+			return nil, fmt.Errorf("Wrong role of the GitHub team %q: %q", parts[0], parts[1])
+		}
+
+		m.teams[strings.ToLower(parts[0])] = role
+	}
+
+	return m, nil
+}
+
+// enabled returns true if the users must be members of an organization or
+// team to log in
+func (m *githubMembership) enabled() bool {
+	return len(m.orgs) > 0 || len(m.teams) > 0
+}
+
+// role returns the Role of the user of the client, given by the teams it
+// belongs to, that is set when the user is created. The members of the
+// organizations that are not in any team get the Worker Role, or an empty one
+// to let Access decide when there are no teams. The rest of users are not
+// allowed to log in
+func (m *githubMembership) role(client *http.Client) (model.Role, error) {
+	var role model.Role
+	if len(m.teams) > 0 {
+		teams, err := githubList(client, "/user/teams")
+		if err != nil {
+			return "", err
+		}
+
+		for _, t := range teams {
+			key := strings.ToLower(t.Organization.Login + "/" + t.Slug)
+			if r, ok := m.teams[key]; ok && (role == "" || r == model.Requester) {
+				role = r
+			}
+		}
+	}
+
+	if role != "" {
+		return role, nil
+	}
+
+	if len(m.orgs) > 0 {
+		orgs, err := githubList(client, "/user/orgs")
+		if err != nil {
+			return "", err
+		}
+
+		for _, o := range orgs {
+			if !m.orgs[strings.ToLower(o.Login)] {
+				continue
+			}
+
+			if len(m.teams) > 0 {
+				return model.Worker, nil
+			}
+
+			return "", nil
+		}
+	}
+
+	return "", ErrNotAllowed
+}
+
+// githubList returns all the items of the GitHub API list at the given path,
+// requesting all its pages
+func githubList(client *http.Client, path string) ([]githubListItem, error) {
+	var items []githubListItem
+	for page := 1; ; page++ {
+		var pageItems []githubListItem
+		url := fmt.Sprintf("%s%s?per_page=%d&page=%d", githubAPIURL, path, githubPageSize, page)
+		if err := getJSON(client, url, &pageItems); err != nil {
+			return nil, err
+		}
+
+		items = append(items, pageItems...)
+		if len(pageItems) < githubPageSize {
+			return items, nil
+		}
+	}
+}
+
+// NewGitHub returns a new OAuth service that authenticates GitHub users. If
+// orgs or teams are given, only their members can log in, and the new users get
+// the Role of their teams; see ProviderConfig
+func NewGitHub(conf OAuthConfig, orgs []string, teams []string) (*OAuth, error) {
+	membership, err := newGitHubMembership(orgs, teams)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{"read:user"}
+	if membership.enabled() {
+		scopes = append(scopes, "read:org")
+	}
+
+	return newOAuth(GitHubProvider, github.Endpoint, scopes, conf,
+		func(client *http.Client) (*AuthUser, error) {
+			var user githubUser
+			if err := getJSON(client, githubAPIURL+"/user", &user); err != nil {
+				return nil, err
+			}
+
+			authUser := &AuthUser{Login: user.Login, Username: user.Username, AvatarURL: user.AvatarURL}
+			if !membership.enabled() {
+				return authUser, nil
+			}
+
+			role, err := membership.role(client)
+			if err != nil {
+				return nil, err
+			}
+
+			authUser.Role = role
+			return authUser, nil
+		}), nil
+}
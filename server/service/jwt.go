@@ -2,11 +2,15 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
@@ -29,30 +33,108 @@ var extractor = &request.PostExtractionFilter{
 // JWTConfig defines enviroment variables for JWT
 type JWTConfig struct {
 	SigningKey string `envconfig:"SIGNING_KEY" required:"true"`
+	Issuer     string `envconfig:"ISSUER" default:"code-annotation"`
+	Audience   string `envconfig:"AUDIENCE" default:"code-annotation"`
+	// Expiry is the lifetime of a token; RefreshExpiry is the time since the
+	// log in during which it can be refreshed
+	Expiry        time.Duration `envconfig:"EXPIRY" default:"1h"`
+	RefreshExpiry time.Duration `envconfig:"REFRESH_EXPIRY" default:"168h"`
 }
 
-// JWT service abstracts JWT implementation
+// JWT service abstracts JWT implementation. Every token has a model.Session,
+// stored to revoke it
 type JWT struct {
+	conf       JWTConfig
 	signingKey []byte
+	sessions   repository.SessionStore
 }
 
 // NewJWT return new JWT service
-func NewJWT(signingKey string) *JWT {
-	return &JWT{signingKey: []byte(signingKey)}
+func NewJWT(conf JWTConfig, sessions repository.SessionStore) *JWT {
+	return &JWT{conf: conf, signingKey: []byte(conf.SigningKey), sessions: sessions}
 }
 
-type userIDContext int
+// ErrInvalidToken is returned when a token is not valid, expired or revoked
+var ErrInvalidToken = fmt.Errorf("invalid token")
 
-const userIDKey userIDContext = 1
+type userContext int
+
+const (
+	userIDKey userContext = iota + 1
+	userRoleKey
+	tokenIDKey
+)
 
 type jwtClaim struct {
-	ID int
+	ID   int
+	Role model.Role
 	jwt.StandardClaims
 }
 
-// MakeToken generates token string for a user
+// newTokenID returns a random ID for a token
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// MakeToken generates token string for a user, opening a new session
 func (j *JWT) MakeToken(user *model.User) (string, error) {
-	claims := &jwtClaim{ID: user.ID}
+	return j.makeToken(user, time.Now().UTC().Add(j.conf.RefreshExpiry))
+}
+
+// Refresh revokes the token with the given ID, and generates a new token for
+// the user. Its session expires with the one of the revoked token, so the
+// refresh expiry counts from the log in. It returns ErrInvalidToken if the
+// token was already revoked, e.g. by a concurrent refresh
+func (j *JWT) Refresh(user *model.User, tokenID string) (string, error) {
+	session, err := j.sessions.Get(tokenID)
+	if err != nil {
+		return "", err
+	}
+
+	if session == nil || session.UserID != user.ID {
+		return "", ErrInvalidToken
+	}
+
+	if err := j.Revoke(tokenID); err != nil {
+		return "", err
+	}
+
+	return j.makeToken(user, session.ExpiresAt)
+}
+
+// makeToken generates token string for a user, opening a new session that can
+// be refreshed until expiresAt
+func (j *JWT) makeToken(user *model.User, expiresAt time.Time) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("can't generate jwt token id: %s", err)
+	}
+
+	now := time.Now().UTC()
+	session := &model.Session{ID: id, UserID: user.ID,
+		CreatedAt: now, ExpiresAt: expiresAt}
+	if err := j.sessions.Create(session); err != nil {
+		return "", fmt.Errorf("can't store jwt session: %s", err)
+	}
+
+	// the token can not outlive its session
+	tokenExpiresAt := now.Add(j.conf.Expiry)
+	if tokenExpiresAt.After(expiresAt) {
+		tokenExpiresAt = expiresAt
+	}
+
+	claims := &jwtClaim{ID: user.ID, Role: user.Role, StandardClaims: jwt.StandardClaims{
+		Id:        id,
+		Issuer:    j.conf.Issuer,
+		Audience:  j.conf.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: tokenExpiresAt.Unix(),
+	}}
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	ss, err := t.SignedString(j.signingKey)
 	if err != nil {
@@ -61,22 +143,97 @@ func (j *JWT) MakeToken(user *model.User) (string, error) {
 	return ss, nil
 }
 
-// Middleware return http.Handler which validates token and set user id in context
+// parse returns the claims of the token of the request. An expired token is
+// only accepted with allowExpired, but its session must still be active. It
+// returns ErrInvalidToken for any token that can not be used
+func (j *JWT) parse(r *http.Request, allowExpired bool) (*jwtClaim, error) {
+	var claims jwtClaim
+	_, err := request.ParseFromRequestWithClaims(r, extractor, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		return j.signingKey, nil
+	})
+	if err != nil {
+		vErr, ok := err.(*jwt.ValidationError)
+		if !ok || !allowExpired || vErr.Errors != jwt.ValidationErrorExpired {
+			return nil, ErrInvalidToken
+		}
+	}
+
+	if claims.Role == "" || claims.Id == "" ||
+		!claims.VerifyIssuer(j.conf.Issuer, true) ||
+		!claims.VerifyAudience(j.conf.Audience, true) {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := j.sessions.Get(claims.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if session == nil || session.UserID != claims.ID || !session.Active(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// Middleware return http.Handler which validates token and set user id, role
+// and token id in context. Tokens issued without a role or ID, for other
+// issuer or audience, expired or revoked are rejected
 func (j *JWT) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var claims jwtClaim
-		_, err := request.ParseFromRequestWithClaims(r, extractor, &claims, func(token *jwt.Token) (interface{}, error) {
-			return j.signingKey, nil
-		})
-		if err != nil {
+		claims, err := j.parse(r, false)
+		if err == ErrInvalidToken {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		r = r.WithContext(context.WithValue(r.Context(), userIDKey, claims.ID))
+
+		if err != nil {
+			NewLogger().Errorf("can't validate jwt token: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, claims.ID)
+		ctx = context.WithValue(ctx, userRoleKey, claims.Role)
+		ctx = context.WithValue(ctx, tokenIDKey, claims.Id)
+		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// ParseRefresh returns the user and token IDs of the token of the request, to
+// be refreshed. The token can be expired, but not revoked nor older than the
+// refresh expiry
+func (j *JWT) ParseRefresh(r *http.Request) (userID int, tokenID string, err error) {
+	claims, err := j.parse(r, true)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return claims.ID, claims.Id, nil
+}
+
+// Revoke closes the session of the token with the given ID, so it is not
+// accepted anymore. It returns ErrInvalidToken if it was already revoked
+func (j *JWT) Revoke(tokenID string) error {
+	err := j.sessions.Revoke(tokenID)
+	if err == repository.ErrSessionRevoked {
+		return ErrInvalidToken
+	}
+
+	return err
+}
+
+// RevokeUser closes all the sessions of the given user, so none of its tokens
+// are accepted anymore
+func (j *JWT) RevokeUser(userID int) error {
+	return j.sessions.RevokeUser(userID)
+}
+
 // getUserInt gets the value stored in the Context for the key userIDKey, bool
 // is true on success
 func getUserInt(ctx context.Context) (int, bool) {
@@ -93,3 +250,23 @@ func GetUserID(ctx context.Context) (int, error) {
 
 	return id, nil
 }
+
+// GetUserRole gets the user Role set by the JWT middleware in the Context
+func GetUserRole(ctx context.Context) (model.Role, error) {
+	role, ok := ctx.Value(userRoleKey).(model.Role)
+	if !ok {
+		return "", fmt.Errorf("User role is not set in the context")
+	}
+
+	return role, nil
+}
+
+// GetTokenID gets the ID of the token set by the JWT middleware in the Context
+func GetTokenID(ctx context.Context) (string, error) {
+	id, ok := ctx.Value(tokenIDKey).(string)
+	if !ok {
+		return "", fmt.Errorf("Token ID is not set in the context")
+	}
+
+	return id, nil
+}
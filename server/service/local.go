@@ -0,0 +1,77 @@
+package service
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Source Code Annotation</title></head>
+<body>
+<h1>Source Code Annotation</h1>
+<form method="post">
+<p><label>Login <input name="login" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// Local is the Provider that authenticates the users with the password
+// hashes stored in the DB. Only the users created with the local provider
+// can log in
+type Local struct {
+	users repository.UserStore
+}
+
+// NewLocal returns a new Local provider
+func NewLocal(users repository.UserStore) *Local {
+	return &Local{users: users}
+}
+
+// HashPassword returns the bcrypt hash of the password, to be stored in the
+// PasswordHash of the local users
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Name returns the name of the provider
+func (l *Local) Name() string {
+	return LocalProvider
+}
+
+// Login writes the log in form, that is posted to the same URL
+func (l *Local) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginForm.Execute(w, nil)
+}
+
+// Callback returns the user of the login and password posted with the form
+func (l *Local) Callback(r *http.Request) (*AuthUser, error) {
+	login, password := r.PostFormValue("login"), r.PostFormValue("password")
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := l.users.Get(login)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil || user.Provider != LocalProvider || user.PasswordHash == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &AuthUser{Login: user.Login, Username: user.Username, AvatarURL: user.AvatarURL}, nil
+}
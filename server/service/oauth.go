@@ -7,46 +7,122 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
 )
 
-// OAuthConfig defines enviroment variables for OAuth
+// OAuthConfig defines enviroment variables for OAuth. The client ID and secret
+// are required by all the providers but the local and dev ones. RedirectURL is the
+// URL of the /oauth-callback endpoint; GitHub uses the one of the OAuth
+// application when it is empty
 type OAuthConfig struct {
-	ClientID     string `envconfig:"CLIENT_ID" required:"true"`
-	ClientSecret string `envconfig:"CLIENT_SECRET" required:"true"`
+	ClientID     string `envconfig:"CLIENT_ID"`
+	ClientSecret string `envconfig:"CLIENT_SECRET"`
+	RedirectURL  string `envconfig:"REDIRECT_URL"`
 }
 
-// OAuth service abstracts OAuth implementation
+// OAuth service abstracts OAuth implementation. It is the Provider that
+// authenticates the users with an OAuth 2 authorization code flow
 type OAuth struct {
+	name   string
 	config *oauth2.Config
 	store  *sessions.CookieStore
+	// userInfo gets the user from the provider, with a client that sends the
+	// access token
+	userInfo func(client *http.Client) (*AuthUser, error)
 }
 
-// NewOAuth return new OAuth service
-func NewOAuth(clientID, clientSecret string) *OAuth {
+func newOAuth(
+	name string,
+	endpoint oauth2.Endpoint,
+	scopes []string,
+	conf OAuthConfig,
+	userInfo func(client *http.Client) (*AuthUser, error),
+) *OAuth {
 	config := &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		Scopes:       []string{"read:user"},
-		Endpoint:     github.Endpoint,
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURL,
+		Scopes:       scopes,
+		Endpoint:     endpoint,
 	}
 	return &OAuth{
-		config: config,
-		store:  sessions.NewCookieStore([]byte(clientSecret)),
+		name:     name,
+		config:   config,
+		store:    sessions.NewCookieStore([]byte(conf.ClientSecret)),
+		userInfo: userInfo,
 	}
 }
 
-// GithubUser represents the user response returned by the GitHub auth.
-type githubUser struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
+// gitlabUser represents the user response returned by the GitLab API
+type gitlabUser struct {
+	Login     string `json:"username"`
 	Username  string `json:"name"`
 	AvatarURL string `json:"avatar_url"`
 }
 
+// NewGitLab returns a new OAuth service that authenticates the users of the
+// GitLab instance at the given URL
+func NewGitLab(baseURL string, conf OAuthConfig) *OAuth {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	endpoint := oauth2.Endpoint{
+		AuthURL:  baseURL + "/oauth/authorize",
+		TokenURL: baseURL + "/oauth/token",
+	}
+
+	return newOAuth(GitLabProvider, endpoint, []string{"read_user"}, conf,
+		func(client *http.Client) (*AuthUser, error) {
+			var user gitlabUser
+			if err := getJSON(client, baseURL+"/api/v4/user", &user); err != nil {
+				return nil, err
+			}
+
+			return &AuthUser{Login: user.Login, Username: user.Username, AvatarURL: user.AvatarURL}, nil
+		})
+}
+
+// getJSON decodes into v the JSON response of a GET request to the given URL
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("can't get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't get %s: %s", url, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("can't parse the response of %s: %s", url, err)
+	}
+
+	return nil
+}
+
+// Name returns the name of the provider
+func (o *OAuth) Name() string {
+	return o.name
+}
+
+// Login redirects the user to the provider
+func (o *OAuth) Login(w http.ResponseWriter, r *http.Request) {
+	url := o.MakeAuthURL(w, r)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// Callback validates the state of the request sent back by the provider, and
+// returns the user for its code
+func (o *OAuth) Callback(r *http.Request) (*AuthUser, error) {
+	if err := o.ValidateState(r, r.FormValue("state")); err != nil {
+		return nil, ErrInvalidState
+	}
+
+	return o.GetUser(r.Context(), r.FormValue("code"))
+}
+
 // MakeAuthURL returns string for redirect to provider
 func (o *OAuth) MakeAuthURL(w http.ResponseWriter, r *http.Request) string {
 	b := make([]byte, 16)
@@ -73,22 +149,24 @@ func (o *OAuth) ValidateState(r *http.Request, state string) error {
 }
 
 // GetUser gets user from provider and return user model
-func (o *OAuth) GetUser(ctx context.Context, code string) (*githubUser, error) {
+func (o *OAuth) GetUser(ctx context.Context, code string) (*AuthUser, error) {
 	token, err := o.config.Exchange(ctx, code)
 	if err != nil {
 		return nil, fmt.Errorf("oauth exchange error: %s", err)
 	}
-	client := o.config.Client(ctx, token)
-	resp, err := client.Get("https://api.github.com/user")
-	if err != nil {
-		return nil, fmt.Errorf("can't get user from github: %s", err)
+
+	user, err := o.userInfo(o.config.Client(ctx, token))
+	if err == ErrNotAllowed {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var user githubUser
-	err = json.NewDecoder(resp.Body).Decode(&user)
+
 	if err != nil {
-		return nil, fmt.Errorf("can't parse github response: %s", err)
+		return nil, fmt.Errorf("can't get user from %s: %s", o.name, err)
+	}
+
+	if user.Login == "" {
+		return nil, fmt.Errorf("can't get user from %s: empty login", o.name)
 	}
 
-	return &user, nil
+	return user, nil
 }
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// oidcConfiguration is the part of the OpenID Connect discovery document used
+// to log in
+type oidcConfiguration struct {
+	Issuer           string   `json:"issuer"`
+	AuthorizationURL string   `json:"authorization_endpoint"`
+	TokenURL         string   `json:"token_endpoint"`
+	UserInfoURL      string   `json:"userinfo_endpoint"`
+	TokenAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcEmailClaim is only used as login when the provider verified the email
+const oidcEmailClaim = "email"
+
+// NewOIDC returns a new OAuth service that authenticates the users of the
+// OpenID Connect provider with the given issuer URL. Its endpoints are
+// discovered from the issuer. The login of the users is read from the
+// loginClaim of their user info. It must be unique and not chosen by the
+// users, or they could log in as others: "sub" always is, "email" is only
+// accepted if "email_verified" is true, and others as "preferred_username"
+// depend on the provider
+func NewOIDC(issuer, loginClaim string, conf OAuthConfig) (*OAuth, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	var discovered oidcConfiguration
+	if err := getJSON(http.DefaultClient, issuer+"/.well-known/openid-configuration", &discovered); err != nil {
+		return nil, fmt.Errorf("can't discover the OpenID Connect configuration: %s", err)
+	}
+
+	if strings.TrimSuffix(discovered.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("the OpenID Connect issuer %q does not match %q", discovered.Issuer, issuer)
+	}
+
+	if discovered.AuthorizationURL == "" || discovered.TokenURL == "" || discovered.UserInfoURL == "" {
+		return nil, fmt.Errorf("the OpenID Connect provider does not define the authorization, token and user info endpoints")
+	}
+
+	// by default the client secret is sent with HTTP basic authentication
+	if len(discovered.TokenAuthMethods) > 0 &&
+		!contains(discovered.TokenAuthMethods, "client_secret_basic") &&
+		contains(discovered.TokenAuthMethods, "client_secret_post") {
+		oauth2.RegisterBrokenAuthHeaderProvider(discovered.TokenURL)
+	}
+
+	endpoint := oauth2.Endpoint{
+		AuthURL:  discovered.AuthorizationURL,
+		TokenURL: discovered.TokenURL,
+	}
+
+	scopes := []string{"openid", "profile", "email"}
+	return newOAuth(OIDCProvider, endpoint, scopes, conf,
+		func(client *http.Client) (*AuthUser, error) {
+			var claims map[string]interface{}
+			if err := getJSON(client, discovered.UserInfoURL, &claims); err != nil {
+				return nil, err
+			}
+
+			login, _ := claims[loginClaim].(string)
+			if loginClaim == oidcEmailClaim && !emailVerified(claims) {
+				return nil, fmt.Errorf("the email %q is not verified", login)
+			}
+
+			name, _ := claims["name"].(string)
+			picture, _ := claims["picture"].(string)
+			return &AuthUser{Login: login, Username: name, AvatarURL: picture}, nil
+		}), nil
+}
+
+// emailVerified returns true if the email_verified claim is true. Some
+// providers send it as a string
+func emailVerified(claims map[string]interface{}) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+)
+
+// Names of the authentication providers, stored with the users
+const (
+	GitHubProvider = "github"
+	GitLabProvider = "gitlab"
+	OIDCProvider   = "oidc"
+	LocalProvider  = "local"
+	DevProvider    = "dev"
+)
+
+// ProviderConfig defines enviroment variables for the authentication provider
+type ProviderConfig struct {
+	Provider string `envconfig:"PROVIDER" default:"github"`
+	// GitHubOrgs are the organizations whose members can log in with GitHub;
+	// GitHubTeams are the teams, as "org/team:role", whose members can also
+	// log in, created with the given Role. If both are empty, any GitHub user can log in
+	GitHubOrgs  []string `envconfig:"GITHUB_ORGS"`
+	GitHubTeams []string `envconfig:"GITHUB_TEAMS"`
+	GitLabURL   string   `envconfig:"GITLAB_URL" default:"https://gitlab.com"`
+	// OIDCIssuer is the URL of the OpenID Connect provider, where its
+	// configuration is discovered; OIDCLoginClaim is the claim of the user
+	// info used as login, see NewOIDC
+	OIDCIssuer     string `envconfig:"OIDC_ISSUER"`
+	OIDCLoginClaim string `envconfig:"OIDC_LOGIN_CLAIM" default:"sub"`
+	// DevEnabled must be set to use the dev provider, that does not
+	// authenticate the users; DevUsers are the users that can log in with it
+	DevEnabled bool     `envconfig:"DEV_ENABLED"`
+	DevUsers   []string `envconfig:"DEV_USERS"`
+}
+
+// AuthUser is a user authenticated by a Provider
+type AuthUser struct {
+	Login     string
+	Username  string
+	AvatarURL string
+	// Role is the Role given to the user by the provider when it is created;
+	// if it is empty, the Role is decided by Access
+	Role model.Role
+}
+
+// Provider authenticates the users that log in
+type Provider interface {
+	// Name identifies the provider; it is stored with the users
+	Name() string
+	// Login writes the response that starts the log in, a redirect to the
+	// provider or a form
+	Login(w http.ResponseWriter, r *http.Request)
+	// Callback returns the user authenticated by the request that finishes
+	// the log in
+	Callback(r *http.Request) (*AuthUser, error)
+}
+
+var (
+	// ErrInvalidState is returned by the Callback of a Provider when the
+	// request does not come from the log in started by the user
+	ErrInvalidState = fmt.Errorf("invalid state")
+	// ErrInvalidCredentials is returned by the Callback of a Provider when
+	// the user can not be authenticated
+	ErrInvalidCredentials = fmt.Errorf("invalid login or password")
+	// ErrNotAllowed is returned by the Callback of a Provider when the user
+	// is authenticated, but not allowed to log in
+	ErrNotAllowed = fmt.Errorf("user is not allowed to log in")
+)
+
+// NewProvider returns the Provider chosen in the configuration. The OAuth
+// configuration is used by the GitHub, GitLab and OpenID Connect providers,
+// and users by the local one. The dev provider is refused unless DevEnabled
+// is set
+func NewProvider(conf ProviderConfig, oauthConf OAuthConfig, users repository.UserStore) (Provider, error) {
+	switch conf.Provider {
+	case LocalProvider:
+		return NewLocal(users), nil
+	case DevProvider:
+		if !conf.DevEnabled {
+			return nil, fmt.Errorf("the %s provider lets anyone log in, it must be explicitly enabled", conf.Provider)
+		}
+
+		return NewDev(conf.DevUsers)
+	}
+
+	if oauthConf.ClientID == "" || oauthConf.ClientSecret == "" {
+		return nil, fmt.Errorf("the %s provider requires an OAuth client ID and secret", conf.Provider)
+	}
+
+	switch conf.Provider {
+	case GitHubProvider:
+		return NewGitHub(oauthConf, conf.GitHubOrgs, conf.GitHubTeams)
+	case GitLabProvider:
+		return NewGitLab(conf.GitLabURL, oauthConf), nil
+	case OIDCProvider:
+		if conf.OIDCIssuer == "" {
+			return nil, fmt.Errorf("the %s provider requires an issuer URL", conf.Provider)
+		}
+
+		return NewOIDC(conf.OIDCIssuer, conf.OIDCLoginClaim, oauthConf)
+	default:
+		return nil, fmt.Errorf("Wrong authentication provider: %q", conf.Provider)
+	}
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/src-d/code-annotation/server/model"
+	"github.com/src-d/code-annotation/server/repository"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProviderSuite struct {
+	suite.Suite
+	oauthConf OAuthConfig
+}
+
+func (suite *ProviderSuite) SetupTest() {
+	suite.oauthConf = OAuthConfig{ClientID: "id", ClientSecret: "secret"}
+}
+
+// fakeProvider returns a server with the token endpoint of an OAuth provider
+// at /token, that accepts the code "good", and the given user info at
+// userPath, that requires its access token
+func (suite *ProviderSuite) fakeProvider(userPath string, userInfo interface{}) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "token_type": "bearer"}`))
+	})
+	mux.HandleFunc(userPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(userInfo)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+// logIn goes through the log in with the provider, and returns the user of
+// its callback with the given code
+func (suite *ProviderSuite) logIn(p Provider, code string) (*AuthUser, error) {
+	w := httptest.NewRecorder()
+	p.Login(w, httptest.NewRequest("GET", "/login", nil))
+	suite.Require().Equal(http.StatusTemporaryRedirect, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	suite.Require().NoError(err)
+
+	query := url.Values{"state": {location.Query().Get("state")}, "code": {code}}
+	r := httptest.NewRequest("GET", "/oauth-callback?"+query.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	return p.Callback(r)
+}
+
+func (suite *ProviderSuite) TestNewProvider() {
+	_, err := NewProvider(ProviderConfig{Provider: GitHubProvider}, OAuthConfig{}, nil)
+	suite.Error(err)
+
+	_, err = NewProvider(ProviderConfig{Provider: "facebook"}, suite.oauthConf, nil)
+	suite.Error(err)
+
+	_, err = NewProvider(ProviderConfig{Provider: OIDCProvider}, suite.oauthConf, nil)
+	suite.Error(err)
+
+	p, err := NewProvider(ProviderConfig{Provider: GitHubProvider}, suite.oauthConf, nil)
+	suite.Require().NoError(err)
+	suite.Equal(GitHubProvider, p.Name())
+
+	p, err = NewProvider(ProviderConfig{Provider: LocalProvider}, OAuthConfig{}, nil)
+	suite.Require().NoError(err)
+	suite.Equal(LocalProvider, p.Name())
+
+	// the dev provider must be explicitly enabled
+	_, err = NewProvider(ProviderConfig{Provider: DevProvider}, suite.oauthConf, nil)
+	suite.Error(err)
+
+	_, err = NewProvider(ProviderConfig{Provider: DevProvider, DevEnabled: true,
+		DevUsers: []string{"alice:admin"}}, OAuthConfig{}, nil)
+	suite.Error(err)
+
+	p, err = NewProvider(ProviderConfig{Provider: DevProvider, DevEnabled: true}, OAuthConfig{}, nil)
+	suite.Require().NoError(err)
+	suite.Equal(DevProvider, p.Name())
+}
+
+func (suite *ProviderSuite) TestGitLab() {
+	server := suite.fakeProvider("/api/v4/user",
+		map[string]string{"username": "alice", "name": "Alice", "avatar_url": "http://avatar"})
+	defer server.Close()
+
+	p := NewGitLab(server.URL+"/", suite.oauthConf)
+	p.config.Endpoint.TokenURL = server.URL + "/token"
+	suite.Equal(GitLabProvider, p.Name())
+
+	user, err := suite.logIn(p, "good")
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "alice", Username: "Alice", AvatarURL: "http://avatar"}, user)
+
+	_, err = suite.logIn(p, "bad")
+	suite.Error(err)
+
+	_, err = p.Callback(httptest.NewRequest("GET", "/oauth-callback?state=forged&code=good", nil))
+	suite.Equal(ErrInvalidState, err)
+}
+
+func (suite *ProviderSuite) TestOIDC() {
+	userInfo := map[string]interface{}{"sub": "1234", "preferred_username": "alice",
+		"name": "Alice", "email": "alice@example.com"}
+	server := suite.fakeProvider("/userinfo", userInfo)
+	defer server.Close()
+
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/.well-known/openid-configuration", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "http://" + r.Host,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	}))
+	defer discovery.Close()
+
+	p, err := NewOIDC(discovery.URL+"/", "preferred_username", suite.oauthConf)
+	suite.Require().NoError(err)
+	suite.Equal(OIDCProvider, p.Name())
+	suite.True(strings.HasPrefix(p.config.AuthCodeURL("state"), server.URL+"/authorize?"))
+
+	user, err := suite.logIn(p, "good")
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "alice", Username: "Alice"}, user)
+
+	p, err = NewOIDC(discovery.URL, "sub", suite.oauthConf)
+	suite.Require().NoError(err)
+	user, err = suite.logIn(p, "good")
+	suite.Require().NoError(err)
+	suite.Equal("1234", user.Login)
+
+	p, err = NewOIDC(discovery.URL, "nickname", suite.oauthConf)
+	suite.Require().NoError(err)
+	_, err = suite.logIn(p, "good")
+	suite.Error(err)
+
+	// the emails are only used as login once they are verified
+	p, err = NewOIDC(discovery.URL, "email", suite.oauthConf)
+	suite.Require().NoError(err)
+	for _, verified := range []interface{}{nil, false, "false"} {
+		userInfo["email_verified"] = verified
+		_, err = suite.logIn(p, "good")
+		suite.Error(err, "%v", verified)
+	}
+
+	for _, verified := range []interface{}{true, "true"} {
+		userInfo["email_verified"] = verified
+		user, err = suite.logIn(p, "good")
+		suite.Require().NoError(err)
+		suite.Equal("alice@example.com", user.Login)
+	}
+
+	// the discovered issuer must match the configured one
+	_, err = NewOIDC(server.URL, "sub", suite.oauthConf)
+	suite.Error(err)
+}
+
+func (suite *ProviderSuite) TestLocal() {
+	hash, err := HashPassword("secret")
+	suite.Require().NoError(err)
+
+	users := repository.NewMemory().Users()
+	suite.Require().NoError(users.Create(&model.User{Login: "alice", Username: "Alice",
+		Provider: LocalProvider, PasswordHash: hash}))
+	suite.Require().NoError(users.Create(&model.User{Login: "bob",
+		Provider: GitHubProvider, PasswordHash: hash}))
+	suite.Require().NoError(users.Create(&model.User{Login: "carol",
+		Provider: LocalProvider}))
+
+	p := NewLocal(users)
+
+	w := httptest.NewRecorder()
+	p.Login(w, httptest.NewRequest("GET", "/login", nil))
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `<form method="post">`)
+
+	logIn := func(login, password string) (*AuthUser, error) {
+		return postLogin(p, url.Values{"login": {login}, "password": {password}})
+	}
+
+	user, err := logIn("alice", "secret")
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "alice", Username: "Alice"}, user)
+
+	for _, c := range [][2]string{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"bob", "secret"},
+		{"carol", ""},
+		{"carol", "secret"},
+		{"dave", "secret"},
+	} {
+		_, err := logIn(c[0], c[1])
+		suite.Equal(ErrInvalidCredentials, err, "%s:%s", c[0], c[1])
+	}
+}
+
+func (suite *ProviderSuite) TestGitHubMembership() {
+	team := func(org, slug string) interface{} {
+		return map[string]interface{}{"slug": slug, "organization": map[string]string{"login": org}}
+	}
+	teams := map[string][]interface{}{
+		"alice": {
+			team("src-d", "annotators"),
+			team("src-d", "ML-Research"),
+		},
+		"bob": {
+			team("src-d", "annotators"),
+			team("other", "ml-research"),
+		},
+	}
+	// carol is in the second page of organizations
+	orgs := map[string][]interface{}{"carol": {}}
+	for i := 0; i < githubPageSize; i++ {
+		orgs["carol"] = append(orgs["carol"], map[string]string{"login": fmt.Sprintf("org-%d", i)})
+	}
+	orgs["carol"] = append(orgs["carol"], map[string]string{"login": "Src-D"})
+
+	// the code is the login of the user
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": r.FormValue("code"), "token_type": "bearer"})
+	})
+	login := func(r *http.Request) string {
+		return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"login": login(r)})
+	})
+	page := func(w http.ResponseWriter, r *http.Request, items []interface{}) {
+		suite.Equal(strconv.Itoa(githubPageSize), r.FormValue("per_page"))
+		n, _ := strconv.Atoi(r.FormValue("page"))
+		pageItems := []interface{}{}
+		for i := (n - 1) * githubPageSize; i < n*githubPageSize && i < len(items); i++ {
+			pageItems = append(pageItems, items[i])
+		}
+
+		json.NewEncoder(w).Encode(pageItems)
+	}
+	mux.HandleFunc("/user/teams", func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, teams[login(r)])
+	})
+	mux.HandleFunc("/user/orgs", func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, orgs[login(r)])
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	defaultAPIURL := githubAPIURL
+	githubAPIURL = server.URL
+	defer func() { githubAPIURL = defaultAPIURL }()
+
+	github := func(orgs []string, teams []string) *OAuth {
+		p, err := NewGitHub(suite.oauthConf, orgs, teams)
+		suite.Require().NoError(err)
+		p.config.Endpoint.TokenURL = server.URL + "/token"
+		return p
+	}
+
+	// without organizations nor teams anyone can log in
+	p := github(nil, nil)
+	suite.Equal([]string{"read:user"}, p.config.Scopes)
+	user, err := suite.logIn(p, "eve")
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "eve"}, user)
+
+	p = github([]string{"src-d"}, []string{"src-d/ml-research:requester", " src-d/annotators:worker", ""})
+	suite.Equal([]string{"read:user", "read:org"}, p.config.Scopes)
+
+	for login, role := range map[string]model.Role{
+		"alice": model.Requester,
+		"bob":   model.Worker,
+		"carol": model.Worker,
+	} {
+		user, err := suite.logIn(p, login)
+		suite.Require().NoError(err, login)
+		suite.Equal(role, user.Role, login)
+	}
+
+	_, err = suite.logIn(p, "eve")
+	suite.Equal(ErrNotAllowed, err)
+
+	// the members of the organizations get the role from Access without teams
+	p = github([]string{"src-d"}, nil)
+	user, err = suite.logIn(p, "carol")
+	suite.Require().NoError(err)
+	suite.Equal(model.Role(""), user.Role)
+
+	_, err = suite.logIn(p, "alice")
+	suite.Equal(ErrNotAllowed, err)
+
+	for _, team := range []string{"ml-research:requester", "src-d/ml-research", "src-d/ml-research:admin"} {
+		_, err := NewGitHub(suite.oauthConf, nil, []string{team})
+		suite.Error(err, team)
+	}
+}
+
+// postLogin posts the given form values to the Callback of the provider
+func postLogin(p Provider, form url.Values) (*AuthUser, error) {
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return p.Callback(r)
+}
+
+func (suite *ProviderSuite) TestDev() {
+	p, err := NewDev([]string{"alice:requester", " bob", ""})
+	suite.Require().NoError(err)
+
+	w := httptest.NewRecorder()
+	p.Login(w, httptest.NewRequest("GET", "/login", nil))
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `value="alice"`)
+	suite.Contains(w.Body.String(), `value="bob"`)
+
+	user, err := postLogin(p, url.Values{"login": {"alice"}})
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "alice", Username: "alice", Role: model.Requester}, user)
+
+	user, err = postLogin(p, url.Values{"login": {"bob"}})
+	suite.Require().NoError(err)
+	suite.Equal(&AuthUser{Login: "bob", Username: "bob"}, user)
+
+	_, err = postLogin(p, url.Values{"login": {"eve"}})
+	suite.Equal(ErrInvalidCredentials, err)
+
+	// without users, anyone can log in
+	p, err = NewDev(nil)
+	suite.Require().NoError(err)
+
+	user, err = postLogin(p, url.Values{"login": {"eve"}})
+	suite.Require().NoError(err)
+	suite.Equal("eve", user.Login)
+
+	_, err = postLogin(p, url.Values{"login": {" "}})
+	suite.Equal(ErrInvalidCredentials, err)
+}
+
+func TestProvider(t *testing.T) {
+	suite.Run(t, new(ProviderSuite))
+}
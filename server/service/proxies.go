@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies service finds the address of the clients behind the reverse
+// proxies trusted to set the X-Forwarded-For header. The header is ignored in
+// the requests coming from any other address, as the clients can set it
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// NewTrustedProxies returns a new TrustedProxies service for the given IPs
+// or CIDR ranges, ignoring the empty ones
+func NewTrustedProxies(proxies []string) (*TrustedProxies, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("Wrong trusted proxy: %q", p)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong trusted proxy: %q", p)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return &TrustedProxies{nets: nets}, nil
+}
+
+// trusted returns true if the given address belongs to a trusted proxy
+func (p *TrustedProxies) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address of the client of the request. When the request
+// comes from a trusted proxy, it is the last address of X-Forwarded-For that
+// was not added by a trusted proxy
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !p.trusted(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+
+		ip = addr
+		if !p.trusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Middleware sets the RemoteAddr of the requests to their ClientIP
+func (p *TrustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(p.nets) > 0 {
+			r.RemoteAddr = p.ClientIP(r)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TrustedProxiesSuite struct {
+	suite.Suite
+}
+
+func (suite *TrustedProxiesSuite) TestWrongProxy() {
+	for _, p := range []string{"proxy", "10.0.0.1/33", "10.0.0"} {
+		_, err := NewTrustedProxies([]string{p})
+		suite.Error(err, p)
+	}
+}
+
+func (suite *TrustedProxiesSuite) TestClientIP() {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.1", " 192.168.0.0/16", "", "::1"})
+	suite.Require().NoError(err)
+
+	cases := []struct {
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		// the header of untrusted clients is ignored
+		{"1.2.3.4:5678", []string{"5.6.7.8"}, "1.2.3.4"},
+		{"10.0.0.2:5678", []string{"5.6.7.8"}, "10.0.0.2"},
+		{"10.0.0.1:5678", nil, "10.0.0.1"},
+		{"10.0.0.1:5678", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"[::1]:5678", []string{"5.6.7.8"}, "5.6.7.8"},
+		// the addresses added by trusted proxies are skipped
+		{"10.0.0.1:5678", []string{"6.6.6.6, 5.6.7.8, 192.168.1.1"}, "5.6.7.8"},
+		{"10.0.0.1:5678", []string{"6.6.6.6", "5.6.7.8", "192.168.1.1"}, "5.6.7.8"},
+		{"10.0.0.1:5678", []string{"192.168.1.2, 192.168.1.1"}, "192.168.1.2"},
+		// forged values are not trusted
+		{"10.0.0.1:5678", []string{"6.6.6.6, unknown, 5.6.7.8"}, "5.6.7.8"},
+		{"10.0.0.1:5678", []string{"unknown"}, "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for _, f := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+
+		suite.Equal(c.expected, proxies.ClientIP(r), "%+v", c)
+	}
+}
+
+func (suite *TrustedProxiesSuite) TestNoProxies() {
+	proxies, err := NewTrustedProxies(nil)
+	suite.Require().NoError(err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:5678"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	suite.Equal("127.0.0.1", proxies.ClientIP(r))
+}
+
+func TestTrustedProxies(t *testing.T) {
+	suite.Run(t, new(TrustedProxiesSuite))
+}
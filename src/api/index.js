@@ -7,10 +7,6 @@ export const serverUrl =
 const apiUrl = url => `${serverUrl}${url}`;
 
 function checkStatus(resp) {
-  // when server return Unauthorized we need to remove token
-  if (resp.status === 401) {
-    TokenService.remove();
-  }
   if (resp.status < 200 || resp.status >= 300) {
     const error = new Error(resp.statusText);
     error.response = resp;
@@ -45,7 +41,7 @@ function normalizeErrors(err) {
   return [normalizeError(err)];
 }
 
-function apiCall(url, options = {}) {
+function authFetch(url, options = {}) {
   const token = TokenService.get();
 
   return fetch(apiUrl(url), {
@@ -54,7 +50,61 @@ function apiCall(url, options = {}) {
       ...options.headers,
       Authorization: `Bearer ${token}`,
     },
-  })
+  });
+}
+
+// the server refreshes each token only once, so the concurrent requests share
+// the same refresh
+let refreshing = null;
+
+function refreshToken() {
+  if (!refreshing) {
+    refreshing = authFetch('/api/refresh', { method: 'POST' })
+      .then(checkStatus)
+      .then(resp => resp.json())
+      .then(json => TokenService.set(json.data.token))
+      .then(
+        () => {
+          refreshing = null;
+        },
+        err => {
+          refreshing = null;
+          throw err;
+        }
+      );
+  }
+
+  return refreshing;
+}
+
+// fetchWithRefresh makes the request, and when the token is expired it
+// refreshes it and repeats the request once. The token is removed if it can
+// not be refreshed, so the user has to log in again
+function fetchWithRefresh(url, options = {}) {
+  const token = TokenService.get();
+
+  return authFetch(url, options).then(resp => {
+    if (resp.status !== 401) {
+      return resp;
+    }
+
+    // another request may have refreshed the token meanwhile
+    const refreshed =
+      TokenService.get() !== token ? Promise.resolve() : refreshToken();
+
+    return refreshed
+      .then(() => authFetch(url, options), () => resp)
+      .then(retried => {
+        if (retried.status === 401) {
+          TokenService.remove();
+        }
+        return retried;
+      });
+  });
+}
+
+function apiCall(url, options = {}) {
+  return fetchWithRefresh(url, options)
     .then(checkStatus)
     .then(resp => resp.json())
     .then(json => {